@@ -0,0 +1,210 @@
+package anomaly
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+const (
+	defaultStallWindows        = 2
+	defaultBaselineWindows     = 30
+	defaultMinBaselineWindows  = 3
+	defaultDropRatio           = 0.3
+	defaultMinBaselineRate     = 16 * 1024
+	defaultFloodRatio          = 10
+	defaultFloodBytesPerSecond = 5 * 1024 * 1024
+	defaultDebounce            = time.Minute
+	defaultWebhookTimeout      = 5 * time.Second
+	defaultWebhookQueue        = 100
+	defaultWebhookDrainTimeout = 5 * time.Second
+)
+
+// Options 异常检测的参数，零值使用默认值
+type Options struct {
+	StallWindows        int           // 连续多少个统计周期下行为0认为卡顿
+	BaselineWindows     int           // 滚动基线包含的统计周期个数
+	MinBaselineWindows  int           // 基线至少包含多少个周期才检测下降和上行洪泛
+	DropRatio           float64       // 下行带宽低于基线的比例认为下降
+	MinBaselineRate     float64       // 基线低于该值(字节每秒)时不检测下降，避免空闲会话误报
+	FloodRatio          float64       // 上行带宽超过上行基线的倍数认为洪泛
+	FloodBytesPerSecond float64       // 上行带宽超过该值直接认为洪泛
+	Debounce            time.Duration // 同一个会话同一类异常的最小通知间隔
+}
+
+func (o *Options) withDefaults() Options {
+	opts := *o
+	if opts.StallWindows <= 0 {
+		opts.StallWindows = defaultStallWindows
+	}
+	if opts.BaselineWindows <= 0 {
+		opts.BaselineWindows = defaultBaselineWindows
+	}
+	if opts.MinBaselineWindows <= 0 {
+		opts.MinBaselineWindows = defaultMinBaselineWindows
+	}
+	if opts.MinBaselineWindows > opts.BaselineWindows {
+		opts.MinBaselineWindows = opts.BaselineWindows
+	}
+	if opts.DropRatio <= 0 {
+		opts.DropRatio = defaultDropRatio
+	}
+	if opts.MinBaselineRate <= 0 {
+		opts.MinBaselineRate = defaultMinBaselineRate
+	}
+	if opts.FloodRatio <= 0 {
+		opts.FloodRatio = defaultFloodRatio
+	}
+	if opts.FloodBytesPerSecond <= 0 {
+		opts.FloodBytesPerSecond = defaultFloodBytesPerSecond
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultDebounce
+	}
+	return opts
+}
+
+// Analyzer 异常检测，进程里共用一个，每个会话一个Detector
+type Analyzer struct {
+	opts  Options
+	sinks []Sink
+}
+
+func NewAnalyzer(opts Options, sinks ...Sink) *Analyzer {
+	return &Analyzer{opts: opts.withDefaults(), sinks: sinks}
+}
+
+// NewDetector 创建会话的检测器
+func (a *Analyzer) NewDetector(session *model.Session) *Detector {
+	return &Detector{
+		analyzer:  a,
+		session:   session,
+		downRates: make([]float64, 0, a.opts.BaselineWindows),
+		upRates:   make([]float64, 0, a.opts.BaselineWindows),
+		emitted:   map[EventType]int64{},
+	}
+}
+
+func (a *Analyzer) emit(ctx context.Context, event *Event) {
+	anomalyEvents.WithLabelValues(string(event.Type)).Inc()
+	for _, sink := range a.sinks {
+		if err := sink.Emit(ctx, event); err != nil {
+			anomalySinkFailures.WithLabelValues(string(event.Type)).Inc()
+			log.Warnf("emit bandwidth anomaly %s failure err:%v", event.Type, err)
+		}
+	}
+}
+
+// Sample 一个统计周期内会话串流的流量
+type Sample struct {
+	Start int64
+	End   int64
+	Up    int64
+	Down  int64
+}
+
+// Detector 一个会话的异常检测器，保存滚动基线和卡顿状态
+type Detector struct {
+	analyzer   *Analyzer
+	session    *model.Session
+	mutex      sync.Mutex
+	downRates  []float64 // 最近BaselineWindows个周期的下行带宽，环形使用
+	upRates    []float64
+	next       int
+	streaming  bool  // 出现过下行流量，之前的0流量是串流还没开始，不算卡顿
+	zeroCount  int   // 连续下行为0的周期数
+	stallStart int64 // 开始卡顿的时间
+	emitted    map[EventType]int64
+}
+
+// Observe 检测一个统计周期的流量，返回本次发出的异常
+func (d *Detector) Observe(ctx context.Context, sample Sample) []*Event {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	opts := d.analyzer.opts
+	seconds := sample.End - sample.Start
+	if seconds <= 0 {
+		return nil
+	}
+	upRate, downRate := float64(sample.Up)/float64(seconds), float64(sample.Down)/float64(seconds)
+	var events []*Event
+	if sample.Down == 0 {
+		if d.streaming {
+			if d.zeroCount == 0 {
+				d.stallStart = sample.Start
+			}
+			d.zeroCount++
+			if d.zeroCount >= opts.StallWindows {
+				event := d.newEvent(EventStall, sample.End, upRate, downRate, mean(d.downRates))
+				event.Duration = sample.End - d.stallStart
+				events = append(events, event)
+			}
+		}
+	} else {
+		d.streaming = true
+		d.zeroCount = 0
+		if len(d.downRates) >= opts.MinBaselineWindows {
+			if baseline := mean(d.downRates); baseline >= opts.MinBaselineRate && downRate < baseline*opts.DropRatio {
+				events = append(events, d.newEvent(EventDrop, sample.End, upRate, downRate, baseline))
+			}
+		}
+	}
+	upBaseline := mean(d.upRates)
+	if upRate > opts.FloodBytesPerSecond ||
+		(len(d.upRates) >= opts.MinBaselineWindows && upBaseline > 0 && upRate > upBaseline*opts.FloodRatio && upRate > opts.MinBaselineRate) {
+		events = append(events, d.newEvent(EventUpstreamFlood, sample.End, upRate, downRate, upBaseline))
+	}
+	// 卡顿的周期不计入基线，避免卡顿拉低基线后恢复时检测不到下降
+	if sample.Down > 0 {
+		d.record(upRate, downRate)
+	}
+	var emitted []*Event
+	for _, event := range events {
+		if last, ok := d.emitted[event.Type]; ok && event.Time-last < int64(opts.Debounce/time.Second) {
+			continue
+		}
+		d.emitted[event.Type] = event.Time
+		d.analyzer.emit(ctx, event)
+		emitted = append(emitted, event)
+	}
+	return emitted
+}
+
+func (d *Detector) record(upRate, downRate float64) {
+	if len(d.downRates) < cap(d.downRates) {
+		d.downRates = append(d.downRates, downRate)
+		d.upRates = append(d.upRates, upRate)
+		return
+	}
+	d.downRates[d.next] = downRate
+	d.upRates[d.next] = upRate
+	d.next = (d.next + 1) % len(d.downRates)
+}
+
+func (d *Detector) newEvent(eventType EventType, now int64, upRate, downRate, baseline float64) *Event {
+	return &Event{
+		Type:       eventType,
+		SessionKey: d.session.SessionKey(),
+		FlowID:     d.session.FlowID,
+		GID:        d.session.GID,
+		VMid:       d.session.VMid,
+		Time:       now,
+		UpRate:     upRate,
+		DownRate:   downRate,
+		Baseline:   baseline,
+	}
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,183 @@
+package anomaly
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func observe(d *Detector, now *int64, up, down int64) []EventType {
+	start := *now
+	*now += 10
+	var types []EventType
+	for _, event := range d.Observe(context.Background(), Sample{Start: start, End: *now, Up: up, Down: down}) {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestDetectorStall(t *testing.T) {
+	sink := make(ChannelSink, 10)
+	analyzer := NewAnalyzer(Options{Debounce: 30 * time.Second}, sink)
+	d := analyzer.NewDetector(&model.Session{InstanceId: "i-1", VMid: 7, FlowID: "f"})
+	now := int64(1000)
+	// 串流开始之前没有下行流量不算卡顿
+	for i := 0; i < 3; i++ {
+		if got := observe(d, &now, 0, 0); len(got) != 0 {
+			t.Fatalf("unexpected events before streaming %v", got)
+		}
+	}
+	observe(d, &now, 100, 1000000)
+	if got := observe(d, &now, 0, 0); len(got) != 0 {
+		t.Fatalf("one zero window should not be a stall, got %v", got)
+	}
+	if got := fmt.Sprint(observe(d, &now, 0, 0)); got != "[stall]" {
+		t.Fatalf("expected stall, got %s", got)
+	}
+	// 防抖时间内不重复通知
+	if got := observe(d, &now, 0, 0); len(got) != 0 {
+		t.Fatalf("expected stall to be debounced, got %v", got)
+	}
+	observe(d, &now, 0, 0)
+	if got := fmt.Sprint(observe(d, &now, 0, 0)); got != "[stall]" {
+		t.Fatalf("expected stall after debounce, got %s", got)
+	}
+	if len(sink) != 2 {
+		t.Fatalf("expected 2 events in channel, got %d", len(sink))
+	}
+	event := <-sink
+	if event.SessionKey != "i-1-7" || event.Duration != 20 {
+		t.Fatalf("unexpected stall event %+v", event)
+	}
+}
+
+func TestDetectorDropAndFlood(t *testing.T) {
+	analyzer := NewAnalyzer(Options{})
+	d := analyzer.NewDetector(&model.Session{InstanceId: "i-1"})
+	now := int64(1000)
+	for i := 0; i < 5; i++ {
+		if got := observe(d, &now, 10000, 10000000); len(got) != 0 {
+			t.Fatalf("unexpected events on steady traffic %v", got)
+		}
+	}
+	if got := fmt.Sprint(observe(d, &now, 10000, 1000000)); got != "[drop]" {
+		t.Fatalf("expected drop, got %s", got)
+	}
+	if got := fmt.Sprint(observe(d, &now, 5000000, 10000000)); got != "[upstream_flood]" {
+		t.Fatalf("expected upstream flood against baseline, got %s", got)
+	}
+	d = analyzer.NewDetector(&model.Session{InstanceId: "i-2"})
+	if got := fmt.Sprint(observe(d, &now, 100000000, 10000000)); got != "[upstream_flood]" {
+		t.Fatalf("expected upstream flood over absolute limit, got %s", got)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	events := make(chan *Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &Event{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events <- event
+	}))
+	defer server.Close()
+	sink := NewWebhookSink(server.URL, time.Second)
+	if err := sink.Emit(context.Background(), &Event{Type: EventDrop, FlowID: "f"}); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-events; event.Type != EventDrop || event.FlowID != "f" {
+		t.Fatalf("unexpected webhook event %+v", event)
+	}
+	if err := NewWebhookSink(server.URL+"/missing\x7f", time.Second).post(context.Background(), &Event{}); err == nil {
+		t.Fatalf("expected bad url to fail")
+	}
+}
+
+func TestWebhookSinkQueueFull(t *testing.T) {
+	// 没有启动发送goroutine，队列满后丢弃
+	sink := &WebhookSink{queue: make(chan *Event, 1)}
+	before := testutil.ToFloat64(anomalyWebhookDropped)
+	if err := sink.Emit(context.Background(), &Event{Type: EventStall}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Emit(context.Background(), &Event{Type: EventStall}); err == nil {
+		t.Fatal("expected full queue to drop the event")
+	}
+	if got := testutil.ToFloat64(anomalyWebhookDropped); got != before+1 {
+		t.Fatalf("expected dropped counter to be %v, got %v", before+1, got)
+	}
+}
+
+func TestWebhookSinkClose(t *testing.T) {
+	var mutex sync.Mutex
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		received++
+		mutex.Unlock()
+	}))
+	defer server.Close()
+	sink := NewWebhookSink(server.URL, time.Second)
+	for i := 0; i < 3; i++ {
+		if err := sink.Emit(context.Background(), &Event{Type: EventDrop}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close等待队列里的事件发送完
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if received != 3 {
+		t.Fatalf("expected queued events to be sent, got %d", received)
+	}
+	if err := sink.Emit(context.Background(), &Event{Type: EventDrop}); err == nil {
+		t.Fatal("expected closed sink to reject events")
+	}
+}
+
+func TestWebhookSinkCloseDeadline(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	sink := NewWebhookSink(server.URL, time.Minute)
+	for i := 0; i < 3; i++ {
+		if err := sink.Emit(context.Background(), &Event{Type: EventStall}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-started
+	before := testutil.ToFloat64(anomalyWebhookDropped)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := sink.Close(ctx); err == nil {
+		t.Fatal("expected close to time out")
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("expected close to give up after the deadline, took %v", elapsed)
+	}
+	// 正在发送的事件被取消，剩余的事件丢弃
+	if got := testutil.ToFloat64(anomalyWebhookDropped); got != before+2 {
+		t.Fatalf("expected dropped counter to be %v, got %v", before+2, got)
+	}
+}
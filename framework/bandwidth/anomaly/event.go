@@ -0,0 +1,41 @@
+package anomaly
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType 异常类型
+type EventType string
+
+const (
+	EventStall         EventType = "stall"          // 会话运行中下行流量为0
+	EventDrop          EventType = "drop"           // 下行带宽相比滚动基线突然下降
+	EventUpstreamFlood EventType = "upstream_flood" // 上行流量异常增大
+)
+
+// Event 一次异常，带宽单位是字节每秒
+type Event struct {
+	Type       EventType `json:"type"`
+	SessionKey string    `json:"session_key"`
+	FlowID     string    `json:"flow_id"`
+	GID        int64     `json:"gid"`
+	VMid       int64     `json:"vmid"`
+	Time       int64     `json:"time"`
+	UpRate     float64   `json:"up_rate"`
+	DownRate   float64   `json:"down_rate"`
+	Baseline   float64   `json:"baseline"`
+	Duration   int64     `json:"duration,omitempty"` // 卡顿持续的秒数
+}
+
+func (e *Event) String() string {
+	switch e.Type {
+	case EventStall:
+		return fmt.Sprintf("session[%s] flowId %s downstream stalled for %ds", e.SessionKey, e.FlowID, e.Duration)
+	case EventDrop:
+		return fmt.Sprintf("session[%s] flowId %s downstream dropped to %.0fB/s, baseline %.0fB/s", e.SessionKey, e.FlowID, e.DownRate, e.Baseline)
+	case EventUpstreamFlood:
+		return fmt.Sprintf("session[%s] flowId %s upstream flood %.0fB/s, baseline %.0fB/s", e.SessionKey, e.FlowID, e.UpRate, e.Baseline)
+	}
+	return fmt.Sprintf("session[%s] flowId %s %s at %s", e.SessionKey, e.FlowID, e.Type, time.Unix(e.Time, 0))
+}
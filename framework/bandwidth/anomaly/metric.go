@@ -0,0 +1,27 @@
+package anomaly
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	anomalyEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "anomaly_events_total",
+		Help:      "number of bandwidth anomaly events emitted",
+	}, []string{"type"})
+	anomalySinkFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "anomaly_sink_failures_total",
+		Help:      "number of anomaly events failed to deliver",
+	}, []string{"type"})
+	anomalyWebhookDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "anomaly_webhook_dropped_total",
+		Help:      "number of anomaly events dropped because the webhook queue is full",
+	})
+)
@@ -0,0 +1,140 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+// Sink 异常事件的接收者
+type Sink interface {
+	Emit(ctx context.Context, event *Event) error
+}
+
+// LogSink 把异常事件打印到日志
+type LogSink struct{}
+
+func (LogSink) Emit(ctx context.Context, event *Event) error {
+	log.Warnf("bandwidth anomaly: %s", event.String())
+	return nil
+}
+
+// ChannelSink 把异常事件发送到channel，channel满的时候丢弃事件，不阻塞统计
+type ChannelSink chan *Event
+
+func (c ChannelSink) Emit(ctx context.Context, event *Event) error {
+	select {
+	case c <- event:
+		return nil
+	default:
+		return fmt.Errorf("anomaly channel full, drop %s event", event.Type)
+	}
+}
+
+// WebhookSink 把异常事件以json格式POST到url，事件先放进有界队列，由后台goroutine发送，不阻塞统计，
+// 队列满的时候丢弃事件，Close之后不再接收事件
+type WebhookSink struct {
+	url    string
+	client *http.Client
+	queue  chan *Event
+	mutex  sync.RWMutex
+	closed bool
+	ctx    context.Context // Close超时后取消，放弃正在发送和还没发送的事件
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	s := &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan *Event, defaultWebhookQueue),
+		done:   make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.loop()
+	return s
+}
+
+func (s *WebhookSink) Emit(ctx context.Context, event *Event) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return fmt.Errorf("webhook sink closed, drop %s event", event.Type)
+	}
+	select {
+	case s.queue <- event:
+		return nil
+	default:
+		anomalyWebhookDropped.Inc()
+		return fmt.Errorf("webhook queue full, drop %s event", event.Type)
+	}
+}
+
+// Close 不再接收新事件，等待队列里的事件发送完，最多等待ctx的超时和defaultWebhookDrainTimeout中较早的一个，
+// 超时后放弃剩余的事件并计入anomaly_webhook_dropped_total
+func (s *WebhookSink) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mutex.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, defaultWebhookDrainTimeout)
+	defer cancel()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-s.done
+		return fmt.Errorf("drain webhook queue err:%w", ctx.Err())
+	}
+}
+
+func (s *WebhookSink) loop() {
+	defer close(s.done)
+	defer s.cancel()
+	for event := range s.queue {
+		if s.ctx.Err() != nil {
+			anomalyWebhookDropped.Inc()
+			continue
+		}
+		if err := s.post(s.ctx, event); err != nil {
+			anomalySinkFailures.WithLabelValues(string(event.Type)).Inc()
+			log.Warnf("post bandwidth anomaly %s to webhook failure err:%v", event.Type, err)
+		}
+	}
+}
+
+func (s *WebhookSink) post(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook %s failure status %d body:%s", s.url, resp.StatusCode, respBody)
+	}
+	return nil
+}
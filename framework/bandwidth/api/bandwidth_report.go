@@ -1,6 +1,7 @@
 package api
 
 import (
+	"accumulation/framework/bandwidth/conf"
 	"accumulation/framework/bandwidth/model"
 	"context"
 )
@@ -13,4 +14,12 @@ type BandwidthReportManager interface {
 	RemoveTask(ctx context.Context, session *model.Session) error
 
 	NotifyAccessInfo(ctx context.Context, vmid int64, streamIp string, streamPort model.StreamPorts) error
+
+	ApplyConfig(ctx context.Context, reportConfig *conf.Acl_ReportConfig) error
+
+	QueryBandwidth(ctx context.Context, filter model.Filters, startTime, endTime int64) ([]*model.Bandwidth, error)
+
+	StreamEndpoint(sessionKey string) (*model.StreamEndpoint, bool)
+
+	Close(ctx context.Context) error
 }
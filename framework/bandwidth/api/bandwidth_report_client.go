@@ -1,6 +1,12 @@
 package api
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidReport 上报服务明确拒绝的请求，重试也不会成功，client返回的错误需要包装该错误
+var ErrInvalidReport = errors.New("invalid report request")
 
 type BandwidthReportClient interface {
 	ReportBandwidthData(ctx context.Context, data interface{}) error
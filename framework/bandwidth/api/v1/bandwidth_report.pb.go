@@ -0,0 +1,421 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.2
+// 	protoc        (unknown)
+// source: v1/bandwidth_report.proto
+
+package v1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ReportFlowBizRequest 一个会话在一个统计周期内的流量
+type ReportFlowBizRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ReportId      string                 `protobuf:"bytes,1,opt,name=report_id,json=reportId,proto3" json:"report_id,omitempty"`
+	FlowId        string                 `protobuf:"bytes,2,opt,name=flow_id,json=flowId,proto3" json:"flow_id,omitempty"`
+	BizId         int64                  `protobuf:"varint,3,opt,name=biz_id,json=bizId,proto3" json:"biz_id,omitempty"`
+	Gid           int64                  `protobuf:"varint,4,opt,name=gid,proto3" json:"gid,omitempty"`
+	Uuid          string                 `protobuf:"bytes,5,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Vmid          int64                  `protobuf:"varint,6,opt,name=vmid,proto3" json:"vmid,omitempty"`
+	AreaType      int32                  `protobuf:"varint,7,opt,name=area_type,json=areaType,proto3" json:"area_type,omitempty"`
+	InstanceId    string                 `protobuf:"bytes,8,opt,name=instance_id,json=instanceId,proto3" json:"instance_id,omitempty"`   //实例ID
+	Idc           string                 `protobuf:"bytes,9,opt,name=idc,proto3" json:"idc,omitempty"`                                   //机房
+	No            int32                  `protobuf:"varint,10,opt,name=no,proto3" json:"no,omitempty"`                                   //会话内的上报序号
+	StartTime     int64                  `protobuf:"varint,11,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`    //统计周期开始时间，unix秒
+	EndTime       int64                  `protobuf:"varint,12,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`          //统计周期结束时间，unix秒
+	UpTotal       int64                  `protobuf:"varint,13,opt,name=up_total,json=upTotal,proto3" json:"up_total,omitempty"`          //上行总流量，字节
+	DownTotal     int64                  `protobuf:"varint,14,opt,name=down_total,json=downTotal,proto3" json:"down_total,omitempty"`    //下行总流量，字节
+	StreamUp      int64                  `protobuf:"varint,15,opt,name=stream_up,json=streamUp,proto3" json:"stream_up,omitempty"`       //串流端口上行流量，字节
+	StreamDown    int64                  `protobuf:"varint,16,opt,name=stream_down,json=streamDown,proto3" json:"stream_down,omitempty"` //串流端口下行流量，字节
+	StreamIp      string                 `protobuf:"bytes,17,opt,name=stream_ip,json=streamIp,proto3" json:"stream_ip,omitempty"`
+	Eip           int32                  `protobuf:"varint,18,opt,name=eip,proto3" json:"eip,omitempty"`
+	ImageVersion  int32                  `protobuf:"varint,19,opt,name=image_version,json=imageVersion,proto3" json:"image_version,omitempty"`
+	HardwareType  string                 `protobuf:"bytes,20,opt,name=hardware_type,json=hardwareType,proto3" json:"hardware_type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReportFlowBizRequest) Reset() {
+	*x = ReportFlowBizRequest{}
+	mi := &file_v1_bandwidth_report_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReportFlowBizRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportFlowBizRequest) ProtoMessage() {}
+
+func (x *ReportFlowBizRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_bandwidth_report_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportFlowBizRequest.ProtoReflect.Descriptor instead.
+func (*ReportFlowBizRequest) Descriptor() ([]byte, []int) {
+	return file_v1_bandwidth_report_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ReportFlowBizRequest) GetReportId() string {
+	if x != nil {
+		return x.ReportId
+	}
+	return ""
+}
+
+func (x *ReportFlowBizRequest) GetFlowId() string {
+	if x != nil {
+		return x.FlowId
+	}
+	return ""
+}
+
+func (x *ReportFlowBizRequest) GetBizId() int64 {
+	if x != nil {
+		return x.BizId
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetGid() int64 {
+	if x != nil {
+		return x.Gid
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+func (x *ReportFlowBizRequest) GetVmid() int64 {
+	if x != nil {
+		return x.Vmid
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetAreaType() int32 {
+	if x != nil {
+		return x.AreaType
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetInstanceId() string {
+	if x != nil {
+		return x.InstanceId
+	}
+	return ""
+}
+
+func (x *ReportFlowBizRequest) GetIdc() string {
+	if x != nil {
+		return x.Idc
+	}
+	return ""
+}
+
+func (x *ReportFlowBizRequest) GetNo() int32 {
+	if x != nil {
+		return x.No
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetStartTime() int64 {
+	if x != nil {
+		return x.StartTime
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetEndTime() int64 {
+	if x != nil {
+		return x.EndTime
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetUpTotal() int64 {
+	if x != nil {
+		return x.UpTotal
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetDownTotal() int64 {
+	if x != nil {
+		return x.DownTotal
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetStreamUp() int64 {
+	if x != nil {
+		return x.StreamUp
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetStreamDown() int64 {
+	if x != nil {
+		return x.StreamDown
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetStreamIp() string {
+	if x != nil {
+		return x.StreamIp
+	}
+	return ""
+}
+
+func (x *ReportFlowBizRequest) GetEip() int32 {
+	if x != nil {
+		return x.Eip
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetImageVersion() int32 {
+	if x != nil {
+		return x.ImageVersion
+	}
+	return 0
+}
+
+func (x *ReportFlowBizRequest) GetHardwareType() string {
+	if x != nil {
+		return x.HardwareType
+	}
+	return ""
+}
+
+type ReportFlowBizBatch struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	Requests      []*ReportFlowBizRequest `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReportFlowBizBatch) Reset() {
+	*x = ReportFlowBizBatch{}
+	mi := &file_v1_bandwidth_report_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReportFlowBizBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportFlowBizBatch) ProtoMessage() {}
+
+func (x *ReportFlowBizBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_bandwidth_report_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportFlowBizBatch.ProtoReflect.Descriptor instead.
+func (*ReportFlowBizBatch) Descriptor() ([]byte, []int) {
+	return file_v1_bandwidth_report_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ReportFlowBizBatch) GetRequests() []*ReportFlowBizRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+type ReportFlowBizReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accepted      int32                  `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReportFlowBizReply) Reset() {
+	*x = ReportFlowBizReply{}
+	mi := &file_v1_bandwidth_report_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReportFlowBizReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportFlowBizReply) ProtoMessage() {}
+
+func (x *ReportFlowBizReply) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_bandwidth_report_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportFlowBizReply.ProtoReflect.Descriptor instead.
+func (*ReportFlowBizReply) Descriptor() ([]byte, []int) {
+	return file_v1_bandwidth_report_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ReportFlowBizReply) GetAccepted() int32 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+var File_v1_bandwidth_report_proto protoreflect.FileDescriptor
+
+var file_v1_bandwidth_report_proto_rawDesc = []byte{
+	0x0a, 0x19, 0x76, 0x31, 0x2f, 0x62, 0x61, 0x6e, 0x64, 0x77, 0x69, 0x64, 0x74, 0x68, 0x5f, 0x72,
+	0x65, 0x70, 0x6f, 0x72, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0c, 0x62, 0x61, 0x6e,
+	0x64, 0x77, 0x69, 0x64, 0x74, 0x68, 0x2e, 0x76, 0x31, 0x22, 0xa8, 0x04, 0x0a, 0x14, 0x52, 0x65,
+	0x70, 0x6f, 0x72, 0x74, 0x46, 0x6c, 0x6f, 0x77, 0x42, 0x69, 0x7a, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x5f, 0x69, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x49, 0x64, 0x12,
+	0x17, 0x0a, 0x07, 0x66, 0x6c, 0x6f, 0x77, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x66, 0x6c, 0x6f, 0x77, 0x49, 0x64, 0x12, 0x15, 0x0a, 0x06, 0x62, 0x69, 0x7a, 0x5f,
+	0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x62, 0x69, 0x7a, 0x49, 0x64, 0x12,
+	0x10, 0x0a, 0x03, 0x67, 0x69, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x67, 0x69,
+	0x64, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x75, 0x69, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x75, 0x75, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x76, 0x6d, 0x69, 0x64, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x04, 0x76, 0x6d, 0x69, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x61, 0x72, 0x65,
+	0x61, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x61, 0x72,
+	0x65, 0x61, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x69, 0x6e, 0x73, 0x74, 0x61, 0x6e,
+	0x63, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x69, 0x6e, 0x73,
+	0x74, 0x61, 0x6e, 0x63, 0x65, 0x49, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x69, 0x64, 0x63, 0x18, 0x09,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x69, 0x64, 0x63, 0x12, 0x0e, 0x0a, 0x02, 0x6e, 0x6f, 0x18,
+	0x0a, 0x20, 0x01, 0x28, 0x05, 0x52, 0x02, 0x6e, 0x6f, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x73,
+	0x74, 0x61, 0x72, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x65, 0x6e, 0x64, 0x5f,
+	0x74, 0x69, 0x6d, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x65, 0x6e, 0x64, 0x54,
+	0x69, 0x6d, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x75, 0x70, 0x5f, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x18,
+	0x0d, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x75, 0x70, 0x54, 0x6f, 0x74, 0x61, 0x6c, 0x12, 0x1d,
+	0x0a, 0x0a, 0x64, 0x6f, 0x77, 0x6e, 0x5f, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x18, 0x0e, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x09, 0x64, 0x6f, 0x77, 0x6e, 0x54, 0x6f, 0x74, 0x61, 0x6c, 0x12, 0x1b, 0x0a,
+	0x09, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x5f, 0x75, 0x70, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x08, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x55, 0x70, 0x12, 0x1f, 0x0a, 0x0b, 0x73, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x5f, 0x64, 0x6f, 0x77, 0x6e, 0x18, 0x10, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0a, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x44, 0x6f, 0x77, 0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x73,
+	0x74, 0x72, 0x65, 0x61, 0x6d, 0x5f, 0x69, 0x70, 0x18, 0x11, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x65, 0x69, 0x70, 0x18,
+	0x12, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x65, 0x69, 0x70, 0x12, 0x23, 0x0a, 0x0d, 0x69, 0x6d,
+	0x61, 0x67, 0x65, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x13, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x0c, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12,
+	0x23, 0x0a, 0x0d, 0x68, 0x61, 0x72, 0x64, 0x77, 0x61, 0x72, 0x65, 0x5f, 0x74, 0x79, 0x70, 0x65,
+	0x18, 0x14, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x68, 0x61, 0x72, 0x64, 0x77, 0x61, 0x72, 0x65,
+	0x54, 0x79, 0x70, 0x65, 0x22, 0x54, 0x0a, 0x12, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x46, 0x6c,
+	0x6f, 0x77, 0x42, 0x69, 0x7a, 0x42, 0x61, 0x74, 0x63, 0x68, 0x12, 0x3e, 0x0a, 0x08, 0x72, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x22, 0x2e, 0x62,
+	0x61, 0x6e, 0x64, 0x77, 0x69, 0x64, 0x74, 0x68, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x70, 0x6f,
+	0x72, 0x74, 0x46, 0x6c, 0x6f, 0x77, 0x42, 0x69, 0x7a, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x52, 0x08, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x73, 0x22, 0x30, 0x0a, 0x12, 0x52, 0x65,
+	0x70, 0x6f, 0x72, 0x74, 0x46, 0x6c, 0x6f, 0x77, 0x42, 0x69, 0x7a, 0x52, 0x65, 0x70, 0x6c, 0x79,
+	0x12, 0x1a, 0x0a, 0x08, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x08, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x32, 0x66, 0x0a, 0x0f,
+	0x42, 0x61, 0x6e, 0x64, 0x77, 0x69, 0x64, 0x74, 0x68, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x12,
+	0x53, 0x0a, 0x0d, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x46, 0x6c, 0x6f, 0x77, 0x42, 0x69, 0x7a,
+	0x12, 0x20, 0x2e, 0x62, 0x61, 0x6e, 0x64, 0x77, 0x69, 0x64, 0x74, 0x68, 0x2e, 0x76, 0x31, 0x2e,
+	0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x46, 0x6c, 0x6f, 0x77, 0x42, 0x69, 0x7a, 0x42, 0x61, 0x74,
+	0x63, 0x68, 0x1a, 0x20, 0x2e, 0x62, 0x61, 0x6e, 0x64, 0x77, 0x69, 0x64, 0x74, 0x68, 0x2e, 0x76,
+	0x31, 0x2e, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x46, 0x6c, 0x6f, 0x77, 0x42, 0x69, 0x7a, 0x52,
+	0x65, 0x70, 0x6c, 0x79, 0x42, 0x2c, 0x5a, 0x2a, 0x61, 0x63, 0x63, 0x75, 0x6d, 0x75, 0x6c, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x66, 0x72, 0x61, 0x6d, 0x65, 0x77, 0x6f, 0x72, 0x6b, 0x2f, 0x62,
+	0x61, 0x6e, 0x64, 0x77, 0x69, 0x64, 0x74, 0x68, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x76, 0x31, 0x3b,
+	0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_v1_bandwidth_report_proto_rawDescOnce sync.Once
+	file_v1_bandwidth_report_proto_rawDescData = file_v1_bandwidth_report_proto_rawDesc
+)
+
+func file_v1_bandwidth_report_proto_rawDescGZIP() []byte {
+	file_v1_bandwidth_report_proto_rawDescOnce.Do(func() {
+		file_v1_bandwidth_report_proto_rawDescData = protoimpl.X.CompressGZIP(file_v1_bandwidth_report_proto_rawDescData)
+	})
+	return file_v1_bandwidth_report_proto_rawDescData
+}
+
+var file_v1_bandwidth_report_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_v1_bandwidth_report_proto_goTypes = []any{
+	(*ReportFlowBizRequest)(nil), // 0: bandwidth.v1.ReportFlowBizRequest
+	(*ReportFlowBizBatch)(nil),   // 1: bandwidth.v1.ReportFlowBizBatch
+	(*ReportFlowBizReply)(nil),   // 2: bandwidth.v1.ReportFlowBizReply
+}
+var file_v1_bandwidth_report_proto_depIdxs = []int32{
+	0, // 0: bandwidth.v1.ReportFlowBizBatch.requests:type_name -> bandwidth.v1.ReportFlowBizRequest
+	1, // 1: bandwidth.v1.BandwidthReport.ReportFlowBiz:input_type -> bandwidth.v1.ReportFlowBizBatch
+	2, // 2: bandwidth.v1.BandwidthReport.ReportFlowBiz:output_type -> bandwidth.v1.ReportFlowBizReply
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_v1_bandwidth_report_proto_init() }
+func file_v1_bandwidth_report_proto_init() {
+	if File_v1_bandwidth_report_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_v1_bandwidth_report_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_v1_bandwidth_report_proto_goTypes,
+		DependencyIndexes: file_v1_bandwidth_report_proto_depIdxs,
+		MessageInfos:      file_v1_bandwidth_report_proto_msgTypes,
+	}.Build()
+	File_v1_bandwidth_report_proto = out.File
+	file_v1_bandwidth_report_proto_rawDesc = nil
+	file_v1_bandwidth_report_proto_goTypes = nil
+	file_v1_bandwidth_report_proto_depIdxs = nil
+}
@@ -0,0 +1,109 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: v1/bandwidth_report.proto
+
+package v1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	BandwidthReport_ReportFlowBiz_FullMethodName = "/bandwidth.v1.BandwidthReport/ReportFlowBiz"
+)
+
+// BandwidthReportClient is the client API for BandwidthReport service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BandwidthReportClient interface {
+	ReportFlowBiz(ctx context.Context, in *ReportFlowBizBatch, opts ...grpc.CallOption) (*ReportFlowBizReply, error)
+}
+
+type bandwidthReportClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBandwidthReportClient(cc grpc.ClientConnInterface) BandwidthReportClient {
+	return &bandwidthReportClient{cc}
+}
+
+func (c *bandwidthReportClient) ReportFlowBiz(ctx context.Context, in *ReportFlowBizBatch, opts ...grpc.CallOption) (*ReportFlowBizReply, error) {
+	out := new(ReportFlowBizReply)
+	err := c.cc.Invoke(ctx, BandwidthReport_ReportFlowBiz_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BandwidthReportServer is the server API for BandwidthReport service.
+// All implementations must embed UnimplementedBandwidthReportServer
+// for forward compatibility
+type BandwidthReportServer interface {
+	ReportFlowBiz(context.Context, *ReportFlowBizBatch) (*ReportFlowBizReply, error)
+	mustEmbedUnimplementedBandwidthReportServer()
+}
+
+// UnimplementedBandwidthReportServer must be embedded to have forward compatible implementations.
+type UnimplementedBandwidthReportServer struct {
+}
+
+func (UnimplementedBandwidthReportServer) ReportFlowBiz(context.Context, *ReportFlowBizBatch) (*ReportFlowBizReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportFlowBiz not implemented")
+}
+func (UnimplementedBandwidthReportServer) mustEmbedUnimplementedBandwidthReportServer() {}
+
+// UnsafeBandwidthReportServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BandwidthReportServer will
+// result in compilation errors.
+type UnsafeBandwidthReportServer interface {
+	mustEmbedUnimplementedBandwidthReportServer()
+}
+
+func RegisterBandwidthReportServer(s grpc.ServiceRegistrar, srv BandwidthReportServer) {
+	s.RegisterService(&BandwidthReport_ServiceDesc, srv)
+}
+
+func _BandwidthReport_ReportFlowBiz_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportFlowBizBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BandwidthReportServer).ReportFlowBiz(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BandwidthReport_ReportFlowBiz_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BandwidthReportServer).ReportFlowBiz(ctx, req.(*ReportFlowBizBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// BandwidthReport_ServiceDesc is the grpc.ServiceDesc for BandwidthReport service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var BandwidthReport_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "bandwidth.v1.BandwidthReport",
+	HandlerType: (*BandwidthReportServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReportFlowBiz",
+			Handler:    _BandwidthReport_ReportFlowBiz_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v1/bandwidth_report.proto",
+}
@@ -1,14 +1,26 @@
 package bandwidth
 
 import (
+	"accumulation/framework/bandwidth/anomaly"
 	"accumulation/framework/bandwidth/api"
+	"accumulation/framework/bandwidth/client"
+	"accumulation/framework/bandwidth/conf"
 	model2 "accumulation/framework/bandwidth/model"
+	"accumulation/framework/bandwidth/query"
+	"accumulation/framework/bandwidth/report"
 	"context"
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/gorilla/mux"
 )
 
 type UseCase struct {
 	bandwidthReportManager api.BandwidthReportManager
+	watcher                *conf.Watcher
+	closers                []func(ctx context.Context) error
 }
 
 // NewBandWidthUseCase NewBandWidth .
@@ -16,6 +28,27 @@ func NewBandWidthUseCase(bandwidthReportManager api.BandwidthReportManager) *Use
 	return &UseCase{bandwidthReportManager: bandwidthReportManager}
 }
 
+// NewUseCase 按ReportConfig的host和report_protocol创建上报client(grpc/http)，创建并启动上报job和manager，
+// Close时关闭manager的异常通知，停止job并关闭上报连接
+func NewUseCase(ctx context.Context, data *conf.Data, sinks ...anomaly.Sink) (*UseCase, error) {
+	reportClient, cleanup, err := client.NewBandwidthReportClient(data)
+	if err != nil {
+		return nil, err
+	}
+	job := report.NewBandwidthReportJob(reportClient, data)
+	if err = job.Start(ctx); err != nil {
+		cleanup()
+		return nil, err
+	}
+	manager := report.NewBandwidthReportManager(reportClient, job, data, sinks...)
+	useCase := NewBandWidthUseCase(manager)
+	useCase.closers = append(useCase.closers, manager.Close, func(ctx context.Context) error {
+		defer cleanup()
+		return job.Stop(ctx)
+	})
+	return useCase, nil
+}
+
 func (useCase *UseCase) Start(ctx context.Context, gameStarted *model2.GameStarted) error {
 	session := &model2.Session{
 		Start:        gameStarted.Start,
@@ -29,6 +62,8 @@ func (useCase *UseCase) Start(ctx context.Context, gameStarted *model2.GameStart
 		Idc:          gameStarted.Idc,
 		StreamIp:     gameStarted.StreamIp,
 		StreamPorts:  gameStarted.StreamPorts,
+		StreamProcs:  gameStarted.StreamProcs,
+		Extra:        gameStarted.Extra,
 		EIP:          gameStarted.EIP(),
 		ImageVersion: gameStarted.ImageVersion,
 	}
@@ -49,11 +84,50 @@ func (useCase *UseCase) Stop(ctx context.Context, gameStop *model2.GameStop) err
 	return useCase.bandwidthReportManager.EndReport(ctx, session)
 }
 
-/*func (useCase *UseCase) NotifyAccessInfo(ctx context.Context, req *v1.NotifyAccessInfoReq) error {
-	var streamPorts []model.StreamPort
-	err := json.Unmarshal([]byte(req.StreamPort), &streamPorts)
+func (useCase *UseCase) NotifyAccessInfo(ctx context.Context, accessInfo *model2.AccessInfo) error {
+	streamPorts, err := accessInfo.StreamPorts()
 	if err != nil {
 		return err
 	}
-	return useCase.bandwidthReportManager.NotifyAccessInfo(ctx, req.Vmid, req.StreamIp, streamPorts)
-}*/
+	return useCase.bandwidthReportManager.NotifyAccessInfo(ctx, accessInfo.VMid, accessInfo.StreamIp, streamPorts)
+}
+
+// ApplyConfig 应用新的配置，WatchConfig热加载时配置变化会调用
+func (useCase *UseCase) ApplyConfig(ctx context.Context, data *conf.Data) error {
+	if data == nil || data.Acl == nil {
+		return nil
+	}
+	return useCase.bandwidthReportManager.ApplyConfig(ctx, data.Acl.ReportConfig)
+}
+
+// WatchConfig 启动时从source加载一次配置并生效，之后每interval重新加载，配置变化时调用ApplyConfig，Close时停止
+func (useCase *UseCase) WatchConfig(ctx context.Context, source conf.Source, interval time.Duration) error {
+	watcher := conf.NewWatcher(source, interval)
+	watcher.OnChange(func(ctx context.Context, data *conf.Data) {
+		if err := useCase.ApplyConfig(ctx, data); err != nil {
+			log.Errorf("apply bandwidth config failure err:%v", err)
+		}
+	})
+	if err := watcher.Start(ctx); err != nil {
+		return err
+	}
+	useCase.watcher = watcher
+	return nil
+}
+
+// Close 停止配置热加载，NewUseCase创建的话同时关闭manager的异常通知，停止上报job并关闭上报连接
+func (useCase *UseCase) Close(ctx context.Context) error {
+	var errs []error
+	if useCase.watcher != nil {
+		errs = append(errs, useCase.watcher.Stop(ctx))
+	}
+	for _, closer := range useCase.closers {
+		errs = append(errs, closer(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// RegisterHTTP 把流量查询接口(query.Handler)注册到agent的http路由上
+func (useCase *UseCase) RegisterHTTP(router *mux.Router) {
+	query.NewHandler(useCase.bandwidthReportManager).Register(router)
+}
@@ -0,0 +1,94 @@
+package bandwidth
+
+import (
+	"accumulation/framework/bandwidth/api"
+	"accumulation/framework/bandwidth/client"
+	"accumulation/framework/bandwidth/client/clienttest"
+	"accumulation/framework/bandwidth/conf"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeManager struct {
+	api.BandwidthReportManager
+	mutex   sync.Mutex
+	applied []*conf.Acl_ReportConfig
+}
+
+func (m *fakeManager) ApplyConfig(ctx context.Context, reportConfig *conf.Acl_ReportConfig) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.applied = append(m.applied, reportConfig)
+	return nil
+}
+
+func (m *fakeManager) last() (int, string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.applied) == 0 {
+		return 0, ""
+	}
+	return len(m.applied), m.applied[len(m.applied)-1].BpfFilter
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bandwidth.json")
+	if err := os.WriteFile(path, []byte(`{"acl":{"reportConfig":{"bpf_filter":"udp"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manager := &fakeManager{}
+	useCase := NewBandWidthUseCase(manager)
+	ctx := context.Background()
+	if err := useCase.WatchConfig(ctx, conf.NewFileSource(path), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if count, filter := manager.last(); count != 1 || filter != "udp" {
+		t.Fatalf("expected config to be applied on start, got %d %q", count, filter)
+	}
+	if err := os.WriteFile(path, []byte(`{"acl":{"reportConfig":{"bpf_filter":"tcp port 80"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, filter := manager.last(); filter == "tcp port 80" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected changed config to be applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := useCase.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewUseCase(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewUseCase(ctx, &conf.Data{Acl: &conf.Acl{ReportConfig: &conf.Acl_ReportConfig{}}}); err == nil {
+		t.Fatal("expected empty report host to fail")
+	}
+	server := clienttest.NewStubServer()
+	defer server.Close()
+	addr, err := server.ListenHTTP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	useCase, err := NewUseCase(ctx, &conf.Data{Acl: &conf.Acl{ReportConfig: &conf.Acl_ReportConfig{
+		Host:           addr.String(),
+		ReportProtocol: client.ProtocolHTTP,
+		SpillDir:       filepath.Join(dir, "wal"),
+		DeadLetterPath: filepath.Join(dir, "dead_letter.dat"),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = useCase.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
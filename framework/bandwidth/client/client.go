@@ -0,0 +1,54 @@
+package client
+
+import (
+	"accumulation/framework/bandwidth/api"
+	v1 "accumulation/framework/bandwidth/api/v1"
+	"accumulation/framework/bandwidth/conf"
+	"accumulation/framework/bandwidth/model"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+)
+
+// NewBandwidthReportClient 根据ReportConfig的host和report_protocol创建上报client，返回的cleanup用于关闭连接
+func NewBandwidthReportClient(data *conf.Data) (api.BandwidthReportClient, func(), error) {
+	reportConfig := data.Acl.ReportConfig
+	if reportConfig == nil || len(reportConfig.Host) == 0 {
+		return nil, nil, fmt.Errorf("report host is empty")
+	}
+	switch strings.ToLower(reportConfig.ReportProtocol) {
+	case "", ProtocolGRPC:
+		conn, err := grpc.Dial(reportConfig.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewGRPCReportClient(conn), func() { conn.Close() }, nil
+	case ProtocolHTTP:
+		return NewHTTPReportClient(reportConfig.Host, http.DefaultClient), func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown report protocol %s", reportConfig.ReportProtocol)
+}
+
+// toBatch 上报的数据转换成批量请求，不支持的数据类型重试也不会成功，返回ErrInvalidReport
+func toBatch(data interface{}) (*v1.ReportFlowBizBatch, error) {
+	batch := &v1.ReportFlowBizBatch{}
+	switch reqs := data.(type) {
+	case []*model.ReportFlowBizRequest:
+		for _, req := range reqs {
+			batch.Requests = append(batch.Requests, &req.ReportFlowBizRequest)
+		}
+	case *model.ReportFlowBizRequest:
+		batch.Requests = append(batch.Requests, &reqs.ReportFlowBizRequest)
+	default:
+		return nil, fmt.Errorf("%w: unsupported data type %T", api.ErrInvalidReport, data)
+	}
+	return batch, nil
+}
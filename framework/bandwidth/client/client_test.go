@@ -0,0 +1,91 @@
+package client_test
+
+import (
+	"accumulation/framework/bandwidth/api"
+	v1 "accumulation/framework/bandwidth/api/v1"
+	"accumulation/framework/bandwidth/client"
+	"accumulation/framework/bandwidth/client/clienttest"
+	"accumulation/framework/bandwidth/conf"
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"errors"
+	"testing"
+)
+
+func newRequest(reportId string, upTotal int64) *model.ReportFlowBizRequest {
+	req := &model.ReportFlowBizRequest{}
+	req.ReportId = reportId
+	req.UpTotal = upTotal
+	req.HardwareType = "cpu|gpu"
+	return req
+}
+
+func testReportClient(t *testing.T, protocol string, listen func(*clienttest.StubServer) (string, error)) {
+	server := clienttest.NewStubServer()
+	server.Reject = func(req *v1.ReportFlowBizRequest) bool {
+		return req.ReportId == "invalid"
+	}
+	defer server.Close()
+	addr, err := listen(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportClient, cleanup, err := client.NewBandwidthReportClient(&conf.Data{Acl: &conf.Acl{
+		ReportConfig: &conf.Acl_ReportConfig{Host: addr, ReportProtocol: protocol},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	ctx := context.Background()
+	err = reportClient.ReportBandwidthData(ctx, []*model.ReportFlowBizRequest{newRequest("a", 100), newRequest("b", 200)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := server.Requests()
+	if len(requests) != 2 || requests[0].ReportId != "a" || requests[1].UpTotal != 200 || requests[1].HardwareType != "cpu|gpu" {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	err = reportClient.ReportBandwidthData(ctx, []*model.ReportFlowBizRequest{newRequest("invalid", 1)})
+	if !errors.Is(err, api.ErrInvalidReport) {
+		t.Fatalf("expected invalid report err:%v", err)
+	}
+	if err = reportClient.ReportBandwidthData(ctx, "unsupported"); !errors.Is(err, api.ErrInvalidReport) {
+		t.Fatalf("expected invalid report err:%v", err)
+	}
+}
+
+func TestGRPCReportClient(t *testing.T) {
+	testReportClient(t, client.ProtocolGRPC, func(server *clienttest.StubServer) (string, error) {
+		addr, err := server.ListenGRPC("127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		return addr.String(), nil
+	})
+}
+
+func TestHTTPReportClient(t *testing.T) {
+	testReportClient(t, client.ProtocolHTTP, func(server *clienttest.StubServer) (string, error) {
+		addr, err := server.ListenHTTP("127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		return addr.String(), nil
+	})
+}
+
+func TestReportFlowBizRequestEncode(t *testing.T) {
+	req := newRequest("a", 100)
+	data, err := req.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := req.Instance()
+	if err = decoded.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ReportId != "a" || decoded.UpTotal != 100 {
+		t.Fatalf("unexpected decoded request %v", decoded)
+	}
+}
@@ -0,0 +1,108 @@
+// Package clienttest 测试上报客户端用的本地上报服务
+package clienttest
+
+import (
+	v1 "accumulation/framework/bandwidth/api/v1"
+	"accumulation/framework/bandwidth/client"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// StubServer 本地的上报服务桩，同时支持grpc和http，记录收到的请求，用于测试
+type StubServer struct {
+	v1.UnimplementedBandwidthReportServer
+	// Reject 返回true的请求整批拒绝，grpc返回InvalidArgument，http返回400
+	Reject   func(req *v1.ReportFlowBizRequest) bool
+	mutex    sync.Mutex
+	requests []*v1.ReportFlowBizRequest
+	grpcSrv  *grpc.Server
+	httpSrv  *http.Server
+}
+
+func NewStubServer() *StubServer {
+	return &StubServer{}
+}
+
+func (s *StubServer) ReportFlowBiz(ctx context.Context, batch *v1.ReportFlowBizBatch) (*v1.ReportFlowBizReply, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, req := range batch.Requests {
+		if s.Reject != nil && s.Reject(req) {
+			return nil, status.Errorf(codes.InvalidArgument, "report %s rejected", req.ReportId)
+		}
+	}
+	s.requests = append(s.requests, batch.Requests...)
+	return &v1.ReportFlowBizReply{Accepted: int32(len(batch.Requests))}, nil
+}
+
+func (s *StubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != client.ReportPath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	batch := &v1.ReportFlowBizBatch{}
+	if err = protojson.Unmarshal(body, batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reply, err := s.ReportFlowBiz(r.Context(), batch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, _ := protojson.Marshal(reply)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// ListenGRPC 在addr上启动grpc服务，addr可以是127.0.0.1:0，返回实际监听的地址
+func (s *StubServer) ListenGRPC(addr string) (net.Addr, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.grpcSrv = grpc.NewServer()
+	v1.RegisterBandwidthReportServer(s.grpcSrv, s)
+	go s.grpcSrv.Serve(lis)
+	return lis.Addr(), nil
+}
+
+// ListenHTTP 在addr上启动http服务，返回实际监听的地址
+func (s *StubServer) ListenHTTP(addr string) (net.Addr, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.httpSrv = &http.Server{Handler: s}
+	go s.httpSrv.Serve(lis)
+	return lis.Addr(), nil
+}
+
+// Requests 收到的所有请求
+func (s *StubServer) Requests() []*v1.ReportFlowBizRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*v1.ReportFlowBizRequest(nil), s.requests...)
+}
+
+func (s *StubServer) Close() {
+	if s.grpcSrv != nil {
+		s.grpcSrv.Stop()
+	}
+	if s.httpSrv != nil {
+		s.httpSrv.Close()
+	}
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"accumulation/framework/bandwidth/api"
+	v1 "accumulation/framework/bandwidth/api/v1"
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type grpcReportClient struct {
+	client v1.BandwidthReportClient
+}
+
+func NewGRPCReportClient(conn grpc.ClientConnInterface) api.BandwidthReportClient {
+	return &grpcReportClient{client: v1.NewBandwidthReportClient(conn)}
+}
+
+func (c *grpcReportClient) ReportBandwidthData(ctx context.Context, data interface{}) error {
+	batch, err := toBatch(data)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.ReportFlowBiz(ctx, batch)
+	if err == nil {
+		return nil
+	}
+	if status.Code(err) == codes.InvalidArgument {
+		return fmt.Errorf("%w: %v", api.ErrInvalidReport, err)
+	}
+	return err
+}
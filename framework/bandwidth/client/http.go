@@ -0,0 +1,57 @@
+package client
+
+import (
+	"accumulation/framework/bandwidth/api"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// ReportPath http上报的路径，请求和响应都是protojson编码的ReportFlowBizBatch和ReportFlowBizReply
+const ReportPath = "/bandwidth/v1/report"
+
+type httpReportClient struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPReportClient(host string, client *http.Client) api.BandwidthReportClient {
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+	return &httpReportClient{url: strings.TrimSuffix(host, "/") + ReportPath, client: client}
+}
+
+func (c *httpReportClient) ReportBandwidthData(ctx context.Context, data interface{}) error {
+	batch, err := toBatch(data)
+	if err != nil {
+		return err
+	}
+	body, err := protojson.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("%w: %v", api.ErrInvalidReport, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity:
+		return fmt.Errorf("%w: status %d body:%s", api.ErrInvalidReport, resp.StatusCode, respBody)
+	}
+	return fmt.Errorf("report bandwidth failure status %d body:%s", resp.StatusCode, respBody)
+}
@@ -0,0 +1,97 @@
+//go:build linux
+// +build linux
+
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"context"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/gopacket/afpacket"
+)
+
+// AFPacketCollector 用AF_PACKET的mmap环形缓冲区抓包，不依赖libpcap，不支持bpf过滤规则
+type AFPacketCollector struct {
+	deviceName string
+	macAddress string
+	mutex      sync.Mutex
+	handle     *afpacket.TPacket
+	isRunning  atomic.Bool
+	counter    *packetCounter
+	stopped    chan struct{}
+}
+
+func NewAFPacketCollector(deviceName, macAddress string) *AFPacketCollector {
+	return &AFPacketCollector{deviceName: deviceName, macAddress: macAddress, counter: newPacketCounter(macAddress)}
+}
+
+func (c *AFPacketCollector) Start(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	handle, err := afpacket.NewTPacket(afpacket.OptInterface(c.deviceName), afpacket.OptPollTimeout(time.Second))
+	if err != nil {
+		return err
+	}
+	c.handle = handle
+	c.stopped = make(chan struct{})
+	c.isRunning.Store(true)
+	go c.loopReadPacket(handle, c.stopped)
+	log.Infof("DeviceName %s ,MacAddress %s :afpacket start success", c.deviceName, c.macAddress)
+	return nil
+}
+
+func (c *AFPacketCollector) loopReadPacket(handle *afpacket.TPacket, stopped chan struct{}) {
+	defer close(stopped)
+	defer func() {
+		if e := recover(); e != nil {
+			log.Errorf("afpacket read panic|err=%v|stack=%v", e, string(debug.Stack()))
+		}
+	}()
+	for c.isRunning.Load() {
+		packetData, _, err := handle.ZeroCopyReadPacketData()
+		if err == afpacket.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			log.Errorf("afpacket ZeroCopyReadPacketData error err:%v", err)
+			continue
+		}
+		c.counter.handle(packetData)
+	}
+}
+
+// Stop 等待读包的goroutine退出后再关闭handle，避免读已经释放的环形缓冲区
+func (c *AFPacketCollector) Stop(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.isRunning.Swap(false) {
+		return nil
+	}
+	<-c.stopped
+	c.handle.Close()
+	c.handle = nil
+	log.Infof("DeviceName %s ,MacAddress %s :afpacket stop success", c.deviceName, c.macAddress)
+	return nil
+}
+
+func (c *AFPacketCollector) ExportAndClean() []*model2.Bandwidth {
+	return c.counter.export()
+}
+
+func (c *AFPacketCollector) Stats() Stats {
+	stats := Stats{Name: c.deviceName, Packets: c.counter.packets.Load(), Bytes: c.counter.bytes.Load()}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.handle == nil {
+		return stats
+	}
+	if _, v3, err := c.handle.SocketStats(); err == nil {
+		stats.Dropped = uint64(v3.Drops())
+	}
+	return stats
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"context"
+	"errors"
+)
+
+// AFPacketCollector 只支持linux
+type AFPacketCollector struct {
+	deviceName string
+}
+
+func NewAFPacketCollector(deviceName, macAddress string) *AFPacketCollector {
+	return &AFPacketCollector{deviceName: deviceName}
+}
+
+func (c *AFPacketCollector) Start(ctx context.Context) error {
+	return errors.New("afpacket collector is only supported on linux")
+}
+
+func (c *AFPacketCollector) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (c *AFPacketCollector) ExportAndClean() []*model2.Bandwidth {
+	return nil
+}
+
+func (c *AFPacketCollector) Stats() Stats {
+	return Stats{Name: c.deviceName}
+}
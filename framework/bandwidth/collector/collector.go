@@ -9,20 +9,22 @@ import (
 	"time"
 
 	"github.com/go-kratos/kratos/v2/log"
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 )
 
+// BandwidthCollector 用pcap抓包的收集器
 type BandwidthCollector struct {
-	deviceName        string
-	macAddress        string
-	handle            *pcap.Handle
-	mutex             *sync.Mutex
-	isRunning         atomic.Bool
-	bpfFilter         string
-	stats             map[string]*model2.Bandwidth
-	lastCollectorTime int64
+	deviceName    string
+	macAddress    string
+	handle        *pcap.Handle
+	mutex         *sync.Mutex
+	isRunning     atomic.Bool
+	bpfFilter     string
+	counter       *packetCounter
+	lastPcapStats pcap.Stats
+	queueDropped  atomic.Uint64 // 已经停止的pipeline丢弃的包
+	pipeline      *packetPipeline
+	stopped       chan struct{} // 读包的goroutine退出时关闭
 }
 
 func NewBandwidthCollector(deviceName, bpfFilter, macAddress string) *BandwidthCollector {
@@ -30,7 +32,7 @@ func NewBandwidthCollector(deviceName, bpfFilter, macAddress string) *BandwidthC
 		deviceName: deviceName,
 		macAddress: macAddress,
 		bpfFilter:  bpfFilter,
-		stats:      map[string]*model2.Bandwidth{},
+		counter:    newPacketCounter(macAddress),
 		mutex:      &sync.Mutex{},
 	}
 }
@@ -41,6 +43,13 @@ func (tc *BandwidthCollector) Stop(ctx context.Context) error {
 		log.Infof("DeviceName %s ,MacAddress %s :Stop success", tc.deviceName, tc.macAddress)
 	}()
 	tc.isRunning.Swap(false)
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	// 等待读包的goroutine退出后再关闭handle，避免读已经释放的handle
+	if tc.stopped != nil {
+		<-tc.stopped
+		tc.stopped = nil
+	}
 	if tc.handle != nil {
 		tc.handle.Close()
 		tc.handle = nil
@@ -59,6 +68,7 @@ func (tc *BandwidthCollector) Start(ctx context.Context) error {
 	if tc.handle, err = pcap.OpenLive(tc.deviceName, 65535, true, time.Second); err != nil {
 		return err
 	}
+	tc.lastPcapStats = pcap.Stats{}
 	if len(tc.bpfFilter) > 0 {
 		err = tc.handle.SetBPFFilter(tc.bpfFilter)
 		if err != nil {
@@ -66,95 +76,99 @@ func (tc *BandwidthCollector) Start(ctx context.Context) error {
 		}
 	}
 	tc.isRunning.Swap(true)
-	tc.loopReadPacket()
+	tc.pipeline = newPacketPipeline(tc.counter, defaultPacketQueueLen)
+	tc.stopped = make(chan struct{})
+	tc.loopReadPacket(tc.handle, tc.pipeline, tc.stopped)
 	return nil
 }
 
-func (tc *BandwidthCollector) loopReadPacket() {
+// SetBPFFilter 替换过滤规则，正在抓包的handle立即生效，设置失败时继续使用原来的规则
+func (tc *BandwidthCollector) SetBPFFilter(bpfFilter string) error {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	if bpfFilter == tc.bpfFilter {
+		return nil
+	}
+	if tc.isRunning.Load() && tc.handle != nil {
+		if err := tc.handle.SetBPFFilter(bpfFilter); err != nil {
+			return err
+		}
+	}
+	log.Infof("DeviceName %s ,MacAddress %s :bpf filter changed from %q to %q", tc.deviceName, tc.macAddress, tc.bpfFilter, bpfFilter)
+	tc.bpfFilter = bpfFilter
+	return nil
+}
+
+func (tc *BandwidthCollector) loopReadPacket(handle *pcap.Handle, pipeline *packetPipeline, stopped chan struct{}) {
 	go func() {
+		defer close(stopped)
+		defer func() {
+			pipeline.close()
+			tc.queueDropped.Add(pipeline.dropped())
+		}()
 		defer func() {
 			if e := recover(); e != nil {
 				log.Errorf("NewPacketSource panic|err=%v|stack=%v", e, string(debug.Stack()))
 			}
 		}()
 		// 开始抓包
-		for tc.isRunning.Load() && tc.handle != nil {
-			packetData, _, err := tc.handle.ZeroCopyReadPacketData()
+		for tc.isRunning.Load() {
+			packetData, _, err := handle.ZeroCopyReadPacketData()
 			if err != nil {
+				if err == pcap.NextErrorTimeoutExpired {
+					continue
+				}
+				if !tc.isRunning.Load() {
+					return
+				}
 				log.Errorf("ZeroCopyReadPacketData error err:%v", err)
 				continue
 			}
-			// 只获取以太网帧
-			packet := gopacket.NewPacket(packetData, layers.LayerTypeEthernet, gopacket.Default)
-			ethernetLayer := packet.Layer(layers.LayerTypeEthernet)
-			ipPort := parseIpPortInfo(packet)
-			if ethernetLayer != nil && ipPort != nil {
-				ethernet := ethernetLayer.(*layers.Ethernet)
-				// 如果封包的目的MAC是本机则表示是下行的数据包，否则为上行
-				if ethernet.DstMAC.String() == tc.macAddress {
-					tc.addPacketLen(len(packet.Data()), ipPort.dstIP, ipPort.dstPort, model2.Down)
-				} else if ethernet.SrcMAC.String() == tc.macAddress {
-					tc.addPacketLen(len(packet.Data()), ipPort.srcIP, ipPort.srcPort, model2.Up)
-				}
+			if !pipeline.push(packetData) {
+				collectorPacketsDropped.WithLabelValues(tc.deviceName, dropQueue).Inc()
 			}
 		}
 	}()
 }
 
-func parseIpPortInfo(packet gopacket.Packet) *IpPortInfo {
-	networkLayer := packet.NetworkLayer()
-	if networkLayer == nil {
-		return nil
-	}
-	srcIp, dstIp := networkLayer.NetworkFlow().Endpoints()
-	// 解析传输层数据
-	transportLayer := packet.TransportLayer()
-	if transportLayer == nil {
-		return nil
-	}
-	srcPort := transportLayer.TransportFlow().Src().String()
-	dstPort := transportLayer.TransportFlow().Dst().String()
-	return &IpPortInfo{
-		srcIP:   srcIp.String(),
-		srcPort: srcPort,
-		dstIP:   dstIp.String(),
-		dstPort: dstPort,
-	}
-}
-
-type IpPortInfo struct {
-	srcIP   string
-	srcPort string
-	dstIP   string
-	dstPort string
+func (tc *BandwidthCollector) ExportAndClean() []*model2.Bandwidth {
+	result := tc.counter.export()
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	tc.reportPcapStats()
+	return result
 }
 
-func (tc *BandwidthCollector) addPacketLen(pcapDataLen int, ip string, port string, trafficType model2.TrafficType) {
+func (tc *BandwidthCollector) Stats() Stats {
+	stats := Stats{Name: tc.deviceName, Packets: tc.counter.packets.Load(), Bytes: tc.counter.bytes.Load()}
 	tc.mutex.Lock()
 	defer tc.mutex.Unlock()
-	bandwidth, ok := tc.stats[ip+":"+port]
-	if !ok {
-		bandwidth = &model2.Bandwidth{
-			MacAddress: tc.macAddress,
-			Ip:         ip,
-			Port:       port,
-			StartTime:  tc.lastCollectorTime,
-		}
-		tc.stats[ip+":"+port] = bandwidth
+	stats.Dropped = uint64(tc.lastPcapStats.PacketsDropped+tc.lastPcapStats.PacketsIfDropped) + tc.queueDropped.Load()
+	if tc.isRunning.Load() && tc.pipeline != nil {
+		stats.Dropped += tc.pipeline.dropped()
 	}
-	bandwidth.AddPacketLen(int32(pcapDataLen), trafficType)
+	return stats
 }
 
-func (tc *BandwidthCollector) ExportAndClean() []*model2.Bandwidth {
-	tc.mutex.Lock()
-	defer tc.mutex.Unlock()
-	var result []*model2.Bandwidth
-	endTime := time.Now().Unix()
-	for _, v := range tc.stats {
-		v.CollectTime = endTime
-		result = append(result, v)
+// reportPcapStats 把pcap的累计统计转换成增量计入指标，handle重新打开后统计从0开始
+func (tc *BandwidthCollector) reportPcapStats() {
+	if !tc.isRunning.Load() || tc.handle == nil {
+		return
 	}
-	tc.lastCollectorTime = endTime
-	tc.stats = make(map[string]*model2.Bandwidth)
-	return result
+	stats, err := tc.handle.Stats()
+	if err != nil {
+		log.Warnf("DeviceName %s read pcap stats failure err:%v", tc.deviceName, err)
+		return
+	}
+	last := tc.lastPcapStats
+	if stats.PacketsReceived >= last.PacketsReceived {
+		collectorPacketsReceived.WithLabelValues(tc.deviceName).Add(float64(stats.PacketsReceived - last.PacketsReceived))
+	}
+	if stats.PacketsDropped >= last.PacketsDropped {
+		collectorPacketsDropped.WithLabelValues(tc.deviceName, dropKernel).Add(float64(stats.PacketsDropped - last.PacketsDropped))
+	}
+	if stats.PacketsIfDropped >= last.PacketsIfDropped {
+		collectorPacketsDropped.WithLabelValues(tc.deviceName, dropInterface).Add(float64(stats.PacketsIfDropped - last.PacketsIfDropped))
+	}
+	tc.lastPcapStats = *stats
 }
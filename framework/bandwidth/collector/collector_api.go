@@ -0,0 +1,27 @@
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"context"
+)
+
+// Collector 流量收集器，BandwidthEngine定时调用ExportAndClean导出上一个周期按本地ip:port统计的流量
+type Collector interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	ExportAndClean() []*model2.Bandwidth
+	Stats() Stats
+}
+
+// Stats 收集器启动以来的累计统计，不支持的项为0
+type Stats struct {
+	Name    string `json:"name"`    // 网卡名、cgroup路径或者抓包文件
+	Packets uint64 `json:"packets"` // 统计到的包数
+	Bytes   uint64 `json:"bytes"`   // 统计到的字节数
+	Dropped uint64 `json:"dropped"` // 内核或者网卡丢弃的包数
+}
+
+// FilterSetter 支持运行时替换bpf过滤规则的收集器
+type FilterSetter interface {
+	SetBPFFilter(bpfFilter string) error
+}
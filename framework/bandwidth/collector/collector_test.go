@@ -0,0 +1,191 @@
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestFindInterface(t *testing.T) {
+	interfaces := InterfaceSlice{{Name: "eth0"}, {Name: "eth1"}}
+	addrs := map[string][]net.Addr{
+		"eth0": {&net.IPNet{IP: net.ParseIP("10.0.0.1")}},
+		"eth1": {&net.IPNet{IP: net.ParseIP("10.0.1.1")}, &net.IPNet{IP: net.ParseIP("fe80::1")}},
+	}
+	addrsOf := func(inter net.Interface) ([]net.Addr, error) {
+		return addrs[inter.Name], nil
+	}
+	// 设备的第一个地址不属于网卡，第二个地址匹配
+	inter := interfaces.findInterface([]pcap.InterfaceAddress{{IP: net.ParseIP("fe80::2")}, {IP: net.ParseIP("fe80::1")}}, addrsOf)
+	if inter == nil || inter.Name != "eth1" {
+		t.Fatalf("expected eth1, got %v", inter)
+	}
+	if inter = interfaces.findInterface([]pcap.InterfaceAddress{{IP: net.ParseIP("10.0.2.1")}}, addrsOf); inter != nil {
+		t.Fatalf("expected no interface, got %s", inter.Name)
+	}
+}
+
+type fakeCollector struct {
+	bandwidths []*model2.Bandwidth
+}
+
+func (fakeCollector) Start(ctx context.Context) error       { return nil }
+func (fakeCollector) Stop(ctx context.Context) error        { return nil }
+func (c fakeCollector) ExportAndClean() []*model2.Bandwidth { return c.bandwidths }
+func (fakeCollector) Stats() Stats                          { return Stats{Name: "fake"} }
+
+func TestRegistry(t *testing.T) {
+	if _, err := New("unknown", Options{}); err == nil {
+		t.Fatalf("expected unknown collector type to fail")
+	}
+	Register("fake", func(opts Options) ([]Collector, error) {
+		return []Collector{fakeCollector{}}, nil
+	})
+	collectors, err := New("fake", Options{})
+	if err != nil || len(collectors) != 1 || collectors[0].Stats().Name != "fake" {
+		t.Fatalf("unexpected collectors %v err:%v", collectors, err)
+	}
+	names := fmt.Sprint(Names())
+	if names != "[afpacket ebpf fake pcap procnetdev replay]" {
+		t.Fatalf("unexpected names %s", names)
+	}
+}
+
+const procNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    %d      10    0    0    0     0          0         0     %d      10    0    0    0     0       0          0
+  eth0: %d    200    0    3    0     0          0         0 %d     100    0    1    0     0       0          0
+`
+
+func TestParseProcNetDev(t *testing.T) {
+	counters, err := parseProcNetDev([]byte(fmt.Sprintf(procNetDev, 100, 100, 5000, 800)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := devCounters{RxBytes: 5000, RxPackets: 200, RxDrop: 3, TxBytes: 800, TxPackets: 100, TxDrop: 1}
+	if len(counters) != 2 || counters["eth0"] != want {
+		t.Fatalf("unexpected counters %+v", counters)
+	}
+	if _, err = parseProcNetDev([]byte("a\nb\neth0: 1 2 3\n")); err == nil {
+		t.Fatalf("expected short line to fail")
+	}
+}
+
+func TestProcNetDevCollector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dev")
+	write := func(rx, tx int) {
+		if err := os.WriteFile(path, []byte(fmt.Sprintf(procNetDev, 1, 1, rx, tx)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(5000, 800)
+	c := NewProcNetDevCollector(path, []net.Interface{{Name: "eth0"}})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	write(6000, 1000)
+	bandwidths := c.ExportAndClean()
+	if len(bandwidths) != 1 || bandwidths[0].DownLen != 1000 || bandwidths[0].UpLen != 200 {
+		t.Fatalf("unexpected bandwidths %v", bandwidths)
+	}
+	if stats := c.Stats(); stats.Bytes != 1200 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// testPacket 构造一个本机10.0.0.1:9000的UDP包，up为true时是上行，包长14+20+8+100字节
+func testPacket(t testing.TB, local net.HardwareAddr, up bool) []byte {
+	remote := net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	ethernet := &layers.Ethernet{SrcMAC: remote, DstMAC: local, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.IP{10, 0, 0, 2}, DstIP: net.IP{10, 0, 0, 1}}
+	if up {
+		ethernet.SrcMAC, ethernet.DstMAC = local, remote
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+	}
+	udp := &layers.UDP{SrcPort: 9000, DstPort: 9000}
+	udp.SetNetworkLayerForChecksum(ip)
+	buffer := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		ethernet, ip, udp, gopacket.Payload(make([]byte, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func writeTestPcap(t *testing.T, path string, local net.HardwareAddr, packets int) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := pcapgo.NewWriter(file)
+	if err = writer.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < packets; i++ {
+		data := testPacket(t, local, i%2 == 1)
+		err = writer.WritePacket(gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReplayCollector(t *testing.T) {
+	local := net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	path := filepath.Join(t.TempDir(), "replay.pcap")
+	writeTestPcap(t, path, local, 3)
+	collectors, err := New(TypeReplay, Options{ReplayFile: path, MacAddress: local.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := collectors[0]
+	if err = c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	bandwidths := c.ExportAndClean()
+	sort.Slice(bandwidths, func(i, j int) bool { return bandwidths[i].Ip < bandwidths[j].Ip })
+	if len(bandwidths) != 1 || bandwidths[0].Ip != "10.0.0.1" || bandwidths[0].DownLen != 284 || bandwidths[0].UpLen != 142 {
+		t.Fatalf("unexpected bandwidths %v", bandwidths)
+	}
+	if stats := c.Stats(); stats.Packets != 3 || stats.Bytes != 426 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if err = NewReplayCollector(filepath.Join(t.TempDir(), "missing"), "").Start(context.Background()); err == nil {
+		t.Fatalf("expected missing file to fail")
+	}
+}
+
+func TestPacketPipeline(t *testing.T) {
+	local := net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	counter := newPacketCounter(local.String())
+	pipeline := newPacketPipeline(counter, 8)
+	down, up := testPacket(t, local, false), testPacket(t, local, true)
+	pushed := 0
+	for i := 0; i < 1000; i++ {
+		packetData := down
+		if i%2 == 1 {
+			packetData = up
+		}
+		if pipeline.push(packetData) {
+			pushed++
+		}
+	}
+	pipeline.close()
+	if uint64(pushed)+pipeline.dropped() != 1000 || counter.packets.Load() != uint64(pushed) {
+		t.Fatalf("pushed %d dropped %d handled %d", pushed, pipeline.dropped(), counter.packets.Load())
+	}
+}
@@ -0,0 +1,240 @@
+//go:build linux
+// +build linux
+
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+const (
+	defaultCgroupPath  = "/sys/fs/cgroup"
+	defaultMaxFlows    = 65536
+	afInet             = 2
+	ipProtocolOffset   = 9
+	bpfNoExist         = 1
+	skbLenOffset       = 0
+	skbFamilyOffset    = 88
+	skbRemoteIP4Offset = 92
+	skbLocalIP4Offset  = 96
+	skbRemotePortOffet = 132
+	skbLocalPortOffset = 136
+)
+
+// EBPFCollector 在cgroup上挂载cgroup_skb程序，内核里按5元组累计字节数，不需要把每个包拷贝到用户态
+// 只统计cgroup里进程收发的IPv4流量，ExportAndClean导出并删除map里的计数，得到两次之间的增量
+type EBPFCollector struct {
+	cgroupPath string
+	maxFlows   uint32
+	mutex      sync.Mutex
+	flows      *ebpf.Map
+	programs   []*ebpf.Program
+	links      []link.Link
+	table      *flowTable
+	lastTime   int64
+}
+
+func NewEBPFCollector(cgroupPath string) *EBPFCollector {
+	if len(cgroupPath) == 0 {
+		cgroupPath = defaultCgroupPath
+	}
+	return &EBPFCollector{cgroupPath: cgroupPath, maxFlows: defaultMaxFlows, table: newFlowTable()}
+}
+
+func (c *EBPFCollector) Start(ctx context.Context) (err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	defer func() {
+		if err != nil {
+			c.close()
+		}
+	}()
+	if err = rlimit.RemoveMemlock(); err != nil {
+		return err
+	}
+	c.flows, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "bandwidth_flows",
+		Type:       ebpf.Hash,
+		KeySize:    flowKeySize,
+		ValueSize:  flowValueSize,
+		MaxEntries: c.maxFlows,
+	})
+	if err != nil {
+		return fmt.Errorf("create flow map failure err:%w", err)
+	}
+	// hash map的lookup and delete需要linux 5.14
+	probe := make([]byte, flowValueSize)
+	if err = c.flows.LookupAndDelete(make([]byte, flowKeySize), probe); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return fmt.Errorf("flow map does not support lookup and delete err:%w", err)
+	}
+	for _, attach := range []struct {
+		attachType ebpf.AttachType
+		direction  uint8
+	}{
+		{ebpf.AttachCGroupInetIngress, flowDirectionIngress},
+		{ebpf.AttachCGroupInetEgress, flowDirectionEgress},
+	} {
+		program, err := ebpf.NewProgram(flowProgramSpec(c.flows, attach.direction))
+		if err != nil {
+			return fmt.Errorf("load flow program failure err:%w", err)
+		}
+		c.programs = append(c.programs, program)
+		l, err := link.AttachCgroup(link.CgroupOptions{Path: c.cgroupPath, Attach: attach.attachType, Program: program})
+		if err != nil {
+			return fmt.Errorf("attach flow program to %s failure err:%w", c.cgroupPath, err)
+		}
+		c.links = append(c.links, l)
+	}
+	c.lastTime = time.Now().Unix()
+	log.Infof("cgroup %s :ebpf collector start success", c.cgroupPath)
+	return nil
+}
+
+func (c *EBPFCollector) Stop(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.close()
+	log.Infof("cgroup %s :ebpf collector stop success", c.cgroupPath)
+	return nil
+}
+
+func (c *EBPFCollector) close() {
+	for _, l := range c.links {
+		l.Close()
+	}
+	for _, program := range c.programs {
+		program.Close()
+	}
+	if c.flows != nil {
+		c.flows.Close()
+	}
+	c.links, c.programs, c.flows = nil, nil, nil
+}
+
+// ExportAndClean 先取出所有连接的key，再逐个原子地读取并删除，读取和删除之间内核累加的字节不会丢失，
+// 删除之后的包在map里重新创建，下一次导出
+func (c *EBPFCollector) ExportAndClean() []*model2.Bandwidth {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.flows == nil {
+		return nil
+	}
+	var keys [][]byte
+	keyData, valueData := make([]byte, flowKeySize), make([]byte, flowValueSize)
+	iterator := c.flows.Iterate()
+	for iterator.Next(keyData, valueData) {
+		keys = append(keys, append([]byte(nil), keyData...))
+	}
+	if err := iterator.Err(); err != nil {
+		log.Warnf("iterate flow map failure err:%v", err)
+	}
+	flows := make(map[flowKey]flowStats, len(keys))
+	for _, raw := range keys {
+		if err := c.flows.LookupAndDelete(raw, valueData); err != nil {
+			if !errors.Is(err, ebpf.ErrKeyNotExist) {
+				log.Warnf("lookup and delete flow failure err:%v", err)
+			}
+			continue
+		}
+		key, err := decodeFlowKey(raw)
+		if err != nil {
+			log.Warnf("decode flow key failure err:%v", err)
+			continue
+		}
+		value, err := decodeFlowStats(valueData)
+		if err != nil {
+			log.Warnf("decode flow value failure err:%v", err)
+			continue
+		}
+		flows[key] = value
+	}
+	endTime := time.Now().Unix()
+	result := c.table.export(flows, "", c.lastTime, endTime)
+	c.lastTime = endTime
+	return result
+}
+
+func (c *EBPFCollector) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return Stats{Name: c.cgroupPath, Packets: c.table.packets, Bytes: c.table.bytes}
+}
+
+// flowProgramSpec cgroup_skb程序，按flowKey把包长累加到map里
+// 没有clang的环境也能加载，所以直接用指令编写，逻辑等价于：
+//
+//	if (skb->family != AF_INET) return 1;
+//	key = {skb->local_ip4, skb->remote_ip4, skb->local_port, skb->remote_port, ip->protocol, direction};
+//	value = bpf_map_lookup_elem(&flows, &key);
+//	if (value) { __sync_fetch_and_add(&value->bytes, skb->len); __sync_fetch_and_add(&value->packets, 1); }
+//	else { init = {skb->len, 1}; bpf_map_update_elem(&flows, &key, &init, BPF_NOEXIST); }
+//	return 1;
+func flowProgramSpec(flows *ebpf.Map, direction uint8) *ebpf.ProgramSpec {
+	return &ebpf.ProgramSpec{
+		Name:    "bandwidth_flow",
+		Type:    ebpf.CGroupSKB,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Reg(asm.R6, asm.R1),
+			asm.Mov.Imm(asm.R1, 0),
+			asm.StoreMem(asm.RFP, -8, asm.R1, asm.DWord),
+			asm.StoreMem(asm.RFP, -16, asm.R1, asm.DWord),
+			asm.StoreMem(asm.RFP, -24, asm.R1, asm.DWord),
+			asm.LoadMem(asm.R2, asm.R6, skbFamilyOffset, asm.Word),
+			asm.JNE.Imm(asm.R2, afInet, "out"),
+			asm.LoadMem(asm.R2, asm.R6, skbLocalIP4Offset, asm.Word),
+			asm.StoreMem(asm.RFP, -24, asm.R2, asm.Word),
+			asm.LoadMem(asm.R2, asm.R6, skbRemoteIP4Offset, asm.Word),
+			asm.StoreMem(asm.RFP, -20, asm.R2, asm.Word),
+			asm.LoadMem(asm.R2, asm.R6, skbLocalPortOffset, asm.Word),
+			asm.StoreMem(asm.RFP, -16, asm.R2, asm.Word),
+			asm.LoadMem(asm.R2, asm.R6, skbRemotePortOffet, asm.Word),
+			asm.StoreMem(asm.RFP, -12, asm.R2, asm.Word),
+			asm.StoreImm(asm.RFP, -7, int64(direction), asm.Byte),
+			// bpf_skb_load_bytes(skb, 9, &key.protocol, 1)
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.Mov.Imm(asm.R2, ipProtocolOffset),
+			asm.Mov.Reg(asm.R3, asm.RFP),
+			asm.Add.Imm(asm.R3, -8),
+			asm.Mov.Imm(asm.R4, 1),
+			asm.FnSkbLoadBytes.Call(),
+			// bpf_map_lookup_elem(&flows, &key)
+			asm.LoadMapPtr(asm.R1, flows.FD()),
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, -24),
+			asm.FnMapLookupElem.Call(),
+			asm.JEq.Imm(asm.R0, 0, "init"),
+			asm.LoadMem(asm.R1, asm.R6, skbLenOffset, asm.Word),
+			asm.StoreXAdd(asm.R0, asm.R1, asm.DWord),
+			asm.Mov.Imm(asm.R1, 1),
+			asm.Add.Imm(asm.R0, 8),
+			asm.StoreXAdd(asm.R0, asm.R1, asm.DWord),
+			asm.Ja.Label("out"),
+			// bpf_map_update_elem(&flows, &key, &init, BPF_NOEXIST)
+			asm.LoadMem(asm.R1, asm.R6, skbLenOffset, asm.Word).WithSymbol("init"),
+			asm.StoreMem(asm.RFP, -40, asm.R1, asm.DWord),
+			asm.Mov.Imm(asm.R1, 1),
+			asm.StoreMem(asm.RFP, -32, asm.R1, asm.DWord),
+			asm.LoadMapPtr(asm.R1, flows.FD()),
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, -24),
+			asm.Mov.Reg(asm.R3, asm.RFP),
+			asm.Add.Imm(asm.R3, -40),
+			asm.Mov.Imm(asm.R4, bpfNoExist),
+			asm.FnMapUpdateElem.Call(),
+			asm.Mov.Imm(asm.R0, 1).WithSymbol("out"),
+			asm.Return(),
+		},
+	}
+}
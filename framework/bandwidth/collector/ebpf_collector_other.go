@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"context"
+	"errors"
+)
+
+// EBPFCollector 只支持linux
+type EBPFCollector struct{}
+
+func NewEBPFCollector(cgroupPath string) *EBPFCollector {
+	return &EBPFCollector{}
+}
+
+func (c *EBPFCollector) Start(ctx context.Context) error {
+	return errors.New("ebpf collector is only supported on linux")
+}
+
+func (c *EBPFCollector) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (c *EBPFCollector) ExportAndClean() []*model2.Bandwidth {
+	return nil
+}
+
+func (c *EBPFCollector) Stats() Stats {
+	return Stats{}
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// eBPF map里的key和value布局，和flowProgram写入的内容保持一致
+//
+//	key:   local_ip4(4,网络字节序) remote_ip4(4,网络字节序) local_port(4,主机字节序) remote_port(4,网络字节序) protocol(1) direction(1) pad(2)
+//	value: bytes(8,主机字节序) packets(8,主机字节序)
+const (
+	flowKeySize   = 20
+	flowValueSize = 16
+
+	flowDirectionIngress = 0
+	flowDirectionEgress  = 1
+)
+
+// flowKey 一条连接的5元组加方向
+type flowKey struct {
+	LocalIP    string
+	RemoteIP   string
+	LocalPort  uint16
+	RemotePort uint16
+	Protocol   uint8
+	Direction  uint8
+}
+
+type flowStats struct {
+	Bytes   uint64
+	Packets uint64
+}
+
+func decodeFlowKey(data []byte) (flowKey, error) {
+	if len(data) != flowKeySize {
+		return flowKey{}, fmt.Errorf("invalid flow key size %d", len(data))
+	}
+	key := flowKey{
+		LocalIP:    net.IP(data[0:4]).String(),
+		RemoteIP:   net.IP(data[4:8]).String(),
+		LocalPort:  uint16(binary.NativeEndian.Uint32(data[8:12])),
+		RemotePort: decodeRemotePort(data[12:16]),
+		Protocol:   data[16],
+		Direction:  data[17],
+	}
+	return key, nil
+}
+
+// decodeRemotePort __sk_buff.remote_port是网络字节序的端口，部分内核会把它左移16位放在高位
+func decodeRemotePort(data []byte) uint16 {
+	if port := binary.BigEndian.Uint16(data[2:4]); port != 0 {
+		return port
+	}
+	return binary.BigEndian.Uint16(data[0:2])
+}
+
+func decodeFlowStats(data []byte) (flowStats, error) {
+	if len(data) != flowValueSize {
+		return flowStats{}, fmt.Errorf("invalid flow value size %d", len(data))
+	}
+	return flowStats{
+		Bytes:   binary.NativeEndian.Uint64(data[0:8]),
+		Packets: binary.NativeEndian.Uint64(data[8:16]),
+	}, nil
+}
+
+// flowTable 按本地ip:port汇总导出的连接，记录启动以来的累计值
+type flowTable struct {
+	bytes   uint64 // 启动以来导出的累计值
+	packets uint64
+}
+
+func newFlowTable() *flowTable {
+	return &flowTable{}
+}
+
+// export 把从内核map里取出并删除的计数按本地ip:port汇总成Bandwidth，计数就是两次导出之间的增量
+func (t *flowTable) export(flows map[flowKey]flowStats, macAddress string, startTime, endTime int64) []*model2.Bandwidth {
+	stats := map[string]*model2.Bandwidth{}
+	for key, value := range flows {
+		if value.Bytes == 0 {
+			continue
+		}
+		t.bytes += value.Bytes
+		t.packets += value.Packets
+		port := strconv.Itoa(int(key.LocalPort))
+		bandwidth, exist := stats[key.LocalIP+":"+port]
+		if !exist {
+			bandwidth = &model2.Bandwidth{
+				MacAddress:  macAddress,
+				Ip:          key.LocalIP,
+				Port:        port,
+				StartTime:   startTime,
+				CollectTime: endTime,
+			}
+			stats[key.LocalIP+":"+port] = bandwidth
+		}
+		trafficType := model2.Down
+		if key.Direction == flowDirectionEgress {
+			trafficType = model2.Up
+		}
+		bandwidth.AddPacketLen(int32(value.Bytes), trafficType)
+	}
+	result := make([]*model2.Bandwidth, 0, len(stats))
+	for _, bandwidth := range stats {
+		result = append(result, bandwidth)
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func rawFlowKey(local, remote [4]byte, localPort uint32, remotePort uint16, shifted bool, direction uint8) []byte {
+	data := make([]byte, flowKeySize)
+	copy(data[0:4], local[:])
+	copy(data[4:8], remote[:])
+	binary.NativeEndian.PutUint32(data[8:12], localPort)
+	if shifted {
+		binary.BigEndian.PutUint16(data[14:16], remotePort)
+	} else {
+		binary.BigEndian.PutUint16(data[12:14], remotePort)
+	}
+	data[16] = 17
+	data[17] = direction
+	return data
+}
+
+func rawFlowStats(bytes, packets uint64) []byte {
+	data := make([]byte, flowValueSize)
+	binary.NativeEndian.PutUint64(data[0:8], bytes)
+	binary.NativeEndian.PutUint64(data[8:16], packets)
+	return data
+}
+
+func TestDecodeFlow(t *testing.T) {
+	for _, shifted := range []bool{false, true} {
+		key, err := decodeFlowKey(rawFlowKey([4]byte{10, 0, 0, 1}, [4]byte{1, 2, 3, 4}, 8000, 51234, shifted, flowDirectionEgress))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.LocalIP != "10.0.0.1" || key.RemoteIP != "1.2.3.4" || key.LocalPort != 8000 ||
+			key.RemotePort != 51234 || key.Protocol != 17 || key.Direction != flowDirectionEgress {
+			t.Fatalf("unexpected flow key %+v", key)
+		}
+	}
+	value, err := decodeFlowStats(rawFlowStats(1500, 3))
+	if err != nil || value.Bytes != 1500 || value.Packets != 3 {
+		t.Fatalf("unexpected flow stats %+v err:%v", value, err)
+	}
+	if _, err = decodeFlowKey(make([]byte, 3)); err == nil {
+		t.Fatalf("expected short key to fail")
+	}
+	if _, err = decodeFlowStats(make([]byte, 3)); err == nil {
+		t.Fatalf("expected short value to fail")
+	}
+}
+
+func TestFlowTableExport(t *testing.T) {
+	key := func(remote byte, direction uint8) flowKey {
+		k, _ := decodeFlowKey(rawFlowKey([4]byte{10, 0, 0, 1}, [4]byte{1, 1, 1, remote}, 8000, 40000, false, direction))
+		return k
+	}
+	format := func(bandwidths []*model2.Bandwidth) string {
+		var result []string
+		for _, b := range bandwidths {
+			result = append(result, fmt.Sprintf("%s:%s/%d/%d", b.Ip, b.Port, b.UpLen, b.DownLen))
+		}
+		sort.Strings(result)
+		return fmt.Sprint(result)
+	}
+	table := newFlowTable()
+	result := table.export(map[flowKey]flowStats{
+		key(1, flowDirectionIngress): {Bytes: 1000, Packets: 1},
+		key(2, flowDirectionIngress): {Bytes: 500, Packets: 1},
+		key(1, flowDirectionEgress):  {Bytes: 100, Packets: 1},
+	}, "", 0, 10)
+	if got := format(result); got != "[10.0.0.1:8000/100/1500]" {
+		t.Fatalf("unexpected first export %s", got)
+	}
+	// map里的计数导出后被删除，再次导出的就是增量
+	result = table.export(map[flowKey]flowStats{
+		key(1, flowDirectionIngress): {Bytes: 600, Packets: 1},
+		key(2, flowDirectionIngress): {},
+	}, "", 10, 20)
+	if got := format(result); got != "[10.0.0.1:8000/0/600]" {
+		t.Fatalf("unexpected second export %s", got)
+	}
+	if table.bytes != 2200 || table.packets != 4 {
+		t.Fatalf("unexpected totals %d %d", table.bytes, table.packets)
+	}
+}
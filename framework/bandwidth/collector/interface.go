@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"net"
+
+	"github.com/google/gopacket/pcap"
+)
+
+type InterfaceSlice []net.Interface
+
+// FindInterface 找到pcap设备对应的网卡，设备的任意一个地址属于网卡就认为匹配
+func (ifs InterfaceSlice) FindInterface(pIfs []pcap.InterfaceAddress) *net.Interface {
+	return ifs.findInterface(pIfs, func(inter net.Interface) ([]net.Addr, error) {
+		return inter.Addrs()
+	})
+}
+
+func (ifs InterfaceSlice) findInterface(pIfs []pcap.InterfaceAddress, addrsOf func(net.Interface) ([]net.Addr, error)) *net.Interface {
+	for index := range ifs {
+		addrs, err := addrsOf(ifs[index])
+		if err != nil {
+			continue
+		}
+		var ips []string
+		for _, addr := range addrs {
+			if a, ok := addr.(*net.IPNet); ok {
+				ips = append(ips, a.IP.String())
+			}
+		}
+		for _, pIf := range pIfs {
+			if containStr(ips, pIf.IP.String()) {
+				return &ifs[index]
+			}
+		}
+	}
+	return nil
+}
+
+func containStr(set []string, target string) bool {
+	for _, str := range set {
+		if str == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	dropKernel    = "kernel"
+	dropInterface = "interface"
+	dropQueue     = "queue" // 解析跟不上读包，用户态队列满了
+)
+
+var (
+	collectorPacketsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "collector_packets_received_total",
+		Help:      "number of packets received by the pcap handle",
+	}, []string{"device"})
+	collectorPacketsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "collector_packets_dropped_total",
+		Help:      "number of packets dropped by the kernel, the interface or the user space queue",
+	}, []string{"device", "reason"})
+)
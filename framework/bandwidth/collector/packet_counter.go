@@ -0,0 +1,103 @@
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// packetCounter 解析以太网帧，按本地ip:port统计上下行流量，pcap、afpacket和replay收集器共用
+// 目的MAC是本机的包是下行，源MAC是本机的包是上行
+type packetCounter struct {
+	macAddress        string
+	mutex             sync.Mutex
+	stats             map[string]*model2.Bandwidth
+	lastCollectorTime int64
+	packets           atomic.Uint64
+	bytes             atomic.Uint64
+}
+
+func newPacketCounter(macAddress string) *packetCounter {
+	return &packetCounter{macAddress: macAddress, stats: map[string]*model2.Bandwidth{}}
+}
+
+// handle 统计一个以太网帧，包里的数据不会被保存，可以传入复用的缓冲区
+func (pc *packetCounter) handle(packetData []byte) {
+	// 只获取以太网帧
+	packet := gopacket.NewPacket(packetData, layers.LayerTypeEthernet, gopacket.NoCopy)
+	ethernetLayer := packet.Layer(layers.LayerTypeEthernet)
+	ipPort := parseIpPortInfo(packet)
+	if ethernetLayer == nil || ipPort == nil {
+		return
+	}
+	pc.packets.Add(1)
+	pc.bytes.Add(uint64(len(packetData)))
+	ethernet := ethernetLayer.(*layers.Ethernet)
+	if ethernet.DstMAC.String() == pc.macAddress {
+		pc.addPacketLen(len(packetData), ipPort.dstIP, ipPort.dstPort, model2.Down)
+	} else if ethernet.SrcMAC.String() == pc.macAddress {
+		pc.addPacketLen(len(packetData), ipPort.srcIP, ipPort.srcPort, model2.Up)
+	}
+}
+
+func parseIpPortInfo(packet gopacket.Packet) *IpPortInfo {
+	networkLayer := packet.NetworkLayer()
+	if networkLayer == nil {
+		return nil
+	}
+	srcIp, dstIp := networkLayer.NetworkFlow().Endpoints()
+	// 解析传输层数据
+	transportLayer := packet.TransportLayer()
+	if transportLayer == nil {
+		return nil
+	}
+	srcPort := transportLayer.TransportFlow().Src().String()
+	dstPort := transportLayer.TransportFlow().Dst().String()
+	return &IpPortInfo{
+		srcIP:   srcIp.String(),
+		srcPort: srcPort,
+		dstIP:   dstIp.String(),
+		dstPort: dstPort,
+	}
+}
+
+type IpPortInfo struct {
+	srcIP   string
+	srcPort string
+	dstIP   string
+	dstPort string
+}
+
+func (pc *packetCounter) addPacketLen(pcapDataLen int, ip string, port string, trafficType model2.TrafficType) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	bandwidth, ok := pc.stats[ip+":"+port]
+	if !ok {
+		bandwidth = &model2.Bandwidth{
+			MacAddress: pc.macAddress,
+			Ip:         ip,
+			Port:       port,
+			StartTime:  pc.lastCollectorTime,
+		}
+		pc.stats[ip+":"+port] = bandwidth
+	}
+	bandwidth.AddPacketLen(int32(pcapDataLen), trafficType)
+}
+
+func (pc *packetCounter) export() []*model2.Bandwidth {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	var result []*model2.Bandwidth
+	endTime := time.Now().Unix()
+	for _, v := range pc.stats {
+		v.CollectTime = endTime
+		result = append(result, v)
+	}
+	pc.lastCollectorTime = endTime
+	pc.stats = make(map[string]*model2.Bandwidth)
+	return result
+}
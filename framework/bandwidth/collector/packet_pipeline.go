@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"accumulation/pkg/ringbuff"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+const (
+	defaultPacketQueueLen = 4096
+	drainBatchSize        = 64
+)
+
+// packetPipeline 把读包和解析拆到两个goroutine，读包的goroutine只拷贝数据放入无锁队列，
+// 解析慢的时候由队列缓冲，不会拖慢读包导致内核丢包，队列满时丢弃并计数
+type packetPipeline struct {
+	counter *packetCounter
+	queue   *ringbuff.SPSC[[]byte]
+	idle    atomic.Bool // 解析的goroutine没有数据在等待唤醒
+	wake    chan struct{}
+	closed  chan struct{}
+	done    chan struct{}
+}
+
+func newPacketPipeline(counter *packetCounter, capacity int) *packetPipeline {
+	pipeline := &packetPipeline{
+		counter: counter,
+		queue:   ringbuff.NewSPSC[[]byte](capacity),
+		wake:    make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go pipeline.run()
+	return pipeline
+}
+
+// push 只能在读包的goroutine里调用，packetData会被拷贝，队列满时返回false
+func (p *packetPipeline) push(packetData []byte) bool {
+	if !p.queue.Offer(append([]byte(nil), packetData...)) {
+		return false
+	}
+	if p.idle.Load() && p.idle.CompareAndSwap(true, false) {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// close 读包结束后调用，等待队列里剩下的数据解析完
+func (p *packetPipeline) close() {
+	close(p.closed)
+	<-p.done
+}
+
+func (p *packetPipeline) run() {
+	defer close(p.done)
+	defer func() {
+		if e := recover(); e != nil {
+			log.Errorf("packetPipeline panic|err=%v|stack=%v", e, string(debug.Stack()))
+		}
+	}()
+	var batch [][]byte
+	for {
+		batch = p.queue.Drain(batch[:0], drainBatchSize)
+		for _, packetData := range batch {
+			p.counter.handle(packetData)
+		}
+		if len(batch) > 0 {
+			continue
+		}
+		p.idle.Store(true)
+		// 设置idle之后再检查一次，避免和push交错丢失唤醒
+		if p.queue.Len() > 0 {
+			p.idle.Store(false)
+			continue
+		}
+		select {
+		case <-p.wake:
+		case <-p.closed:
+			for _, packetData := range p.queue.Drain(nil, 0) {
+				p.counter.handle(packetData)
+			}
+			return
+		}
+	}
+}
+
+func (p *packetPipeline) dropped() uint64 {
+	return p.queue.Dropped()
+}
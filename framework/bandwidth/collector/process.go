@@ -0,0 +1,278 @@
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+const (
+	defaultProcRoot               = "/proc"
+	defaultProcessRefreshInterval = 10 * time.Second
+	minProcessRefreshInterval     = time.Second // 查不到进程时提前刷新的最小间隔，同一个地址一直查不到时间隔翻倍
+)
+
+// procNetFiles 按socket本地地址查inode的文件，tcp和udp的同一个端口认为属于同一个进程
+var procNetFiles = []string{"net/tcp", "net/tcp6", "net/udp", "net/udp6"}
+
+// Process 流量所属的进程
+type Process struct {
+	Pid  int32
+	Name string
+}
+
+// missBackoff 查不到进程的地址下一次可以触发提前刷新的时间
+type missBackoff struct {
+	next  time.Time
+	delay time.Duration
+}
+
+// ProcessResolver 通过/proc/net/{tcp,udp}里socket的inode和/proc/[pid]/fd找到本地ip:port所属的进程
+// 映射关系定期刷新，刷新之间新建的连接查不到时提前刷新，同一个地址一直查不到时按地址退避，
+// 避免短连接或者其他命名空间的流量每次导出都遍历所有进程的fd
+type ProcessResolver struct {
+	root        string
+	interval    time.Duration
+	mutex       sync.Mutex
+	sockets     map[string]Process // ip:port -> 进程，监听在0.0.0.0/::上的socket用*:port
+	misses      map[string]*missBackoff
+	refreshTime time.Time
+	now         func() time.Time
+}
+
+func NewProcessResolver(root string, interval time.Duration) *ProcessResolver {
+	if len(root) == 0 {
+		root = defaultProcRoot
+	}
+	if interval <= 0 {
+		interval = defaultProcessRefreshInterval
+	}
+	return &ProcessResolver{root: root, interval: interval, sockets: map[string]Process{}, misses: map[string]*missBackoff{}, now: time.Now}
+}
+
+// Annotate 给流量填上所属的进程，查不到的保持为空
+func (r *ProcessResolver) Annotate(bandwidths []*model2.Bandwidth) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := r.now()
+	if now.Sub(r.refreshTime) >= r.interval {
+		r.refresh(now)
+	}
+	var missed []*model2.Bandwidth
+	due := false
+	for _, bandwidth := range bandwidths {
+		if r.annotate(bandwidth) {
+			continue
+		}
+		missed = append(missed, bandwidth)
+		if miss, ok := r.misses[net.JoinHostPort(bandwidth.Ip, bandwidth.Port)]; !ok || !now.Before(miss.next) {
+			due = true
+		}
+	}
+	if len(missed) == 0 {
+		return
+	}
+	if due && now.Sub(r.refreshTime) >= minProcessRefreshInterval {
+		r.refresh(now)
+	}
+	for _, bandwidth := range missed {
+		key := net.JoinHostPort(bandwidth.Ip, bandwidth.Port)
+		if r.annotate(bandwidth) {
+			delete(r.misses, key)
+			continue
+		}
+		miss, ok := r.misses[key]
+		if !ok {
+			r.misses[key] = &missBackoff{next: now.Add(minProcessRefreshInterval), delay: minProcessRefreshInterval}
+			continue
+		}
+		if !now.Before(miss.next) {
+			miss.delay = min(2*miss.delay, r.interval)
+			miss.next = now.Add(miss.delay)
+		}
+	}
+}
+
+func (r *ProcessResolver) annotate(bandwidth *model2.Bandwidth) bool {
+	process, ok := r.sockets[net.JoinHostPort(bandwidth.Ip, bandwidth.Port)]
+	if !ok {
+		process, ok = r.sockets[net.JoinHostPort("*", bandwidth.Port)]
+	}
+	if ok {
+		bandwidth.Pid, bandwidth.Process = process.Pid, process.Name
+	}
+	return ok
+}
+
+func (r *ProcessResolver) refresh(now time.Time) {
+	r.refreshTime = now
+	// 很久没有再出现的地址不再退避
+	for key, miss := range r.misses {
+		if now.Sub(miss.next) >= r.interval {
+			delete(r.misses, key)
+		}
+	}
+	sockets, err := r.resolve()
+	if err != nil {
+		log.Warnf("resolve socket process failure err:%v", err)
+		return
+	}
+	r.sockets = sockets
+}
+
+// resolve 读取当前所有socket所属的进程
+func (r *ProcessResolver) resolve() (map[string]Process, error) {
+	inodes := map[string]string{} // inode -> ip:port
+	for _, name := range procNetFiles {
+		content, err := os.ReadFile(filepath.Join(r.root, name))
+		if err != nil {
+			// 没有开启ipv6时没有tcp6/udp6
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if err = parseProcNet(content, inodes); err != nil {
+			return nil, fmt.Errorf("parse %s failure err:%w", name, err)
+		}
+	}
+	owners, err := r.socketOwners(inodes)
+	if err != nil {
+		return nil, err
+	}
+	sockets := make(map[string]Process, len(owners))
+	for inode, process := range owners {
+		sockets[inodes[inode]] = process
+	}
+	return sockets, nil
+}
+
+// socketOwners 遍历/proc/[pid]/fd找到inode所属的进程，没有权限读取的进程跳过
+func (r *ProcessResolver) socketOwners(inodes map[string]string) (map[string]Process, error) {
+	entries, err := os.ReadDir(r.root)
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]Process{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		fdDir := filepath.Join(r.root, entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		var process *Process
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if _, ok := inodes[inode]; !ok {
+				continue
+			}
+			if process == nil {
+				comm, _ := os.ReadFile(filepath.Join(r.root, entry.Name(), "comm"))
+				process = &Process{Pid: int32(pid), Name: strings.TrimSpace(string(comm))}
+			}
+			owners[inode] = *process
+		}
+	}
+	return owners, nil
+}
+
+// parseProcNet 解析/proc/net/{tcp,udp}[6]，第一行是表头，第2列是本地地址，第10列是inode
+func parseProcNet(content []byte, inodes map[string]string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 0; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if line == 0 || len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 10 {
+			return fmt.Errorf("invalid line %q", scanner.Text())
+		}
+		// inode为0的是已经关闭的连接
+		if fields[9] == "0" {
+			continue
+		}
+		address, err := parseProcNetAddress(fields[1])
+		if err != nil {
+			return err
+		}
+		inodes[fields[9]] = address
+	}
+	return scanner.Err()
+}
+
+// parseProcNetAddress 解析 "0100007F:1F90" 格式的地址，ip按32位分组的主机字节序存储，端口是大端十六进制
+func parseProcNetAddress(address string) (string, error) {
+	hexIP, hexPort, ok := strings.Cut(address, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid address %s", address)
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s err:%w", address, err)
+	}
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", fmt.Errorf("invalid address %s", address)
+	}
+	ip := make(net.IP, len(raw))
+	for index := 0; index < len(raw); index += 4 {
+		binary.BigEndian.PutUint32(ip[index:], binary.NativeEndian.Uint32(raw[index:]))
+	}
+	host := ip.String()
+	if ip.IsUnspecified() {
+		host = "*"
+	} else if v4 := ip.To4(); v4 != nil {
+		// ipv4映射的ipv6地址按ipv4处理，和收集器里的ip保持一致
+		host = v4.String()
+	}
+	return net.JoinHostPort(host, strconv.FormatUint(port, 10)), nil
+}
+
+// processCollector 给被包装的收集器导出的流量填上所属的进程
+type processCollector struct {
+	Collector
+	resolver *ProcessResolver
+}
+
+func (c *processCollector) ExportAndClean() []*model2.Bandwidth {
+	bandwidths := c.Collector.ExportAndClean()
+	c.resolver.Annotate(bandwidths)
+	return bandwidths
+}
+
+// FilterSetter 被包装的收集器支持修改过滤规则时透传
+func (c *processCollector) SetBPFFilter(bpfFilter string) error {
+	if setter, ok := c.Collector.(FilterSetter); ok {
+		return setter.SetBPFFilter(bpfFilter)
+	}
+	return nil
+}
+
+// WithProcess 包装收集器，导出的流量按本地ip:port关联到进程，多个收集器可以共用一个resolver
+func WithProcess(collectors []Collector, resolver *ProcessResolver) []Collector {
+	result := make([]Collector, 0, len(collectors))
+	for _, collector := range collectors {
+		result = append(result, &processCollector{Collector: collector, resolver: resolver})
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseProcNetAddress(t *testing.T) {
+	for address, want := range map[string]string{
+		"0100007F:1F90":                         "127.0.0.1:8080",
+		"00000000:2328":                         "*:9000",
+		"0000000000000000FFFF00000100000A:0050": "10.0.0.1:80",
+		"00000000000000000000000000000000:0035": "*:53",
+		"000080FE00000000FF565002BDC4A7FE:01BB": "[fe80::250:56ff:fea7:c4bd]:443",
+	} {
+		got, err := parseProcNetAddress(address)
+		if err != nil || got != want {
+			t.Fatalf("parse %s got %s err:%v, want %s", address, got, err, want)
+		}
+	}
+	if _, err := parseProcNetAddress("0100007F"); err == nil {
+		t.Fatalf("expected address without port to fail")
+	}
+}
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:2328 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100000A:C350 0200000A:0050 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 20 4 30 10 -1
+   2: 0100000A:C351 0200000A:0050 06 00000000:00000000 03:00000000 00000000     0        0 0 3 0000000000000000
+`
+
+// writeProcTree 构造一个/proc目录，进程100是game，拥有inode 1001，进程200是sidecar，拥有inode 1002
+func writeProcTree(t *testing.T, root string) {
+	mustWrite := func(name, content string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustLink := func(name, target string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite("net/tcp", procNetTCP)
+	mustWrite("net/udp", "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n")
+	mustWrite("100/comm", "game\n")
+	mustLink("100/fd/0", "/dev/null")
+	mustLink("100/fd/3", "socket:[1001]")
+	mustWrite("200/comm", "sidecar\n")
+	mustLink("200/fd/5", "socket:[1002]")
+	mustWrite("self/comm", "self\n")
+}
+
+func TestProcessResolver(t *testing.T) {
+	root := t.TempDir()
+	writeProcTree(t, root)
+	resolver := NewProcessResolver(root, time.Minute)
+	bandwidths := []*model2.Bandwidth{
+		{Ip: "10.0.0.1", Port: "9000"},
+		{Ip: "10.0.0.1", Port: "50000"},
+		{Ip: "10.0.0.1", Port: "50001"},
+	}
+	resolver.Annotate(bandwidths)
+	if bandwidths[0].Pid != 100 || bandwidths[0].Process != "game" {
+		t.Fatalf("expected listening port to belong to game, got %d %s", bandwidths[0].Pid, bandwidths[0].Process)
+	}
+	if bandwidths[1].Pid != 200 || bandwidths[1].Process != "sidecar" {
+		t.Fatalf("expected connection to belong to sidecar, got %d %s", bandwidths[1].Pid, bandwidths[1].Process)
+	}
+	if bandwidths[2].Pid != 0 {
+		t.Fatalf("expected closed connection to be unknown")
+	}
+}
+
+func TestProcessResolverMissBackoff(t *testing.T) {
+	root := t.TempDir()
+	writeProcTree(t, root)
+	now := time.Unix(1000, 0)
+	resolver := NewProcessResolver(root, time.Minute)
+	resolver.now = func() time.Time { return now }
+	missed := func() []*model2.Bandwidth { return []*model2.Bandwidth{{Ip: "10.0.0.1", Port: "50001"}} }
+	resolver.Annotate(missed())
+	// 查不到的地址在退避时间内不触发刷新，之后间隔翻倍
+	for _, step := range []struct {
+		after   time.Duration
+		refresh bool
+	}{
+		{time.Second, true},
+		{time.Second, false},
+		{time.Second, true},
+		{3 * time.Second, false},
+		{time.Second, true},
+	} {
+		now = now.Add(step.after)
+		last := resolver.refreshTime
+		resolver.Annotate(missed())
+		if refreshed := resolver.refreshTime != last; refreshed != step.refresh {
+			t.Fatalf("at %v expected refresh %v, got %v", now.Unix(), step.refresh, refreshed)
+		}
+	}
+	// 新出现的地址不受其他地址的退避影响
+	now = now.Add(time.Second)
+	last := resolver.refreshTime
+	resolver.Annotate([]*model2.Bandwidth{{Ip: "10.0.0.1", Port: "50002"}})
+	if resolver.refreshTime == last {
+		t.Fatal("expected new missed address to refresh")
+	}
+}
+
+func TestWithProcess(t *testing.T) {
+	root := t.TempDir()
+	writeProcTree(t, root)
+	collectors := WithProcess([]Collector{fakeCollector{bandwidths: []*model2.Bandwidth{{Ip: "10.0.0.1", Port: "9000"}}}},
+		NewProcessResolver(root, time.Minute))
+	bandwidths := collectors[0].ExportAndClean()
+	if len(bandwidths) != 1 || bandwidths[0].Process != "game" {
+		t.Fatalf("unexpected bandwidths %v", bandwidths)
+	}
+}
@@ -0,0 +1,163 @@
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+const defaultProcNetDevPath = "/proc/net/dev"
+
+// devCounters /proc/net/dev里一个网卡的累计计数
+type devCounters struct {
+	RxBytes, RxPackets, RxDrop uint64
+	TxBytes, TxPackets, TxDrop uint64
+}
+
+// ProcNetDevCollector 读取/proc/net/dev的网卡计数，只能统计到网卡级别，导出的Bandwidth的Port为空，Ip是网卡的第一个IPv4地址
+// 开销最小，适合只需要整机带宽的场景；会话配置了串流端口时不能按端口区分，网卡的流量都算作串流流量(见StreamEndpoint.Stream)
+type ProcNetDevCollector struct {
+	path       string
+	interfaces map[string]net.Interface
+	mutex      sync.Mutex
+	first      map[string]devCounters
+	last       map[string]devCounters
+	lastTime   int64
+}
+
+func NewProcNetDevCollector(path string, interfaces []net.Interface) *ProcNetDevCollector {
+	c := &ProcNetDevCollector{path: path, interfaces: map[string]net.Interface{}}
+	for _, inter := range interfaces {
+		c.interfaces[inter.Name] = inter
+	}
+	return c
+}
+
+func (c *ProcNetDevCollector) Start(ctx context.Context) error {
+	counters, err := c.read()
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.first, c.last = counters, counters
+	c.lastTime = time.Now().Unix()
+	return nil
+}
+
+func (c *ProcNetDevCollector) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (c *ProcNetDevCollector) ExportAndClean() []*model2.Bandwidth {
+	counters, err := c.read()
+	if err != nil {
+		log.Warnf("read %s failure err:%v", c.path, err)
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	endTime := time.Now().Unix()
+	var result []*model2.Bandwidth
+	for name, current := range counters {
+		inter, ok := c.interfaces[name]
+		if !ok {
+			continue
+		}
+		last, ok := c.last[name]
+		// 计数回绕或者网卡重建时从0开始算
+		if !ok || current.RxBytes < last.RxBytes || current.TxBytes < last.TxBytes {
+			last = devCounters{}
+		}
+		result = append(result, &model2.Bandwidth{
+			MacAddress:  inter.HardwareAddr.String(),
+			Ip:          interfaceIPv4(inter),
+			UpLen:       int32(current.TxBytes - last.TxBytes),
+			DownLen:     int32(current.RxBytes - last.RxBytes),
+			StartTime:   c.lastTime,
+			CollectTime: endTime,
+		})
+	}
+	c.last = counters
+	c.lastTime = endTime
+	return result
+}
+
+func (c *ProcNetDevCollector) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := Stats{Name: c.path}
+	for name, last := range c.last {
+		if _, ok := c.interfaces[name]; !ok {
+			continue
+		}
+		first := c.first[name]
+		stats.Packets += last.RxPackets + last.TxPackets - first.RxPackets - first.TxPackets
+		stats.Bytes += last.RxBytes + last.TxBytes - first.RxBytes - first.TxBytes
+		stats.Dropped += last.RxDrop + last.TxDrop - first.RxDrop - first.TxDrop
+	}
+	return stats
+}
+
+func (c *ProcNetDevCollector) read() (map[string]devCounters, error) {
+	content, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	return parseProcNetDev(content)
+}
+
+// parseProcNetDev 解析/proc/net/dev，前两行是表头，之后每行是 "网卡名: 接收的8列 发送的8列"
+func parseProcNetDev(content []byte) (map[string]devCounters, error) {
+	result := map[string]devCounters{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 0; scanner.Scan(); line++ {
+		if line < 2 {
+			continue
+		}
+		name, values, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid /proc/net/dev line %q", scanner.Text())
+		}
+		fields := strings.Fields(values)
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("invalid /proc/net/dev line %q", scanner.Text())
+		}
+		numbers := make([]uint64, 16)
+		for index := range numbers {
+			number, err := strconv.ParseUint(fields[index], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid /proc/net/dev line %q err:%w", scanner.Text(), err)
+			}
+			numbers[index] = number
+		}
+		result[strings.TrimSpace(name)] = devCounters{
+			RxBytes: numbers[0], RxPackets: numbers[1], RxDrop: numbers[3],
+			TxBytes: numbers[8], TxPackets: numbers[9], TxDrop: numbers[11],
+		}
+	}
+	return result, scanner.Err()
+}
+
+func interfaceIPv4(inter net.Interface) string {
+	addrs, err := inter.Addrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"accumulation/pkg/nnet"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/gopacket/pcap"
+)
+
+const (
+	TypePcap       = "pcap"
+	TypeAFPacket   = "afpacket"
+	TypeEBPF       = "ebpf"
+	TypeReplay     = "replay"
+	TypeProcNetDev = "procnetdev"
+)
+
+// Options 创建收集器的参数，不同的收集器只使用其中的一部分
+type Options struct {
+	BpfFilter  string           // pcap的过滤规则
+	CgroupPath string           // ebpf挂载的cgroup
+	ReplayFile string           // replay读取的抓包文件
+	MacAddress string           // replay判断上下行使用的本机MAC
+	Interfaces []net.Interface  // pcap/afpacket/procnetdev使用的网卡，为空时使用所有有效网卡
+	Process    *ProcessResolver // 不为空时导出的流量关联到所属的进程
+}
+
+func (opts Options) interfaces() ([]net.Interface, error) {
+	if len(opts.Interfaces) > 0 {
+		return opts.Interfaces, nil
+	}
+	return nnet.GetValidInterfaces()
+}
+
+// Factory 按配置创建一组收集器
+type Factory func(opts Options) ([]Collector, error)
+
+var (
+	registryMutex sync.RWMutex
+	factories     = map[string]Factory{}
+)
+
+func init() {
+	Register(TypePcap, newPcapCollectors)
+	Register(TypeAFPacket, newAFPacketCollectors)
+	Register(TypeEBPF, func(opts Options) ([]Collector, error) {
+		return []Collector{NewEBPFCollector(opts.CgroupPath)}, nil
+	})
+	Register(TypeReplay, func(opts Options) ([]Collector, error) {
+		return []Collector{NewReplayCollector(opts.ReplayFile, opts.MacAddress)}, nil
+	})
+	Register(TypeProcNetDev, func(opts Options) ([]Collector, error) {
+		interfaces, err := opts.interfaces()
+		if err != nil {
+			return nil, err
+		}
+		return []Collector{NewProcNetDevCollector(defaultProcNetDevPath, interfaces)}, nil
+	})
+}
+
+// Register 注册收集器实现，同名的实现会被替换
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	factories[name] = factory
+}
+
+// New 创建name对应的收集器，name为空时使用pcap
+func New(name string, opts Options) ([]Collector, error) {
+	if len(name) == 0 {
+		name = TypePcap
+	}
+	registryMutex.RLock()
+	factory, ok := factories[name]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown collector type %s, supported:%v", name, Names())
+	}
+	collectors, err := factory(opts)
+	if err != nil || opts.Process == nil {
+		return collectors, err
+	}
+	return WithProcess(collectors, opts.Process), nil
+}
+
+// Names 已经注册的收集器
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newPcapCollectors 给每个有ip的有效网卡创建一个pcap收集器
+func newPcapCollectors(opts Options) ([]Collector, error) {
+	interfaces, err := opts.interfaces()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		log.Errorf("pcap findAllDevs err :%v", err)
+		return nil, err
+	}
+	var collectors []Collector
+	for _, d := range devices {
+		inter := InterfaceSlice(interfaces).FindInterface(d.Addresses)
+		if inter != nil {
+			collectors = append(collectors,
+				NewBandwidthCollector(d.Name, opts.BpfFilter, inter.HardwareAddr.String()))
+		}
+	}
+	return collectors, nil
+}
+
+func newAFPacketCollectors(opts Options) ([]Collector, error) {
+	interfaces, err := opts.interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var collectors []Collector
+	for _, inter := range interfaces {
+		collectors = append(collectors, NewAFPacketCollector(inter.Name, inter.HardwareAddr.String()))
+	}
+	return collectors, nil
+}
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	model2 "accumulation/framework/bandwidth/model"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// ReplayCollector 回放pcap抓包文件，Start时读取整个文件，用于离线分析和测试
+type ReplayCollector struct {
+	path    string
+	counter *packetCounter
+}
+
+func NewReplayCollector(path, macAddress string) *ReplayCollector {
+	return &ReplayCollector{path: path, counter: newPacketCounter(macAddress)}
+}
+
+func (c *ReplayCollector) Start(ctx context.Context) error {
+	file, err := os.Open(c.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("read pcap file %s failure err:%w", c.path, err)
+	}
+	if reader.LinkType() != layers.LinkTypeEthernet {
+		return fmt.Errorf("unsupported link type %s of %s", reader.LinkType(), c.path)
+	}
+	for ctx.Err() == nil {
+		packetData, _, err := reader.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// 文件最后一个包不完整时停止回放
+			log.Warnf("replay %s stopped err:%v", c.path, err)
+			break
+		}
+		c.counter.handle(packetData)
+	}
+	log.Infof("replay %s finished, packets:%d", c.path, c.counter.packets.Load())
+	return ctx.Err()
+}
+
+func (c *ReplayCollector) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (c *ReplayCollector) ExportAndClean() []*model2.Bandwidth {
+	return c.counter.export()
+}
+
+func (c *ReplayCollector) Stats() Stats {
+	return Stats{Name: c.path, Packets: c.counter.packets.Load(), Bytes: c.counter.bytes.Load()}
+}
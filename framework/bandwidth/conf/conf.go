@@ -1,17 +1,61 @@
 package conf
 
 import (
+	"time"
+
 	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+const (
+	defaultBackendReportInterval = 10 * time.Second
+	defaultCollectInterval       = 5 * time.Second
+)
+
 type Acl_ReportConfig struct {
 	Host                  string               `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
 	BackendReportInterval int32                `protobuf:"varint,2,opt,name=backend_report_interval,json=backendReportInterval,proto3" json:"backend_report_interval,omitempty"` //上报周期
 	BpfFilter             string               `protobuf:"bytes,3,opt,name=bpf_filter,json=bpfFilter,proto3" json:"bpf_filter,omitempty"`
 	SessionTimeout        *durationpb.Duration `protobuf:"bytes,4,opt,name=session_timeout,json=sessionTimeout,proto3" json:"session_timeout,omitempty"`
-	ReportJobBufLen       int32                `protobuf:"varint,5,opt,name=report_job_buf_len,json=reportJobBufLen,proto3" json:"report_job_buf_len,omitempty"` //report 的buf长度
-	EngineBufLen          int32                `protobuf:"varint,6,opt,name=engine_buf_len,json=engineBufLen,proto3" json:"engine_buf_len,omitempty"`            //engine的buf长度
+	ReportJobBufLen       int32                `protobuf:"varint,5,opt,name=report_job_buf_len,json=reportJobBufLen,proto3" json:"report_job_buf_len,omitempty"`        //report 的buf长度
+	EngineBufLen          int32                `protobuf:"varint,6,opt,name=engine_buf_len,json=engineBufLen,proto3" json:"engine_buf_len,omitempty"`                   //engine的buf长度
+	SpillDir              string               `protobuf:"bytes,7,opt,name=spill_dir,json=spillDir,proto3" json:"spill_dir,omitempty"`                                  //上报数据落盘目录
+	SpillSegmentSize      int64                `protobuf:"varint,8,opt,name=spill_segment_size,json=spillSegmentSize,proto3" json:"spill_segment_size,omitempty"`       //落盘段文件大小
+	SpillFsyncPolicy      string               `protobuf:"bytes,9,opt,name=spill_fsync_policy,json=spillFsyncPolicy,proto3" json:"spill_fsync_policy,omitempty"`        //刷盘策略 none/always/interval
+	SpillFsyncInterval    *durationpb.Duration `protobuf:"bytes,10,opt,name=spill_fsync_interval,json=spillFsyncInterval,proto3" json:"spill_fsync_interval,omitempty"` //定时刷盘周期
+	ReportBatchSize       int32                `protobuf:"varint,11,opt,name=report_batch_size,json=reportBatchSize,proto3" json:"report_batch_size,omitempty"`         //一次上报的最大条数
+	ReportBatchWait       *durationpb.Duration `protobuf:"bytes,12,opt,name=report_batch_wait,json=reportBatchWait,proto3" json:"report_batch_wait,omitempty"`          //凑批的最长等待时间
+	ReportMaxBackoff      *durationpb.Duration `protobuf:"bytes,13,opt,name=report_max_backoff,json=reportMaxBackoff,proto3" json:"report_max_backoff,omitempty"`       //上报失败重试的最大间隔
+	DeadLetterPath        string               `protobuf:"bytes,14,opt,name=dead_letter_path,json=deadLetterPath,proto3" json:"dead_letter_path,omitempty"`             //被拒绝的上报请求保存的文件
+	ReportProtocol        string               `protobuf:"bytes,15,opt,name=report_protocol,json=reportProtocol,proto3" json:"report_protocol,omitempty"`               //上报协议 grpc/http，默认grpc
+	CollectInterval       *durationpb.Duration `protobuf:"bytes,16,opt,name=collect_interval,json=collectInterval,proto3" json:"collect_interval,omitempty"`            //engine从collector导出流量的周期
+	AnomalyWebhook        string               `protobuf:"bytes,17,opt,name=anomaly_webhook,json=anomalyWebhook,proto3" json:"anomaly_webhook,omitempty"`               //流量异常事件通知的webhook地址
+	AnomalyDebounce       *durationpb.Duration `protobuf:"bytes,18,opt,name=anomaly_debounce,json=anomalyDebounce,proto3" json:"anomaly_debounce,omitempty"`            //同一个会话同一类异常的最小通知间隔
+	CollectorType         string               `protobuf:"bytes,19,opt,name=collector_type,json=collectorType,proto3" json:"collector_type,omitempty"`                  //流量收集方式 pcap/afpacket/ebpf/replay/procnetdev，默认pcap
+	CgroupPath            string               `protobuf:"bytes,20,opt,name=cgroup_path,json=cgroupPath,proto3" json:"cgroup_path,omitempty"`                           //ebpf收集器挂载的cgroup，默认/sys/fs/cgroup
+	ReplayFile            string               `protobuf:"bytes,21,opt,name=replay_file,json=replayFile,proto3" json:"replay_file,omitempty"`                           //replay收集器回放的pcap文件
+	ReplayMacAddress      string               `protobuf:"bytes,22,opt,name=replay_mac_address,json=replayMacAddress,proto3" json:"replay_mac_address,omitempty"`       //回放时区分上下行的本机MAC
+	ProcessAttribution    bool                 `protobuf:"varint,23,opt,name=process_attribution,json=processAttribution,proto3" json:"process_attribution,omitempty"`  //流量是否关联到进程
+	ProcessRefresh        *durationpb.Duration `protobuf:"bytes,24,opt,name=process_refresh,json=processRefresh,proto3" json:"process_refresh,omitempty"`               //socket和进程对应关系的刷新周期，默认10秒
+	ShapingMode           string               `protobuf:"bytes,25,opt,name=shaping_mode,json=shapingMode,proto3" json:"shaping_mode,omitempty"`                        //会话限速方式 tc/dry_run，默认不限速
+}
+
+// ReportInterval 上报周期，没有配置时返回默认值10秒
+// 不用protobuf生成的GetXxx命名，避免和字段原始类型的getter混淆
+func (x *Acl_ReportConfig) ReportInterval() time.Duration {
+	if x == nil || x.BackendReportInterval <= 0 {
+		return defaultBackendReportInterval
+	}
+	return time.Duration(x.BackendReportInterval) * time.Second
+}
+
+// CollectPeriod 导出流量的周期，没有配置时返回默认值5秒
+func (x *Acl_ReportConfig) CollectPeriod() time.Duration {
+	if x == nil || x.CollectInterval == nil || x.CollectInterval.AsDuration() <= 0 {
+		return defaultCollectInterval
+	}
+	return x.CollectInterval.AsDuration()
 }
+
 type Acl struct {
 	ReportConfig *Acl_ReportConfig `protobuf:"bytes,6,opt,name=reportConfig,proto3" json:"reportConfig,omitempty"`
 }
@@ -0,0 +1,218 @@
+package conf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+const defaultWatchInterval = 5 * time.Second
+
+// CronSpec 把周期转换成cron表达式，能整除一分钟的秒数按整秒对齐，其他周期用@every
+func CronSpec(interval time.Duration) string {
+	seconds := int64(interval / time.Second)
+	if interval%time.Second == 0 && seconds > 0 && 60%seconds == 0 {
+		return fmt.Sprintf("*/%d * * * * *", seconds)
+	}
+	return fmt.Sprintf("@every %s", interval)
+}
+
+// ErrNotModified 配置没有变化
+var ErrNotModified = errors.New("config not modified")
+
+// Source 配置来源，返回的内容是json格式的Data
+type Source interface {
+	Load(ctx context.Context) ([]byte, error)
+}
+
+// FileSource 从本地文件读取配置，文件修改时间和大小都没有变化的时候返回ErrNotModified
+type FileSource struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+func (s *FileSource) Load(ctx context.Context) ([]byte, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil, ErrNotModified
+	}
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return content, nil
+}
+
+// HTTPSource 从http接口拉取配置，支持ETag，服务端返回304时返回ErrNotModified
+type HTTPSource struct {
+	url    string
+	client *http.Client
+	etag   string
+}
+
+func NewHTTPSource(url string) *HTTPSource {
+	return &HTTPSource{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *HTTPSource) Load(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.etag) > 0 {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, ErrNotModified
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("load config from %s failure status:%d", s.url, resp.StatusCode)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.etag = resp.Header.Get("ETag")
+	return content, nil
+}
+
+// Watcher 定时从Source加载配置，配置内容变化时通知观察者
+type Watcher struct {
+	source    Source
+	interval  time.Duration
+	reloading sync.Mutex // 串行化Reload，Source不需要并发安全
+	mutex     sync.Mutex
+	content   []byte
+	current   *Data
+	observers []func(ctx context.Context, data *Data)
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func NewWatcher(source Source, interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	return &Watcher{source: source, interval: interval}
+}
+
+// OnChange 注册观察者，配置变化时按注册顺序调用
+func (w *Watcher) OnChange(observer func(ctx context.Context, data *Data)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.observers = append(w.observers, observer)
+}
+
+// Current 最近一次加载成功的配置
+func (w *Watcher) Current() *Data {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.current
+}
+
+// Start 先同步加载一次配置，失败直接返回，成功后在后台定时重新加载
+func (w *Watcher) Start(ctx context.Context) error {
+	if _, err := w.Reload(ctx); err != nil {
+		return err
+	}
+	ctx, w.cancel = context.WithCancel(context.Background())
+	w.done = make(chan struct{})
+	go w.loop(ctx)
+	return nil
+}
+
+func (w *Watcher) Stop(ctx context.Context) error {
+	if w.cancel == nil {
+		return nil
+	}
+	w.cancel()
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (w *Watcher) loop(ctx context.Context) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Reload(ctx); err != nil {
+				log.Warnf("reload config failure, keep current config err:%v", err)
+			}
+		}
+	}
+}
+
+// Reload 加载配置，内容有变化时通知观察者，返回配置是否变化
+// 解析失败的配置不会生效，继续使用之前的配置
+func (w *Watcher) Reload(ctx context.Context) (bool, error) {
+	w.reloading.Lock()
+	defer w.reloading.Unlock()
+	content, err := w.source.Load(ctx)
+	if errors.Is(err, ErrNotModified) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	w.mutex.Lock()
+	if bytes.Equal(content, w.content) {
+		w.mutex.Unlock()
+		return false, nil
+	}
+	data := &Data{}
+	if err = json.Unmarshal(content, data); err != nil {
+		w.mutex.Unlock()
+		return false, fmt.Errorf("parse config failure err:%w", err)
+	}
+	w.content = content
+	w.current = data
+	observers := append([]func(ctx context.Context, data *Data){}, w.observers...)
+	w.mutex.Unlock()
+	for _, observer := range observers {
+		w.notify(ctx, observer, data)
+	}
+	return true, nil
+}
+
+func (w *Watcher) notify(ctx context.Context, observer func(ctx context.Context, data *Data), data *Data) {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Errorf("config observer panic|err=%v|stack=%v", e, string(debug.Stack()))
+		}
+	}()
+	observer(ctx, data)
+}
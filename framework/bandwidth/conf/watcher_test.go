@@ -0,0 +1,110 @@
+package conf
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCronSpec(t *testing.T) {
+	for interval, want := range map[time.Duration]string{
+		5 * time.Second:         "*/5 * * * * *",
+		10 * time.Second:        "*/10 * * * * *",
+		7 * time.Second:         "@every 7s",
+		1500 * time.Millisecond: "@every 1.5s",
+		2 * time.Minute:         "@every 2m0s",
+	} {
+		if got := CronSpec(interval); got != want {
+			t.Fatalf("CronSpec(%v) got %q, want %q", interval, got, want)
+		}
+	}
+}
+
+func TestFileWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write(`{"acl":{"reportConfig":{"bpf_filter":"udp"}}}`, now)
+	watcher := NewWatcher(NewFileSource(path), time.Hour)
+	var filters []string
+	watcher.OnChange(func(ctx context.Context, data *Data) {
+		filters = append(filters, data.Acl.ReportConfig.BpfFilter)
+	})
+	ctx := context.Background()
+	if err := watcher.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop(ctx)
+
+	if changed, err := watcher.Reload(ctx); err != nil || changed {
+		t.Fatalf("expected unchanged file to be skipped, changed:%v err:%v", changed, err)
+	}
+	write(`{"acl":{"reportConfig":{"bpf_filter":"tcp","collect_interval":{"seconds":2}}}}`, now.Add(time.Second))
+	if changed, err := watcher.Reload(ctx); err != nil || !changed {
+		t.Fatalf("expected config to change, changed:%v err:%v", changed, err)
+	}
+	if got := watcher.Current().Acl.ReportConfig.CollectPeriod(); got != 2*time.Second {
+		t.Fatalf("unexpected collect interval %v", got)
+	}
+	// 解析失败的配置不生效
+	write(`{"acl":`, now.Add(2*time.Second))
+	if _, err := watcher.Reload(ctx); err == nil {
+		t.Fatalf("expected broken config to fail")
+	}
+	if got := watcher.Current().Acl.ReportConfig.BpfFilter; got != "tcp" {
+		t.Fatalf("expected previous config to be kept, got %q", got)
+	}
+	if len(filters) != 2 || filters[0] != "udp" || filters[1] != "tcp" {
+		t.Fatalf("unexpected notifications %v", filters)
+	}
+}
+
+func TestHTTPWatcher(t *testing.T) {
+	var requests, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"acl":{"reportConfig":{"engine_buf_len":100}}}`))
+	}))
+	defer server.Close()
+
+	watcher := NewWatcher(NewHTTPSource(server.URL), 10*time.Millisecond)
+	var changes atomic.Int32
+	watcher.OnChange(func(ctx context.Context, data *Data) {
+		changes.Add(1)
+	})
+	ctx := context.Background()
+	if err := watcher.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for notModified.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected watcher to poll with etag, requests:%d", requests.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := watcher.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if changes.Load() != 1 || watcher.Current().Acl.ReportConfig.EngineBufLen != 100 {
+		t.Fatalf("expected exactly one change, got %d", changes.Load())
+	}
+}
@@ -1,10 +1,12 @@
 package model
 
 import (
+	v1 "accumulation/framework/bandwidth/api/v1"
 	"encoding/json"
 	"fmt"
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/tidwall/gjson"
+	"google.golang.org/protobuf/proto"
 
 	"sort"
 )
@@ -19,6 +21,8 @@ type Bandwidth struct {
 	DownLen     int32
 	StartTime   int64
 	CollectTime int64
+	Pid         int32  // 本地ip:port所属的进程，没有开启进程关联或者查不到时为0
+	Process     string // 进程名
 }
 
 func (b *Bandwidth) Print() {
@@ -101,6 +105,7 @@ type GameStarted struct {
 	Idc          string       `json:"idc"`         //机房
 	StreamIp     string       `json:"stream_ip"`
 	StreamPorts  []StreamPort `json:"stream_ports"`
+	StreamProcs  []string     `json:"stream_processes"` //串流进程名，流量关联到进程时只统计这些进程的流量
 	Extra        string       `json:"extra"`
 	ImageVersion int          `json:"image_version"`
 	RuntimeInfo  string       `json:"runtime_info"`
@@ -141,6 +146,32 @@ func (gameStarted *GameStarted) Unmarshal(data []byte) error {
 	return nil
 }
 
+// AccessInfo 串流地址分配通知，StreamPort是StreamPort数组的json
+type AccessInfo struct {
+	VMid       int64  `json:"vmid"`
+	StreamIp   string `json:"stream_ip"`
+	StreamPort string `json:"stream_port"`
+}
+
+func (accessInfo *AccessInfo) Unmarshal(data []byte) error {
+	err := json.Unmarshal(data, accessInfo)
+	if err != nil {
+		return fmt.Errorf("err:%v,body:%s", err, string(data))
+	}
+	return nil
+}
+
+func (accessInfo *AccessInfo) StreamPorts() (StreamPorts, error) {
+	var streamPorts StreamPorts
+	if len(accessInfo.StreamPort) == 0 {
+		return streamPorts, nil
+	}
+	if err := json.Unmarshal([]byte(accessInfo.StreamPort), &streamPorts); err != nil {
+		return nil, fmt.Errorf("err:%v,stream_port:%s", err, accessInfo.StreamPort)
+	}
+	return streamPorts, nil
+}
+
 type GameStop struct {
 	Start      int64  `json:"start"`
 	FlowID     string `json:"flow_id"`
@@ -166,16 +197,17 @@ type Serialization[R any] interface {
 	Instance() R
 }
 type ReportFlowBizRequest struct {
+	v1.ReportFlowBizRequest
 }
 
 func (r *ReportFlowBizRequest) Encode() ([]byte, error) {
-	return nil, nil /*proto.Marshal(&r.ReportFlowBizRequest)*/
+	return proto.Marshal(&r.ReportFlowBizRequest)
 }
 
 func (r *ReportFlowBizRequest) Decode(data []byte) error {
-	/*	if err := proto.Unmarshal(data, &r.ReportFlowBizRequest); err != nil {
+	if err := proto.Unmarshal(data, &r.ReportFlowBizRequest); err != nil {
 		return err
-	}*/
+	}
 	return nil
 }
 func (r *ReportFlowBizRequest) Instance() *ReportFlowBizRequest {
@@ -26,6 +26,7 @@ type Session struct {
 	Idc          string      `json:"idc"`         //机房
 	StreamIp     string      `json:"stream_ip"`
 	StreamPorts  StreamPorts `json:"stream_port"`
+	StreamProcs  StreamProcs `json:"stream_processes"`
 	Extra        string      `json:"extra"`
 	EIP          int32       `json:"eip"`
 	ImageVersion int         `json:"image_version"`
@@ -39,6 +40,37 @@ func (session *Session) String() string {
 	return string(data)
 }
 
+// StreamEndpoint 会话的串流地址，串流地址可能在会话开始之后才分配
+type StreamEndpoint struct {
+	StreamIp    string      `json:"stream_ip"`
+	StreamPorts StreamPorts `json:"stream_port"`
+	StreamProcs StreamProcs `json:"stream_processes"`
+}
+
+// Stream 流量是否属于串流，配置了串流端口时端口要匹配，配置了串流进程并且流量关联到了进程时进程也要匹配，
+// 没有开启ProcessAttribution时流量没有进程名，不按进程过滤；procnetdev只能统计到网卡级别，流量没有端口，不按端口过滤
+func (endpoint *StreamEndpoint) Stream(bandwidth *Bandwidth) bool {
+	if len(endpoint.StreamPorts) > 0 && len(bandwidth.Port) > 0 && !endpoint.StreamPorts.Contains(bandwidth.Port) {
+		return false
+	}
+	if len(endpoint.StreamProcs) > 0 && len(bandwidth.Process) > 0 && !endpoint.StreamProcs.Contains(bandwidth.Process) {
+		return false
+	}
+	return true
+}
+
+// StreamProcs 串流进程名
+type StreamProcs []string
+
+func (sps StreamProcs) Contains(process string) bool {
+	for _, sp := range sps {
+		if sp == process {
+			return true
+		}
+	}
+	return false
+}
+
 type StreamPorts []StreamPort
 
 func (sps StreamPorts) Contains(port string) bool {
@@ -0,0 +1,165 @@
+package query
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Direction 统计的流量方向
+type Direction string
+
+const (
+	DirectionAll  Direction = "all"
+	DirectionUp   Direction = "up"
+	DirectionDown Direction = "down"
+)
+
+func ParseDirection(direction string) (Direction, error) {
+	switch Direction(strings.ToLower(direction)) {
+	case "", DirectionAll:
+		return DirectionAll, nil
+	case DirectionUp:
+		return DirectionUp, nil
+	case DirectionDown:
+		return DirectionDown, nil
+	}
+	return DirectionAll, fmt.Errorf("unknown direction %s", direction)
+}
+
+func (d Direction) bytes(bandwidth *model.Bandwidth) int64 {
+	switch d {
+	case DirectionUp:
+		return int64(bandwidth.UpLen)
+	case DirectionDown:
+		return int64(bandwidth.DownLen)
+	}
+	return int64(bandwidth.UpLen) + int64(bandwidth.DownLen)
+}
+
+// Aggregation 每个时间区间内的聚合方式
+type Aggregation string
+
+const (
+	AggregationSum Aggregation = "sum"
+	AggregationAvg Aggregation = "avg"
+	AggregationP95 Aggregation = "p95"
+)
+
+func ParseAggregation(aggregation string) (Aggregation, error) {
+	switch Aggregation(strings.ToLower(aggregation)) {
+	case "", AggregationSum:
+		return AggregationSum, nil
+	case AggregationAvg:
+		return AggregationAvg, nil
+	case AggregationP95:
+		return AggregationP95, nil
+	}
+	return AggregationSum, fmt.Errorf("unknown aggregation %s", aggregation)
+}
+
+// Point 一个时间区间的聚合结果，Time是区间的开始时间
+type Point struct {
+	Time    int64   `json:"time"`
+	Value   float64 `json:"value"`
+	Samples int     `json:"samples"`
+}
+
+// Aggregate 按step把流量分到[start,end)内的时间区间里，每个区间再按aggregation聚合
+// engine里每个采集周期每个ip:port一条数据，同一个采集时间的数据先合并成一个样本，
+// sum是区间内的总字节数，avg和p95是区间内每个采集周期字节数的平均值和95分位(nearest-rank)
+// 没有数据的区间不返回
+func Aggregate(bandwidths []*model.Bandwidth, start, end, step int64,
+	direction Direction, aggregation Aggregation) []Point {
+	if step <= 0 || end <= start {
+		return nil
+	}
+	samples := map[int64]map[int64]int64{}
+	for _, bandwidth := range bandwidths {
+		if bandwidth.CollectTime < start || bandwidth.CollectTime >= end {
+			continue
+		}
+		bucket := start + (bandwidth.CollectTime-start)/step*step
+		if samples[bucket] == nil {
+			samples[bucket] = map[int64]int64{}
+		}
+		samples[bucket][bandwidth.CollectTime] += direction.bytes(bandwidth)
+	}
+	points := make([]Point, 0, len(samples))
+	for bucket, values := range samples {
+		list := make([]int64, 0, len(values))
+		for _, value := range values {
+			list = append(list, value)
+		}
+		points = append(points, Point{Time: bucket, Value: aggregate(list, aggregation), Samples: len(list)})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time < points[j].Time
+	})
+	return points
+}
+
+func aggregate(values []int64, aggregation Aggregation) float64 {
+	var sum int64
+	for _, value := range values {
+		sum += value
+	}
+	switch aggregation {
+	case AggregationAvg:
+		return float64(sum) / float64(len(values))
+	case AggregationP95:
+		sort.Slice(values, func(i, j int) bool {
+			return values[i] < values[j]
+		})
+		rank := int(math.Ceil(0.95 * float64(len(values))))
+		return float64(values[rank-1])
+	}
+	return float64(sum)
+}
+
+// Talker 一个ip或ip:port的流量汇总
+type Talker struct {
+	Ip    string `json:"ip"`
+	Port  string `json:"port,omitempty"`
+	Up    int64  `json:"up"`
+	Down  int64  `json:"down"`
+	Total int64  `json:"total"`
+}
+
+// TopTalkers 按方向统计流量最多的n个ip，byPort为true时按ip:port统计
+func TopTalkers(bandwidths []*model.Bandwidth, n int, direction Direction, byPort bool) []Talker {
+	talkers := map[string]*Talker{}
+	for _, bandwidth := range bandwidths {
+		key, port := bandwidth.Ip, ""
+		if byPort {
+			key, port = bandwidth.Ip+":"+bandwidth.Port, bandwidth.Port
+		}
+		talker, ok := talkers[key]
+		if !ok {
+			talker = &Talker{Ip: bandwidth.Ip, Port: port}
+			talkers[key] = talker
+		}
+		talker.Up += int64(bandwidth.UpLen)
+		talker.Down += int64(bandwidth.DownLen)
+		talker.Total += direction.bytes(bandwidth)
+	}
+	result := make([]Talker, 0, len(talkers))
+	for _, talker := range talkers {
+		result = append(result, *talker)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total != result[j].Total {
+			return result[i].Total > result[j].Total
+		}
+		if result[i].Ip != result[j].Ip {
+			return result[i].Ip < result[j].Ip
+		}
+		return result[i].Port < result[j].Port
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package query
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"fmt"
+	"testing"
+)
+
+func samples() []*model.Bandwidth {
+	var bandwidths []*model.Bandwidth
+	// 每5秒采集一次，两个端口各一条数据
+	for i := int64(0); i < 12; i++ {
+		bandwidths = append(bandwidths,
+			&model.Bandwidth{Ip: "10.0.0.1", Port: "8000", UpLen: int32(i), DownLen: int32(10 * i), CollectTime: 100 + 5*i},
+			&model.Bandwidth{Ip: "10.0.0.2", Port: "9000", UpLen: 1, DownLen: 1, CollectTime: 100 + 5*i})
+	}
+	return bandwidths
+}
+
+func TestAggregate(t *testing.T) {
+	for _, c := range []struct {
+		direction   Direction
+		aggregation Aggregation
+		want        string
+	}{
+		{DirectionUp, AggregationSum, "[{100 21 6} {130 57 6}]"},
+		{DirectionDown, AggregationSum, "[{100 156 6} {130 516 6}]"},
+		{DirectionAll, AggregationAvg, "[{100 29.5 6} {130 95.5 6}]"},
+		{DirectionAll, AggregationP95, "[{100 57 6} {130 123 6}]"},
+	} {
+		got := fmt.Sprint(Aggregate(samples(), 100, 160, 30, c.direction, c.aggregation))
+		if got != c.want {
+			t.Fatalf("%s/%s got %s, want %s", c.direction, c.aggregation, got, c.want)
+		}
+	}
+	if got := Aggregate(samples(), 120, 135, 10, DirectionUp, AggregationSum); fmt.Sprint(got) != "[{120 11 2} {130 7 1}]" {
+		t.Fatalf("unexpected points in partial range %v", got)
+	}
+}
+
+func TestTopTalkers(t *testing.T) {
+	bandwidths := append(samples(), &model.Bandwidth{Ip: "10.0.0.2", Port: "9001", UpLen: 100, CollectTime: 100})
+	got := TopTalkers(bandwidths, 1, DirectionUp, false)
+	if len(got) != 1 || got[0].Ip != "10.0.0.2" || got[0].Up != 112 {
+		t.Fatalf("unexpected top talkers by ip %+v", got)
+	}
+	got = TopTalkers(bandwidths, 0, DirectionDown, true)
+	if len(got) != 3 || got[0].Port != "8000" || got[2].Port != "9001" || got[0].Total != 660 {
+		t.Fatalf("unexpected top talkers by port %+v", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := ParseDirection("sideways"); err == nil {
+		t.Fatalf("expected unknown direction to fail")
+	}
+	if _, err := ParseAggregation("p99"); err == nil {
+		t.Fatalf("expected unknown aggregation to fail")
+	}
+	for value, want := range map[string]int64{"": 60, "30": 30, "5m": 300} {
+		if got, err := parseStep(value); err != nil || got != want {
+			t.Fatalf("parse step %q got %d err:%v", value, got, err)
+		}
+	}
+	for _, value := range []string{"0", "500ms", "abc"} {
+		if _, err := parseStep(value); err == nil {
+			t.Fatalf("expected step %q to fail", value)
+		}
+	}
+}
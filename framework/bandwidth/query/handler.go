@@ -0,0 +1,254 @@
+package query
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/gorilla/mux"
+)
+
+const (
+	TrafficPath = "/bandwidth/v1/traffic"
+	TopPath     = "/bandwidth/v1/top"
+
+	defaultRange = 10 * time.Minute
+	defaultStep  = time.Minute
+	defaultTopN  = 10
+	maxPoints    = 10000
+)
+
+// Querier 查询流量的数据源，api.BandwidthReportManager实现了该接口，
+// 从保存的会话流量里查询，会话结束后在保留时间内仍然可以按session查询
+type Querier interface {
+	QueryBandwidth(ctx context.Context, filter model.Filters, startTime, endTime int64) ([]*model.Bandwidth, error)
+	StreamEndpoint(sessionKey string) (*model.StreamEndpoint, bool)
+}
+
+type Handler struct {
+	querier Querier
+	now     func() time.Time
+}
+
+func NewHandler(querier Querier) *Handler {
+	return &Handler{querier: querier, now: time.Now}
+}
+
+// Register 注册查询接口
+//
+//	GET /bandwidth/v1/traffic?start=&end=&ip=&port=&session=&direction=all|up|down&step=60s&agg=sum|avg|p95
+//	GET /bandwidth/v1/top?start=&end=&ip=&port=&session=&direction=all|up|down&n=10&by=ip|port
+//
+// start和end是unix秒，默认查询最近10分钟；session是会话的SessionKey，按会话的串流ip和端口过滤
+func (h *Handler) Register(router *mux.Router) {
+	router.HandleFunc(TrafficPath, h.Traffic).Methods(http.MethodGet).Name("bandwidth_traffic")
+	router.HandleFunc(TopPath, h.Top).Methods(http.MethodGet).Name("bandwidth_top")
+}
+
+type TrafficResp struct {
+	Start       int64       `json:"start"`
+	End         int64       `json:"end"`
+	Step        int64       `json:"step"`
+	Direction   Direction   `json:"direction"`
+	Aggregation Aggregation `json:"aggregation"`
+	Points      []Point     `json:"points"`
+}
+
+type TopResp struct {
+	Start     int64     `json:"start"`
+	End       int64     `json:"end"`
+	Direction Direction `json:"direction"`
+	Talkers   []Talker  `json:"talkers"`
+}
+
+type errorResp struct {
+	Error string `json:"error"`
+}
+
+// params 两个接口共用的查询参数
+type params struct {
+	start, end int64
+	direction  Direction
+	filter     model.Filters
+}
+
+func (h *Handler) Traffic(w http.ResponseWriter, r *http.Request) {
+	p, err := h.parseParams(r)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	step, err := parseStep(query.Get("step"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if (p.end-p.start)/step > maxPoints {
+		h.writeError(w, badRequest("too many points, increase step"))
+		return
+	}
+	aggregation, err := ParseAggregation(query.Get("agg"))
+	if err != nil {
+		h.writeError(w, badRequest(err.Error()))
+		return
+	}
+	bandwidths, err := h.querier.QueryBandwidth(r.Context(), p.filter, p.start, p.end)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, &TrafficResp{
+		Start:       p.start,
+		End:         p.end,
+		Step:        step,
+		Direction:   p.direction,
+		Aggregation: aggregation,
+		Points:      Aggregate(bandwidths, p.start, p.end, step, p.direction, aggregation),
+	})
+}
+
+func (h *Handler) Top(w http.ResponseWriter, r *http.Request) {
+	p, err := h.parseParams(r)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	n := defaultTopN
+	if value := query.Get("n"); len(value) > 0 {
+		if n, err = strconv.Atoi(value); err != nil || n <= 0 {
+			h.writeError(w, badRequest("invalid n "+value))
+			return
+		}
+	}
+	var byPort bool
+	switch by := query.Get("by"); by {
+	case "", "ip":
+	case "port":
+		byPort = true
+	default:
+		h.writeError(w, badRequest("unknown by "+by))
+		return
+	}
+	bandwidths, err := h.querier.QueryBandwidth(r.Context(), p.filter, p.start, p.end)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, &TopResp{
+		Start:     p.start,
+		End:       p.end,
+		Direction: p.direction,
+		Talkers:   TopTalkers(bandwidths, n, p.direction, byPort),
+	})
+}
+
+func (h *Handler) parseParams(r *http.Request) (*params, error) {
+	query := r.URL.Query()
+	end := h.now().Unix()
+	if value := query.Get("end"); len(value) > 0 {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, badRequest("invalid end " + value)
+		}
+		end = parsed
+	}
+	start := end - int64(defaultRange/time.Second)
+	if value := query.Get("start"); len(value) > 0 {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, badRequest("invalid start " + value)
+		}
+		start = parsed
+	}
+	if end <= start {
+		return nil, badRequest("end must be after start")
+	}
+	direction, err := ParseDirection(query.Get("direction"))
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+	ip, port := query.Get("ip"), query.Get("port")
+	var endpoint *model.StreamEndpoint
+	if sessionKey := query.Get("session"); len(sessionKey) > 0 {
+		var ok bool
+		if endpoint, ok = h.querier.StreamEndpoint(sessionKey); !ok {
+			return nil, &httpError{code: http.StatusNotFound, msg: "unknown session " + sessionKey}
+		}
+	}
+	filter := func(bandwidth *model.Bandwidth) bool {
+		if len(ip) > 0 && bandwidth.Ip != ip {
+			return false
+		}
+		if len(port) > 0 && bandwidth.Port != port {
+			return false
+		}
+		if endpoint != nil {
+			if len(endpoint.StreamIp) > 0 && bandwidth.Ip != endpoint.StreamIp {
+				return false
+			}
+			if len(endpoint.StreamPorts) > 0 && !endpoint.StreamPorts.Contains(bandwidth.Port) {
+				return false
+			}
+		}
+		return true
+	}
+	return &params{start: start, end: end, direction: direction, filter: filter}, nil
+}
+
+// parseStep 支持时长(60s、5m)或者秒数
+func parseStep(value string) (int64, error) {
+	if len(value) == 0 {
+		return int64(defaultStep / time.Second), nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds <= 0 {
+			return 0, badRequest("invalid step " + value)
+		}
+		return seconds, nil
+	}
+	step, err := time.ParseDuration(value)
+	if err != nil || step < time.Second {
+		return 0, badRequest("invalid step " + value)
+	}
+	return int64(step / time.Second), nil
+}
+
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%d: %s", e.code, e.msg)
+}
+
+func badRequest(msg string) error {
+	return &httpError{code: http.StatusBadRequest, msg: msg}
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	var he *httpError
+	switch {
+	case errors.As(err, &he):
+		h.writeJSON(w, he.code, &errorResp{Error: he.msg})
+	default:
+		log.Errorf("query bandwidth failure err:%v", err)
+		h.writeJSON(w, http.StatusInternalServerError, &errorResp{Error: err.Error()})
+	}
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Warnf("write query response failure err:%v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package query
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeQuerier struct {
+	data      model.Bandwidths
+	endpoints map[string]*model.StreamEndpoint
+	err       error
+}
+
+func (q *fakeQuerier) QueryBandwidth(ctx context.Context, filter model.Filters, startTime, endTime int64) ([]*model.Bandwidth, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	return model.NewBandwidths(q.data.Search(startTime, endTime)).Filter(filter), nil
+}
+
+func (q *fakeQuerier) StreamEndpoint(sessionKey string) (*model.StreamEndpoint, bool) {
+	endpoint, ok := q.endpoints[sessionKey]
+	return endpoint, ok
+}
+
+func newTestServer(t *testing.T, querier Querier) *httptest.Server {
+	handler := NewHandler(querier)
+	handler.now = func() time.Time { return time.Unix(160, 0) }
+	router := mux.NewRouter()
+	handler.Register(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, url string, resp interface{}) int {
+	r, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if err = json.NewDecoder(r.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	return r.StatusCode
+}
+
+func TestTrafficHandler(t *testing.T) {
+	querier := &fakeQuerier{data: samples(), endpoints: map[string]*model.StreamEndpoint{
+		"i-1-7": {StreamIp: "10.0.0.1", StreamPorts: model.StreamPorts{{Port: 8000}}},
+	}}
+	server := newTestServer(t, querier)
+
+	var resp TrafficResp
+	code := get(t, server.URL+TrafficPath+"?start=100&step=30s&session=i-1-7&direction=up", &resp)
+	if code != http.StatusOK || resp.End != 160 || len(resp.Points) != 2 || resp.Points[0].Value != 15 {
+		t.Fatalf("unexpected traffic response %d %+v", code, resp)
+	}
+	code = get(t, server.URL+TrafficPath+"?start=100&end=160&step=60&ip=10.0.0.2&agg=avg", &resp)
+	if code != http.StatusOK || len(resp.Points) != 1 || resp.Points[0].Value != 2 {
+		t.Fatalf("unexpected traffic response %d %+v", code, resp)
+	}
+
+	var errResp errorResp
+	for url, want := range map[string]int{
+		TrafficPath + "?session=unknown":            http.StatusNotFound,
+		TrafficPath + "?start=200&end=100":          http.StatusBadRequest,
+		TrafficPath + "?agg=max":                    http.StatusBadRequest,
+		TrafficPath + "?start=0&end=1000000&step=1": http.StatusBadRequest,
+	} {
+		if code = get(t, server.URL+url, &errResp); code != want || len(errResp.Error) == 0 {
+			t.Fatalf("%s got %d %v, want %d", url, code, errResp, want)
+		}
+	}
+}
+
+func TestTopHandler(t *testing.T) {
+	server := newTestServer(t, &fakeQuerier{data: samples()})
+	var resp TopResp
+	code := get(t, server.URL+TopPath+"?start=100&n=1&direction=down", &resp)
+	if code != http.StatusOK || len(resp.Talkers) != 1 || resp.Talkers[0].Ip != "10.0.0.1" || resp.Talkers[0].Total != 660 {
+		t.Fatalf("unexpected top response %d %+v", code, resp)
+	}
+	var errResp errorResp
+	if code = get(t, server.URL+TopPath+"?by=mac", &errResp); code != http.StatusBadRequest {
+		t.Fatalf("expected unknown by to fail, got %d", code)
+	}
+
+	server = newTestServer(t, &fakeQuerier{err: errors.New("query failure")})
+	if code = get(t, server.URL+TopPath, &errResp); code != http.StatusInternalServerError {
+		t.Fatalf("expected query failure to be 500, got %d", code)
+	}
+}
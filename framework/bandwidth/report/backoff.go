@@ -0,0 +1,34 @@
+package report
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff 指数退避，每次失败等待时间翻倍直到max，实际等待时间在[d/2, d)之间随机，避免多个实例同时重试
+type backoff struct {
+	base     time.Duration
+	max      time.Duration
+	attempts int
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	if max < base {
+		max = base
+	}
+	return &backoff{base: base, max: max}
+}
+
+func (b *backoff) Next() time.Duration {
+	d := b.max
+	if b.attempts < 32 && b.base<<b.attempts < b.max {
+		d = b.base << b.attempts
+	}
+	b.attempts++
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (b *backoff) Reset() {
+	b.attempts = 0
+}
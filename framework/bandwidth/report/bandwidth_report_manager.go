@@ -1,94 +1,297 @@
 package report
 
 import (
+	"accumulation/framework/bandwidth/anomaly"
 	api2 "accumulation/framework/bandwidth/api"
 	"accumulation/framework/bandwidth/collector"
 	"accumulation/framework/bandwidth/conf"
 	"accumulation/framework/bandwidth/model"
+	"accumulation/framework/bandwidth/shaping"
 	"accumulation/framework/bandwidth/store"
-	"accumulation/pkg/nnet"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/go-kratos/kratos/v2/log"
-	"github.com/google/gopacket/pcap"
-	"net"
 	"sync"
 )
 
-const defaultBpfFilter = "udp or tcp"
+const (
+	defaultBpfFilter = "udp or tcp"
+	shapingModeTC    = "tc"
+	shapingDryRun    = "dry_run"
+)
 
 type bandwidthReportManager struct {
 	client       api2.BandwidthReportClient
-	collectors   []*collector.BandwidthCollector
-	engine       store.BandwidthEngine
+	shared       *sharedEngine
 	mutex        *sync.Mutex
 	tasks        map[string]*BandwidthReportTask
 	reportConfig *conf.Acl_ReportConfig
 	job          *BandwidthReportJob
+	analyzer     *anomaly.Analyzer
+	webhook      *anomaly.WebhookSink // 配置了anomaly_webhook时由manager创建，Close时关闭
+	enforcer     *shaping.Enforcer    // 没有开启限速时为nil
+	history      *trafficHistory      // 查询接口使用，会话结束后仍然保留一段时间
 }
 
-func NewBandwidthReportManager(client api2.BandwidthReportClient, job *BandwidthReportJob, data *conf.Data) api2.BandwidthReportManager {
-	return &bandwidthReportManager{
+// NewBandwidthReportManager sinks是额外的流量异常接收者，异常事件默认打印日志，配置了anomaly_webhook时同时通知webhook
+func NewBandwidthReportManager(client api2.BandwidthReportClient, job *BandwidthReportJob, data *conf.Data,
+	sinks ...anomaly.Sink) api2.BandwidthReportManager {
+	manager := &bandwidthReportManager{
 		client:       client,
 		mutex:        &sync.Mutex{},
 		reportConfig: data.Acl.ReportConfig,
 		tasks:        make(map[string]*BandwidthReportTask),
 		job:          job,
+		webhook:      newWebhookSink(data.Acl.ReportConfig),
+		enforcer:     newEnforcer(data.Acl.ReportConfig),
+		history:      newTrafficHistory(),
+	}
+	if manager.webhook != nil {
+		sinks = append(sinks, manager.webhook)
 	}
+	manager.analyzer = newAnalyzer(data.Acl.ReportConfig, sinks)
+	manager.shared = newSharedEngine(manager.initialization)
+	return manager
 
 }
+
+// StartReport 开始会话的上报任务，同一个会话已经有任务的话替换掉旧的任务
+// 先引用共享的engine再停止旧任务，避免引用计数归零导致collector被销毁后又重建
 func (bandwidthReportManager *bandwidthReportManager) StartReport(ctx context.Context, session *model.Session) error {
-	task, ok := bandwidthReportManager.tasks[session.SessionKey()]
-	if ok {
-		task.Stop(ctx)
-	}
-	bandwidthReportManager.mutex.Lock()
-	defer bandwidthReportManager.mutex.Unlock()
-	if bandwidthReportManager.collectors == nil {
-		err := bandwidthReportManager.initialization(ctx)
-		if err != nil {
-			return err
-		}
+	engine, err := bandwidthReportManager.shared.Acquire(ctx)
+	if err != nil {
+		return err
 	}
-	task = NewBandwidthReportTask(session,
-		bandwidthReportManager.engine,
+	task := NewBandwidthReportTask(session,
+		engine,
 		bandwidthReportManager.job,
 		bandwidthReportManager)
-	task.Start(ctx)
+	task.release = bandwidthReportManager.shared.Release
+	task.history = bandwidthReportManager.history
+	if bandwidthReportManager.analyzer != nil {
+		task.detector = bandwidthReportManager.analyzer.NewDetector(session)
+	}
+	bandwidthReportManager.mutex.Lock()
+	// 在锁里读取配置，保证和ApplyConfig串行，任务不会错过配置变更
+	task.SetReportInterval(bandwidthReportManager.reportConfig.ReportInterval())
+	task.SetSessionTimeout(sessionTimeout(bandwidthReportManager.reportConfig))
+	old := bandwidthReportManager.tasks[session.SessionKey()]
 	bandwidthReportManager.tasks[session.SessionKey()] = task
+	bandwidthReportManager.mutex.Unlock()
+	if old != nil {
+		old.Stop(ctx)
+	}
+	task.Start(ctx)
+	bandwidthReportManager.shape(ctx, session)
 	return nil
 }
 
-func (bandwidthReportManager *bandwidthReportManager) NotifyAccessInfo(ctx context.Context,
-	vmid int64, streamIp string, streamPorts model.StreamPorts) error {
+// shape 按会话的限速配置限速，限速失败不影响上报
+func (bandwidthReportManager *bandwidthReportManager) shape(ctx context.Context, session *model.Session) {
+	if bandwidthReportManager.enforcer == nil {
+		return
+	}
+	endpoint, ok := bandwidthReportManager.StreamEndpoint(session.SessionKey())
+	if !ok {
+		return
+	}
+	if err := bandwidthReportManager.enforcer.Apply(ctx, session, endpoint); err != nil {
+		// 限速配置会保留，串流地址更新时重试
+		log.Errorf("session[%s] apply shaping failure err:%v", session.SessionKey(), err)
+	}
+	// Apply过程中串流地址变了的话，Update可能没有生效，这里补上
+	if latest, ok := bandwidthReportManager.StreamEndpoint(session.SessionKey()); ok && latest != endpoint {
+		if err := bandwidthReportManager.enforcer.Update(ctx, session.SessionKey(), latest); err != nil {
+			log.Errorf("session[%s] update shaping failure err:%v", session.SessionKey(), err)
+		}
+	}
+}
 
+// newEnforcer 限速方式在启动时确定，不支持热加载
+func newEnforcer(reportConfig *conf.Acl_ReportConfig) *shaping.Enforcer {
+	if reportConfig == nil {
+		return nil
+	}
+	switch reportConfig.ShapingMode {
+	case shapingModeTC:
+		return shaping.NewEnforcer(shaping.NewNetlinkExecutor())
+	case shapingDryRun:
+		return shaping.NewEnforcer(&shaping.DryRun{})
+	}
 	return nil
 }
-func (bandwidthReportManager *bandwidthReportManager) RemoveTask(ctx context.Context, session *model.Session) error {
+
+// newWebhookSink 通知地址在启动时确定，不支持热加载
+func newWebhookSink(reportConfig *conf.Acl_ReportConfig) *anomaly.WebhookSink {
+	if reportConfig == nil || len(reportConfig.AnomalyWebhook) == 0 {
+		return nil
+	}
+	return anomaly.NewWebhookSink(reportConfig.AnomalyWebhook, 0)
+}
+
+func newAnalyzer(reportConfig *conf.Acl_ReportConfig, sinks []anomaly.Sink) *anomaly.Analyzer {
+	sinks = append([]anomaly.Sink{anomaly.LogSink{}}, sinks...)
+	var opts anomaly.Options
+	if reportConfig != nil && reportConfig.AnomalyDebounce != nil {
+		opts.Debounce = reportConfig.AnomalyDebounce.AsDuration()
+	}
+	return anomaly.NewAnalyzer(opts, sinks...)
+}
+
+// Close 关闭manager创建的webhook通知，等待队列里的异常事件发送完，调用方传入的sinks由调用方关闭
+func (bandwidthReportManager *bandwidthReportManager) Close(ctx context.Context) error {
+	if bandwidthReportManager.webhook == nil {
+		return nil
+	}
+	return bandwidthReportManager.webhook.Close(ctx)
+}
+
+func sessionTimeout(reportConfig *conf.Acl_ReportConfig) int64 {
+	if reportConfig == nil || reportConfig.SessionTimeout == nil {
+		return 0
+	}
+	return int64(reportConfig.SessionTimeout.AsDuration().Seconds())
+}
+
+func (bandwidthReportManager *bandwidthReportManager) config() *conf.Acl_ReportConfig {
 	bandwidthReportManager.mutex.Lock()
 	defer bandwidthReportManager.mutex.Unlock()
-	delete(bandwidthReportManager.tasks, session.SessionKey())
-	if len(bandwidthReportManager.tasks) == 0 {
-		for _, collector := range bandwidthReportManager.collectors {
-			collector.Stop(ctx)
+	return bandwidthReportManager.reportConfig
+}
+
+// ApplyConfig 应用新的上报配置，不需要重启会话：
+// 正在抓包的collector替换bpf过滤规则，engine修改容量和导出周期，任务修改统计周期和空闲超时，job修改环形队列容量
+func (bandwidthReportManager *bandwidthReportManager) ApplyConfig(ctx context.Context, reportConfig *conf.Acl_ReportConfig) error {
+	bandwidthReportManager.mutex.Lock()
+	bandwidthReportManager.reportConfig = reportConfig
+	tasks := make([]*BandwidthReportTask, 0, len(bandwidthReportManager.tasks))
+	for _, task := range bandwidthReportManager.tasks {
+		tasks = append(tasks, task)
+	}
+	bandwidthReportManager.mutex.Unlock()
+
+	var errs []error
+	bandwidthReportManager.shared.Reconfigure(func(collectors []collector.Collector, engine store.BandwidthEngine) {
+		bpfFilter := bpfFilter(reportConfig)
+		for _, c := range collectors {
+			setter, ok := c.(collector.FilterSetter)
+			if !ok {
+				continue
+			}
+			if err := setter.SetBPFFilter(bpfFilter); err != nil {
+				errs = append(errs, fmt.Errorf("set bpf filter %q failure err:%w", bpfFilter, err))
+			}
+		}
+		if reconfigurable, ok := engine.(store.Reconfigurable); ok {
+			reconfigurable.SetCapacity(engineBufLen(reportConfig))
+			if err := reconfigurable.SetCollectInterval(reportConfig.CollectPeriod()); err != nil {
+				errs = append(errs, fmt.Errorf("set collect interval failure err:%w", err))
+			}
+		}
+	})
+	for _, task := range tasks {
+		if err := task.SetReportInterval(reportConfig.ReportInterval()); err != nil {
+			errs = append(errs, fmt.Errorf("session[%s] set report interval failure err:%w", task.session.SessionKey(), err))
+		}
+		task.SetSessionTimeout(sessionTimeout(reportConfig))
+	}
+	if bandwidthReportManager.job != nil && reportConfig != nil {
+		bandwidthReportManager.job.SetBufLen(int(reportConfig.ReportJobBufLen))
+	}
+	return errors.Join(errs...)
+}
+
+// NotifyAccessInfo 更新vmid对应会话的串流地址，从下一个统计周期开始按新的地址统计
+func (bandwidthReportManager *bandwidthReportManager) NotifyAccessInfo(ctx context.Context,
+	vmid int64, streamIp string, streamPorts model.StreamPorts) error {
+	bandwidthReportManager.mutex.Lock()
+	updated := map[string]*model.StreamEndpoint{}
+	for _, task := range bandwidthReportManager.tasks {
+		if task.session.VMid != vmid {
+			continue
+		}
+		// 串流进程只在会话开始时指定，通知里没有
+		endpoint := &model.StreamEndpoint{StreamIp: streamIp, StreamPorts: streamPorts,
+			StreamProcs: task.endpoint.Load().StreamProcs}
+		task.UpdateStreamEndpoint(endpoint)
+		updated[task.session.SessionKey()] = endpoint
+		log.Infof("session[%s] update stream endpoint ip:%s ports:%v", task.session.SessionKey(), streamIp, streamPorts)
+	}
+	bandwidthReportManager.mutex.Unlock()
+	if len(updated) == 0 {
+		return fmt.Errorf("report task of vmid %d not found", vmid)
+	}
+	if bandwidthReportManager.enforcer == nil {
+		return nil
+	}
+	for sessionKey, endpoint := range updated {
+		if err := bandwidthReportManager.enforcer.Update(ctx, sessionKey, endpoint); err != nil {
+			log.Errorf("session[%s] update shaping failure err:%v", sessionKey, err)
 		}
-		bandwidthReportManager.engine.Stop(ctx)
-		bandwidthReportManager.collectors = nil
-		bandwidthReportManager.engine = nil
 	}
 	return nil
 }
+
+// QueryBandwidth 从collector导出时保存的流量里查询，包括不属于任何会话串流ip的流量，engine释放后仍然可以查询
+func (bandwidthReportManager *bandwidthReportManager) QueryBandwidth(ctx context.Context,
+	filter model.Filters, startTime, endTime int64) ([]*model.Bandwidth, error) {
+	if bandwidthReportManager.history == nil {
+		return nil, nil
+	}
+	return bandwidthReportManager.history.query(filter, startTime, endTime), nil
+}
+
+// StreamEndpoint 会话当前的串流地址，会话已经结束时返回保存的最后的串流地址
+func (bandwidthReportManager *bandwidthReportManager) StreamEndpoint(sessionKey string) (*model.StreamEndpoint, bool) {
+	bandwidthReportManager.mutex.Lock()
+	task, ok := bandwidthReportManager.tasks[sessionKey]
+	bandwidthReportManager.mutex.Unlock()
+	if ok {
+		return task.endpoint.Load(), true
+	}
+	if bandwidthReportManager.history == nil {
+		return nil, false
+	}
+	return bandwidthReportManager.history.endpoint(sessionKey)
+}
+
+// RemoveTask 从任务列表里移除会话的任务，任务已经被同一个会话的新任务替换时不做处理
+func (bandwidthReportManager *bandwidthReportManager) RemoveTask(ctx context.Context, session *model.Session) error {
+	bandwidthReportManager.mutex.Lock()
+	task, ok := bandwidthReportManager.tasks[session.SessionKey()]
+	removed := ok && task.session == session
+	if removed {
+		delete(bandwidthReportManager.tasks, session.SessionKey())
+	}
+	bandwidthReportManager.mutex.Unlock()
+	if removed && bandwidthReportManager.history != nil {
+		bandwidthReportManager.history.end(session.SessionKey())
+	}
+	if removed && bandwidthReportManager.enforcer != nil {
+		return bandwidthReportManager.enforcer.Remove(ctx, session.SessionKey())
+	}
+	return nil
+}
+
+// EndReport 结束会话的上报任务，flowId不一致说明是旧会话的结束事件，忽略
 func (bandwidthReportManager *bandwidthReportManager) EndReport(ctx context.Context, session *model.Session) error {
+	bandwidthReportManager.mutex.Lock()
 	task, ok := bandwidthReportManager.tasks[session.SessionKey()]
-	if ok && task.IsRunnable() {
-		task.Stop(ctx)
+	bandwidthReportManager.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	if len(session.FlowID) > 0 && len(task.session.FlowID) > 0 && session.FlowID != task.session.FlowID {
+		log.Warnf("session[%s] end flowId %s not match current flowId %s", session.SessionKey(), session.FlowID, task.session.FlowID)
+		return nil
 	}
+	task.Stop(ctx)
 	return nil
 }
 
-func (bandwidthReportManager *bandwidthReportManager) initialization(ctx context.Context) error {
-	var collectors []*collector.BandwidthCollector
-	var storeEngine store.BandwidthEngine
-	var err error
+func (bandwidthReportManager *bandwidthReportManager) initialization(ctx context.Context) (
+	collectors []collector.Collector, storeEngine store.BandwidthEngine, err error) {
 	defer func() {
 		if err != nil {
 			for _, collector := range collectors {
@@ -99,84 +302,60 @@ func (bandwidthReportManager *bandwidthReportManager) initialization(ctx context
 			}
 		}
 	}()
-	bpfFilter := bandwidthReportManager.reportConfig.BpfFilter
-	if len(bpfFilter) == 0 {
-		bpfFilter = defaultBpfFilter
-	}
-	collectors, err = buildBandwidthCollector(bpfFilter)
+	reportConfig := bandwidthReportManager.config()
+	collectors, err = newCollectors(reportConfig)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	engineBufLen := 0
-	if bandwidthReportManager.reportConfig != nil {
-		engineBufLen = int(bandwidthReportManager.reportConfig.EngineBufLen)
+	// engine导出的流量同时保存到history，sharedEngine保存原始的collector，修改配置时按原始类型判断
+	exporters := collectors
+	if bandwidthReportManager.history != nil {
+		exporters = make([]collector.Collector, 0, len(collectors))
+		for _, c := range collectors {
+			exporters = append(exporters, &historyCollector{Collector: c, history: bandwidthReportManager.history})
+		}
 	}
-
-	storeEngine = store.NewBandwidthEngine(collectors, engineBufLen)
+	storeEngine = store.NewBandwidthEngine(exporters, engineBufLen(reportConfig), reportConfig.CollectPeriod())
 	for _, collector := range collectors {
 		err = collector.Start(ctx)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 	}
 	err = storeEngine.Start(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	bandwidthReportManager.collectors = collectors
-	bandwidthReportManager.engine = storeEngine
-	return nil
+	return collectors, storeEngine, nil
 }
-func buildBandwidthCollector(bpfFilter string) ([]*collector.BandwidthCollector, error) {
-	interfaces, err := nnet.GetValidInterfaces()
-	if err != nil {
-		return nil, err
-	}
-	devices, err := pcap.FindAllDevs()
-	if err != nil {
-		log.Errorf("pcap findAllDevs err :%v", err)
-		return nil, err
-	}
-	var collectors []*collector.BandwidthCollector
-	for _, d := range devices {
-		inter := InterfaceSlice(interfaces).FindInterface(d.Addresses)
-		if inter != nil {
-			collectors = append(collectors,
-				collector.NewBandwidthCollector(d.Name, bpfFilter, inter.HardwareAddr.String()))
-		}
 
+// newCollectors 按CollectorType从注册表创建收集器，没有配置时使用pcap
+func newCollectors(reportConfig *conf.Acl_ReportConfig) ([]collector.Collector, error) {
+	if reportConfig == nil {
+		return collector.New(collector.TypePcap, collector.Options{BpfFilter: defaultBpfFilter})
 	}
-	return collectors, nil
+	opts := collector.Options{
+		BpfFilter:  bpfFilter(reportConfig),
+		CgroupPath: reportConfig.CgroupPath,
+		ReplayFile: reportConfig.ReplayFile,
+		MacAddress: reportConfig.ReplayMacAddress,
+	}
+	if reportConfig.ProcessAttribution {
+		opts.Process = collector.NewProcessResolver("", reportConfig.ProcessRefresh.AsDuration())
+	}
+	return collector.New(reportConfig.CollectorType, opts)
 }
 
-type InterfaceSlice []net.Interface
-
-func (ifs InterfaceSlice) FindInterface(pIfs []pcap.InterfaceAddress) *net.Interface {
-	for _, fs := range ifs {
-		addrs, err := fs.Addrs()
-		if err != nil {
-			continue
-		}
-		var ips []string
-		for _, addr := range addrs {
-			if a, ok := addr.(*net.IPNet); ok {
-				ips = append(ips, a.IP.String())
-			}
-		}
-		for _, pIf := range pIfs {
-			if !containStr(ips, pIf.IP.String()) {
-				break
-			}
-			return &fs
-		}
+func bpfFilter(reportConfig *conf.Acl_ReportConfig) string {
+	if reportConfig == nil || len(reportConfig.BpfFilter) == 0 {
+		return defaultBpfFilter
 	}
-	return nil
+	return reportConfig.BpfFilter
 }
-func containStr(set []string, target string) bool {
-	for _, str := range set {
-		if str == target {
-			return true
-		}
+
+func engineBufLen(reportConfig *conf.Acl_ReportConfig) int {
+	if reportConfig == nil {
+		return 0
 	}
-	return false
+	return int(reportConfig.EngineBufLen)
 }
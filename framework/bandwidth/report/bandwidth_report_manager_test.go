@@ -0,0 +1,233 @@
+package report
+
+import (
+	"accumulation/framework/bandwidth/collector"
+	"accumulation/framework/bandwidth/conf"
+	"accumulation/framework/bandwidth/model"
+	"accumulation/framework/bandwidth/store"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type countingEngine struct {
+	fakeEngine
+	stopped *atomic.Int32
+}
+
+func (e *countingEngine) Stop(ctx context.Context) error {
+	e.stopped.Add(1)
+	return nil
+}
+
+func newTestManager(t *testing.T, reportConfig *conf.Acl_ReportConfig) (*bandwidthReportManager, *atomic.Int32, *atomic.Int32) {
+	job, _ := newTestJob(t, &fakeReportClient{}, 10)
+	manager := &bandwidthReportManager{
+		mutex:        &sync.Mutex{},
+		tasks:        map[string]*BandwidthReportTask{},
+		reportConfig: reportConfig,
+		job:          job,
+	}
+	var built, stopped atomic.Int32
+	manager.shared = newSharedEngine(func(ctx context.Context) ([]collector.Collector, store.BandwidthEngine, error) {
+		built.Add(1)
+		return nil, &countingEngine{stopped: &stopped}, nil
+	})
+	return manager, &built, &stopped
+}
+
+func TestManagerStartStopStorm(t *testing.T) {
+	manager, built, stopped := newTestManager(t, &conf.Acl_ReportConfig{})
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 10; j++ {
+			wg.Add(3)
+			session := &model.Session{InstanceId: fmt.Sprintf("i-%d", i), VMid: int64(i), FlowID: fmt.Sprintf("f-%d-%d", i, j)}
+			go func() {
+				defer wg.Done()
+				if err := manager.StartReport(ctx, session); err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				manager.EndReport(ctx, session)
+			}()
+			go func(vmid int64) {
+				defer wg.Done()
+				manager.NotifyAccessInfo(ctx, vmid, "10.0.0.1", nil)
+			}(int64(i))
+		}
+	}
+	wg.Wait()
+
+	manager.mutex.Lock()
+	var tasks []*BandwidthReportTask
+	for _, task := range manager.tasks {
+		tasks = append(tasks, task)
+	}
+	manager.mutex.Unlock()
+	for _, task := range tasks {
+		if state := task.State(); state != TaskRunning {
+			t.Fatalf("task in map should be running, got %v", state)
+		}
+		manager.EndReport(ctx, &model.Session{InstanceId: task.session.InstanceId, VMid: task.session.VMid})
+	}
+
+	manager.mutex.Lock()
+	left := len(manager.tasks)
+	manager.mutex.Unlock()
+	if left != 0 {
+		t.Fatalf("expected all tasks removed, %d left", left)
+	}
+	if refs := manager.shared.Refs(); refs != 0 {
+		t.Fatalf("expected no engine refs, got %d", refs)
+	}
+	if built.Load() != stopped.Load() {
+		t.Fatalf("engine built %d times but stopped %d times", built.Load(), stopped.Load())
+	}
+}
+
+func TestManagerReplaceTask(t *testing.T) {
+	manager, built, stopped := newTestManager(t, &conf.Acl_ReportConfig{})
+	ctx := context.Background()
+	first := &model.Session{InstanceId: "i-1", FlowID: "f-1"}
+	second := &model.Session{InstanceId: "i-1", FlowID: "f-2"}
+	if err := manager.StartReport(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.StartReport(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if built.Load() != 1 || stopped.Load() != 0 {
+		t.Fatalf("engine should be kept while replacing task, built %d stopped %d", built.Load(), stopped.Load())
+	}
+	// 旧会话的结束事件不影响新的会话
+	manager.EndReport(ctx, first)
+	if len(manager.tasks) != 1 || manager.tasks[second.SessionKey()].session != second {
+		t.Fatalf("expected task of second session to be kept")
+	}
+	manager.EndReport(ctx, second)
+	if len(manager.tasks) != 0 || stopped.Load() != 1 {
+		t.Fatalf("expected engine stopped after last session, tasks %d stopped %d", len(manager.tasks), stopped.Load())
+	}
+}
+
+func TestManagerIdleExpiry(t *testing.T) {
+	manager, _, stopped := newTestManager(t, &conf.Acl_ReportConfig{SessionTimeout: durationpb.New(time.Second)})
+	ctx := context.Background()
+	session := &model.Session{InstanceId: "i-1", Start: time.Now().Unix()}
+	if err := manager.StartReport(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	task := manager.tasks[session.SessionKey()]
+	task.periodFetchBandwidth()
+	if task.State() != TaskRunning {
+		t.Fatalf("task should not expire before session timeout")
+	}
+	task.lastActive.Store(time.Now().Unix() - 2)
+	task.periodFetchBandwidth()
+	waitFor(t, func() bool {
+		return task.State() == TaskStopped && stopped.Load() == 1
+	})
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if len(manager.tasks) != 0 {
+		t.Fatalf("expected expired task to be removed")
+	}
+}
+
+type reconfigurableEngine struct {
+	countingEngine
+	capacity int
+	interval time.Duration
+}
+
+func (e *reconfigurableEngine) SetCapacity(capacity int) {
+	e.capacity = capacity
+}
+
+func (e *reconfigurableEngine) SetCollectInterval(interval time.Duration) error {
+	e.interval = interval
+	return nil
+}
+
+func TestManagerApplyConfig(t *testing.T) {
+	manager, _, _ := newTestManager(t, &conf.Acl_ReportConfig{})
+	var stopped atomic.Int32
+	engine := &reconfigurableEngine{countingEngine: countingEngine{stopped: &stopped}}
+	manager.shared = newSharedEngine(func(ctx context.Context) ([]collector.Collector, store.BandwidthEngine, error) {
+		return nil, engine, nil
+	})
+	ctx := context.Background()
+	session := &model.Session{InstanceId: "i-1"}
+	if err := manager.StartReport(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.EndReport(ctx, session)
+	task := manager.tasks[session.SessionKey()]
+	if task.reportInterval != defaultReportInterval {
+		t.Fatalf("unexpected default report interval %v", task.reportInterval)
+	}
+
+	err := manager.ApplyConfig(ctx, &conf.Acl_ReportConfig{
+		BackendReportInterval: 30,
+		EngineBufLen:          100,
+		CollectInterval:       durationpb.New(2 * time.Second),
+		SessionTimeout:        durationpb.New(time.Minute),
+		ReportJobBufLen:       20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if engine.capacity != 100 || engine.interval != 2*time.Second {
+		t.Fatalf("engine not reconfigured, capacity %d interval %v", engine.capacity, engine.interval)
+	}
+	task.mutex.Lock()
+	interval, entries := task.reportInterval, len(task.cron.Entries())
+	task.mutex.Unlock()
+	if interval != 30*time.Second || entries != 1 {
+		t.Fatalf("task not reconfigured, interval %v entries %d", interval, entries)
+	}
+	if task.sessionTimeout.Load() != 60 {
+		t.Fatalf("unexpected session timeout %d", task.sessionTimeout.Load())
+	}
+	if manager.job.ringBuff.Capacity() != 20 {
+		t.Fatalf("unexpected ring capacity %d", manager.job.ringBuff.Capacity())
+	}
+}
+
+func TestManagerShaping(t *testing.T) {
+	manager, _, _ := newTestManager(t, &conf.Acl_ReportConfig{})
+	manager.enforcer = newEnforcer(&conf.Acl_ReportConfig{ShapingMode: shapingDryRun})
+	ctx := context.Background()
+	session := &model.Session{InstanceId: "i-1", VMid: 1, FlowID: "f-1",
+		Extra: `{"shaping":{"rate":"20mbit","device":"eth0"}}`}
+	if err := manager.StartReport(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	// 新会话替换旧会话时限速跟随新会话，旧任务停止不会取消限速
+	replaced := &model.Session{InstanceId: "i-1", VMid: 1, FlowID: "f-2",
+		Extra: `{"shaping":{"rate":"10mbit","device":"eth0"}}`}
+	if err := manager.StartReport(ctx, replaced); err != nil {
+		t.Fatal(err)
+	}
+	if manager.enforcer.Sessions() != 1 {
+		t.Fatalf("expected replaced session to keep shaping")
+	}
+	if err := manager.NotifyAccessInfo(ctx, 1, "10.0.0.1", model.StreamPorts{{Port: 9000}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.EndReport(ctx, replaced); err != nil {
+		t.Fatal(err)
+	}
+	if manager.enforcer.Sessions() != 0 {
+		t.Fatalf("expected shaping to be removed with the session")
+	}
+}
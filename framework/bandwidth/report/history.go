@@ -0,0 +1,134 @@
+package report
+
+import (
+	"accumulation/framework/bandwidth/collector"
+	"accumulation/framework/bandwidth/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHistoryRetention 流量和结束的会话保留的时间
+	defaultHistoryRetention = time.Hour
+	// defaultHistoryRecords 最多保留的流量条数，超过时丢弃最早的
+	defaultHistoryRecords = 50000
+)
+
+// sessionEndpoint 会话最后一次统计时的串流地址
+type sessionEndpoint struct {
+	endpoint *model.StreamEndpoint
+	endTime  time.Time // 会话还在运行时为零值
+}
+
+// trafficHistory 保存collector导出的所有流量，不按会话的串流ip过滤，保留进程信息，
+// engine只保存最近capacity条并且在最后一个会话结束时释放，查询接口从这里查询，
+// 采集时间超过retention的流量和结束超过retention的会话被删除
+type trafficHistory struct {
+	mutex      sync.Mutex
+	retention  time.Duration
+	maxRecords int
+	records    []*model.Bandwidth // 按采集时间排序
+	sessions   map[string]*sessionEndpoint
+	now        func() time.Time
+}
+
+func newTrafficHistory() *trafficHistory {
+	return &trafficHistory{
+		retention:  defaultHistoryRetention,
+		maxRecords: defaultHistoryRecords,
+		sessions:   map[string]*sessionEndpoint{},
+		now:        time.Now,
+	}
+}
+
+// record 保存collector一次导出的流量，保存的是副本，engine里的数据被修改不受影响
+func (h *trafficHistory) record(bandwidths []*model.Bandwidth) {
+	if len(bandwidths) == 0 {
+		return
+	}
+	records := make([]*model.Bandwidth, 0, len(bandwidths))
+	for _, bandwidth := range bandwidths {
+		record := *bandwidth
+		records = append(records, &record)
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	sorted := len(h.records) == 0 || records[0].CollectTime >= h.records[len(h.records)-1].CollectTime
+	h.records = append(h.records, records...)
+	if !sorted || !sort.IsSorted(model.NewBandwidths(records)) {
+		sort.Stable(model.NewBandwidths(h.records))
+	}
+	if over := len(h.records) - h.maxRecords; over > 0 {
+		h.records = h.records[over:]
+	}
+	h.sweep()
+}
+
+// track 保存会话当前的串流地址，同一个会话重新开始时覆盖
+func (h *trafficHistory) track(sessionKey string, endpoint *model.StreamEndpoint) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.sessions[sessionKey] = &sessionEndpoint{endpoint: endpoint}
+}
+
+// end 会话结束，串流地址再保留retention
+func (h *trafficHistory) end(sessionKey string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if session, ok := h.sessions[sessionKey]; ok {
+		session.endTime = h.now()
+	}
+	h.sweep()
+}
+
+// endpoint 会话最后一次统计时的串流地址
+func (h *trafficHistory) endpoint(sessionKey string) (*model.StreamEndpoint, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.sweep()
+	session, ok := h.sessions[sessionKey]
+	if !ok {
+		return nil, false
+	}
+	return session.endpoint, true
+}
+
+// query 按采集时间查询保存的流量
+func (h *trafficHistory) query(filter model.Filters, startTime, endTime int64) []*model.Bandwidth {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.sweep()
+	data := model.NewBandwidths(h.records).Search(startTime, endTime)
+	if filter == nil {
+		return append([]*model.Bandwidth(nil), data...)
+	}
+	return model.NewBandwidths(data).Filter(filter)
+}
+
+func (h *trafficHistory) sweep() {
+	now := h.now()
+	for sessionKey, session := range h.sessions {
+		if !session.endTime.IsZero() && now.Sub(session.endTime) >= h.retention {
+			delete(h.sessions, sessionKey)
+		}
+	}
+	expired := now.Add(-h.retention).Unix()
+	if index := sort.Search(len(h.records), func(i int) bool {
+		return h.records[i].CollectTime >= expired
+	}); index > 0 {
+		h.records = h.records[index:]
+	}
+}
+
+// historyCollector 导出流量时同时保存到history
+type historyCollector struct {
+	collector.Collector
+	history *trafficHistory
+}
+
+func (c *historyCollector) ExportAndClean() []*model.Bandwidth {
+	bandwidths := c.Collector.ExportAndClean()
+	c.history.record(bandwidths)
+	return bandwidths
+}
@@ -0,0 +1,104 @@
+package report
+
+import (
+	"accumulation/framework/bandwidth/collector"
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeCollector struct {
+	bandwidths []*model.Bandwidth
+}
+
+func (c *fakeCollector) Start(ctx context.Context) error { return nil }
+
+func (c *fakeCollector) Stop(ctx context.Context) error { return nil }
+
+func (c *fakeCollector) ExportAndClean() []*model.Bandwidth {
+	bandwidths := c.bandwidths
+	c.bandwidths = nil
+	return bandwidths
+}
+
+func (c *fakeCollector) Stats() collector.Stats { return collector.Stats{} }
+
+func TestTrafficHistory(t *testing.T) {
+	now := time.Unix(1000, 0)
+	history := newTrafficHistory()
+	history.maxRecords = 3
+	history.now = func() time.Time { return now }
+	history.record([]*model.Bandwidth{
+		{Ip: "10.0.0.1", Port: "9000", UpLen: 1, DownLen: 10, CollectTime: 990, Pid: 1, Process: "game"},
+		{Ip: "10.0.0.2", Port: "8000", UpLen: 2, DownLen: 20, CollectTime: 990, Pid: 2, Process: "sidecar"},
+	})
+	// 乱序导出的流量按采集时间排序
+	history.record([]*model.Bandwidth{
+		{Ip: "10.0.0.1", Port: "9001", UpLen: 3, DownLen: 30, CollectTime: 980},
+	})
+	got := history.query(nil, 0, 2000)
+	if len(got) != 3 || got[0].Port != "9001" || got[1].Process != "game" || got[2].Pid != 2 {
+		t.Fatalf("unexpected records %+v", got)
+	}
+	// 不属于任何会话串流ip的流量也可以查询
+	if got = history.query(func(bandwidth *model.Bandwidth) bool { return bandwidth.Ip == "10.0.0.2" }, 0, 2000); len(got) != 1 {
+		t.Fatalf("unexpected records %+v", got)
+	}
+	// 超过maxRecords时丢弃最早的
+	history.record([]*model.Bandwidth{{Ip: "10.0.0.1", Port: "9000", UpLen: 5, DownLen: 50, CollectTime: 995}})
+	if got = history.query(nil, 0, 2000); len(got) != 3 || got[0].CollectTime != 990 {
+		t.Fatalf("unexpected records %+v", got)
+	}
+	// 超过retention的流量被删除
+	now = time.Unix(992, 0).Add(defaultHistoryRetention)
+	if got = history.query(nil, 0, 0); len(got) != 1 || got[0].CollectTime != 995 {
+		t.Fatalf("unexpected records %+v", got)
+	}
+
+	endpoint := &model.StreamEndpoint{StreamIp: "10.0.0.1"}
+	history.track("i-1-1", endpoint)
+	history.end("i-1-1")
+	if _, ok := history.endpoint("i-1-1"); !ok {
+		t.Fatal("expected ended session to be retained")
+	}
+	now = now.Add(defaultHistoryRetention)
+	if _, ok := history.endpoint("i-1-1"); ok {
+		t.Fatal("expected ended session to be removed after retention")
+	}
+}
+
+func TestQueryEndedSession(t *testing.T) {
+	job, _ := newTestJob(t, &fakeReportClient{}, 1)
+	manager := &bandwidthReportManager{mutex: &sync.Mutex{}, tasks: map[string]*BandwidthReportTask{}, history: newTrafficHistory()}
+	exporter := &historyCollector{Collector: &fakeCollector{bandwidths: []*model.Bandwidth{
+		{Ip: "10.0.0.1", Port: "9000", UpLen: 10, DownLen: 100, CollectTime: time.Now().Unix() - 5, Pid: 7, Process: "game"},
+		{Ip: "10.0.0.2", Port: "9000", UpLen: 20, DownLen: 200, CollectTime: time.Now().Unix() - 5},
+	}}, history: manager.history}
+	engine := &fakeEngine{data: exporter.ExportAndClean()}
+	session := &model.Session{InstanceId: "i-1", VMid: 7, Start: time.Now().Unix() - 10, StreamIp: "10.0.0.1"}
+	task := NewBandwidthReportTask(session, engine, job, manager)
+	task.history = manager.history
+	manager.tasks[session.SessionKey()] = task
+	task.periodFetchBandwidth()
+
+	// 任务结束后仍然可以按会话查询
+	if err := manager.RemoveTask(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	endpoint, ok := manager.StreamEndpoint(session.SessionKey())
+	if !ok || endpoint.StreamIp != "10.0.0.1" {
+		t.Fatalf("expected ended session endpoint, got %v %v", endpoint, ok)
+	}
+	bandwidths, err := manager.QueryBandwidth(context.Background(), func(bandwidth *model.Bandwidth) bool {
+		return bandwidth.Ip == endpoint.StreamIp
+	}, 0, time.Now().Unix()+1)
+	if err != nil || len(bandwidths) != 1 || bandwidths[0].DownLen != 100 || bandwidths[0].Process != "game" {
+		t.Fatalf("unexpected query result %+v %v", bandwidths, err)
+	}
+	// 不属于会话的流量同样保存
+	if bandwidths, _ = manager.QueryBandwidth(context.Background(), nil, 0, 0); len(bandwidths) != 2 {
+		t.Fatalf("expected all exported traffic, got %+v", bandwidths)
+	}
+}
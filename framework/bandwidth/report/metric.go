@@ -0,0 +1,67 @@
+package report
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	failureRetry      = "retry"
+	failureDeadLetter = "dead_letter"
+	directionUp       = "up"
+	directionDown     = "down"
+)
+
+var sessionLabels = []string{"gid", "vmid", "flow_id", "stream_port", "direction"}
+
+var (
+	reportQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "report_queue_depth",
+		Help:      "number of report requests waiting in the ring buffer",
+	})
+	reportQueueCapacity = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "report_queue_capacity",
+		Help:      "capacity of the report ring buffer",
+	})
+	reportSpillBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "report_spill_bytes",
+		Help:      "size of the report wal segment files on disk",
+	})
+	sessionBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "session_bytes_total",
+		Help:      "bytes transferred by a session, stream_port is empty when the session has no stream port",
+	}, sessionLabels)
+	sessionRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "session_bytes_per_second",
+		Help:      "average bandwidth of a session during the last report period",
+	}, sessionLabels)
+	reportSendDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "report_send_duration_seconds",
+		Help:      "latency of a report batch send",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5},
+	})
+	reportSent = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "report_sent_total",
+		Help:      "number of report requests sent successfully",
+	})
+	reportFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cgvmagent",
+		Subsystem: "bandwidth",
+		Name:      "report_failures_total",
+		Help:      "number of failed report sends",
+	}, []string{"reason"})
+)
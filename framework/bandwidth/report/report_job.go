@@ -6,7 +6,11 @@ import (
 	"accumulation/framework/bandwidth/model"
 	"accumulation/framework/bandwidth/store"
 	"accumulation/pkg/log"
+	"accumulation/pkg/ringbuff"
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
 
 	"runtime/debug"
 	"sync"
@@ -14,139 +18,387 @@ import (
 	"time"
 )
 
-var BufLen = 500
+const (
+	defaultBufLen         = 500
+	defaultSpillDir       = "bandwidth/report"
+	defaultDeadLetterPath = "bandwidth/dead_letter.dat"
+	defaultBatchSize      = 20
+	defaultBatchWait      = 500 * time.Millisecond
+	minBatchWait          = 10 * time.Millisecond // 凑批等待时间太短时队列不满会一直空转
+	defaultDrainTimeout   = 10 * time.Second      // Stop时上报剩余数据的最长时间，ctx没有更早结束时使用
+	defaultBaseBackoff    = time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+// reportItem 环形队列里的数据，persisted为true表示数据是从wal里加载的，上报成功后需要commit
+type reportItem struct {
+	req       *model.ReportFlowBizRequest
+	pos       store.WALPosition
+	persisted bool
+	spilled   atomic.Bool // 只在内存里的数据在开始写wal时已经写到wal里，Stop时不用再写
+}
 
 type BandwidthReportJob struct {
 	client       api.BandwidthReportClient
 	isRunnable   atomic.Bool
-	ringBuff     *RingBuff[*model.ReportFlowBizRequest]
-	tmp          []*model.ReportFlowBizRequest
+	ringBuff     *ringbuff.RingBuff[*reportItem]
 	mutex        *sync.Mutex
-	dataBuffOnly atomic.Bool //数据仅仅在buff里
-	store        *store.FileStore[*model.ReportFlowBizRequest]
+	dataBuffOnly atomic.Bool  //数据仅仅在buff里
+	pendingLen   atomic.Int64 //等待生效的环形队列容量，队列里数据太多不能立即缩容时记录下来
+	wal          *store.WAL[*model.ReportFlowBizRequest]
+	deadLetter   *store.FileStore[*model.ReportFlowBizRequest]
+	batchSize    int
+	batchWait    time.Duration
+	backoff      *backoff
+	notify       chan struct{}
+	stopping     chan struct{}
+	stopped      chan struct{}
+	sendCtx      context.Context
+	cancelSend   context.CancelFunc
 }
 
 func NewBandwidthReportJob(
 	client api.BandwidthReportClient,
 	config *conf.Data,
 ) *BandwidthReportJob {
-	if config.Acl.ReportConfig != nil && config.Acl.ReportConfig.ReportJobBufLen > 0 {
-		BufLen = int(config.Acl.ReportConfig.ReportJobBufLen)
+	reportConfig := config.Acl.ReportConfig
+	bufLen := defaultBufLen
+	if reportConfig != nil && reportConfig.ReportJobBufLen > 0 {
+		bufLen = int(reportConfig.ReportJobBufLen)
+	}
+	job := &BandwidthReportJob{
+		client:     client,
+		mutex:      &sync.Mutex{},
+		ringBuff:   ringbuff.NewRingBuff[*reportItem](bufLen, ringbuff.Reject),
+		wal:        store.NewWAL[*model.ReportFlowBizRequest](walOptions(reportConfig)),
+		deadLetter: store.NewFileStore[*model.ReportFlowBizRequest](filepath.Join(os.TempDir(), defaultDeadLetterPath)),
+		batchSize:  defaultBatchSize,
+		batchWait:  defaultBatchWait,
+		backoff:    newBackoff(defaultBaseBackoff, defaultMaxBackoff),
+		notify:     make(chan struct{}, 1),
+	}
+	if reportConfig == nil {
+		return job
+	}
+	if len(reportConfig.DeadLetterPath) > 0 {
+		job.deadLetter = store.NewFileStore[*model.ReportFlowBizRequest](reportConfig.DeadLetterPath)
+	}
+	if reportConfig.ReportBatchSize > 0 {
+		job.batchSize = int(reportConfig.ReportBatchSize)
 	}
-	return &BandwidthReportJob{
-		client:   client,
-		mutex:    &sync.Mutex{},
-		ringBuff: NewRingBuff[*model.ReportFlowBizRequest](BufLen),
-		store:    store.NewFileStore[*model.ReportFlowBizRequest](""),
+	if reportConfig.ReportBatchWait != nil {
+		job.batchWait = reportConfig.ReportBatchWait.AsDuration()
 	}
+	if job.batchWait < minBatchWait {
+		job.batchWait = minBatchWait
+	}
+	if reportConfig.ReportMaxBackoff != nil {
+		job.backoff = newBackoff(defaultBaseBackoff, reportConfig.ReportMaxBackoff.AsDuration())
+	}
+	return job
 }
 
-func (job *BandwidthReportJob) Start(ctx context.Context) error {
-	job.isRunnable.Swap(true)
-	err := job.store.Open()
-	if err != nil {
-		return err
+func walOptions(reportConfig *conf.Acl_ReportConfig) store.WALOptions {
+	opts := store.WALOptions{Dir: filepath.Join(os.TempDir(), defaultSpillDir)}
+	if reportConfig == nil {
+		return opts
 	}
-	reqs, err := job.loadFromFile(ctx, BufLen)
+	if len(reportConfig.SpillDir) > 0 {
+		opts.Dir = reportConfig.SpillDir
+	}
+	opts.SegmentSize = reportConfig.SpillSegmentSize
+	policy, err := store.ParseFsyncPolicy(reportConfig.SpillFsyncPolicy)
 	if err != nil {
-		return err
+		log.Warnf(context.Background(), "parse spill fsync policy failure err:%v", err)
 	}
-	for _, req := range reqs {
-		job.Add(ctx, req, true)
+	opts.FsyncPolicy = policy
+	if reportConfig.SpillFsyncInterval != nil {
+		opts.FsyncInterval = reportConfig.SpillFsyncInterval.AsDuration()
 	}
-	if job.ringBuff.SurplusCount() > 0 {
-		job.dataBuffOnly.Store(true)
+	return opts
+}
+
+func (job *BandwidthReportJob) Start(ctx context.Context) error {
+	if err := job.wal.Open(); err != nil {
+		return err
 	}
+	if err := job.deadLetter.Open(); err != nil {
+		job.wal.Close()
+		return err
+	}
+	job.isRunnable.Swap(true)
+	job.stopping = make(chan struct{})
+	job.stopped = make(chan struct{})
+	job.sendCtx, job.cancelSend = context.WithCancel(context.Background())
+	job.tryLoadData()
 	go job.loopReport()
 	return nil
 }
+
+// Stop 先把环形队列里的数据尽量上报完，上报失败、ctx结束或者超过defaultDrainTimeout时放弃上报
+// 环形队列里还没有落盘的数据写到wal里，已经在wal里的数据没有commit，重启后会重新加载
 func (job *BandwidthReportJob) Stop(ctx context.Context) error {
-	job.isRunnable.Swap(false)
-	surplus := job.ringBuff.Surplus()
-	job.store.Truncate(ctx, surplus, job.tmp)
-	return job.store.Close()
+	if !job.isRunnable.Swap(false) {
+		return nil
+	}
+	close(job.stopping)
+	drainCtx, cancel := context.WithTimeout(ctx, defaultDrainTimeout)
+	defer cancel()
+	select {
+	case <-job.stopped:
+	case <-drainCtx.Done():
+		job.cancelSend()
+		<-job.stopped
+	}
+	job.cancelSend()
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	// 剩下只在内存里的数据都在wal的数据之后(见spillBuffered)，追加到wal末尾顺序不变；
+	// 调用方的ctx可能已经超时，用新的ctx保证能写完
+	appendCtx, cancelAppend := context.WithTimeout(context.Background(), defaultDrainTimeout)
+	defer cancelAppend()
+	var reqs []*model.ReportFlowBizRequest
+	for _, item := range job.ringBuff.Surplus() {
+		if !item.persisted && !item.spilled.Load() {
+			reqs = append(reqs, item.req)
+		}
+	}
+	if err := job.wal.Append(appendCtx, reqs); err != nil {
+		log.Errorf(ctx, "write surplus to wal failure err:%v", err)
+	}
+	if err := job.deadLetter.Close(); err != nil {
+		log.Warnf(ctx, "close dead letter failure err:%v", err)
+	}
+	return job.wal.Close()
 }
+
 func (job *BandwidthReportJob) loopReport() {
+	restart := false
 	defer func() {
 		if e := recover(); e != nil {
 			log.Errorf(context.TODO(), "BandwidthReportJob panic|err=%v|stack=%v", e, string(debug.Stack()))
+			restart = job.isRunnable.Load()
 		}
-		if job.isRunnable.Load() {
-			job.loopReport()
+		if restart {
+			go job.loopReport()
+			return
 		}
+		close(job.stopped)
 	}()
-	for job.isRunnable.Load() {
-		req := job.ringBuff.Current()
-		if req != nil {
-			err := job.client.ReportBandwidthData(context.Background(), []*model.ReportFlowBizRequest{req})
-			if err != nil {
-				time.Sleep(time.Second * time.Duration(2))
-				log.Warnf(context.Background(), "report bandwidth failure req:%v", *req)
-			} else {
-				job.ringBuff.Dequeue()
+	for {
+		reportQueueDepth.Set(float64(job.ringBuff.Size()))
+		reportQueueCapacity.Set(float64(job.ringBuff.Capacity()))
+		reportSpillBytes.Set(float64(job.wal.Size()))
+		if job.draining() && (job.ringBuff.Size() == 0 || job.sendCtx.Err() != nil) {
+			return
+		}
+		items := job.nextBatch()
+		if len(items) == 0 {
+			continue
+		}
+		if err := job.send(items); err != nil {
+			reportFailures.WithLabelValues(failureRetry).Inc()
+			if job.draining() {
+				// 停止时不再重试，剩余的数据由Stop写到wal里
+				log.Warnf(context.Background(), "report bandwidth failure while stopping, spill %d items err:%v", job.ringBuff.Size(), err)
+				return
 			}
-		} else {
-			job.tryLoadData()
-			time.Sleep(time.Second * time.Duration(3))
+			delay := job.backoff.Next()
+			log.Warnf(context.Background(), "report bandwidth failure, retry after %v, size:%d err:%v", delay, len(items), err)
+			job.sleep(delay)
+			continue
 		}
+		job.backoff.Reset()
 	}
 }
+
+// nextBatch 凑一批数据，最多batchSize条，队列不满batchSize时最多等待batchWait
+func (job *BandwidthReportJob) nextBatch() []*reportItem {
+	if !job.dataBuffOnly.Load() && job.ringBuff.Size() < job.batchSize {
+		job.tryLoadData()
+	}
+	items := job.ringBuff.Peek(job.batchSize)
+	if len(items) >= job.batchSize || job.draining() {
+		return items
+	}
+	timer := time.NewTimer(job.batchWait)
+	defer timer.Stop()
+	for len(items) < job.batchSize {
+		select {
+		case <-job.notify:
+			items = job.ringBuff.Peek(job.batchSize)
+		case <-timer.C:
+			return job.ringBuff.Peek(job.batchSize)
+		case <-job.stopping:
+			return job.ringBuff.Peek(job.batchSize)
+		}
+	}
+	return items
+}
+
+// send 上报一批数据，被上报服务拒绝的批次拆成单条重新上报，单条被拒绝的放入死信文件，避免阻塞整个队列
+func (job *BandwidthReportJob) send(items []*reportItem) error {
+	err := job.doSend(items)
+	if err == nil || !errors.Is(err, api.ErrInvalidReport) {
+		return err
+	}
+	if len(items) == 1 {
+		reportFailures.WithLabelValues(failureDeadLetter).Inc()
+		log.Errorf(context.Background(), "report request rejected, move to dead letter req:%v err:%v", items[0].req, err)
+		if err = job.deadLetter.Store(context.Background(), []*model.ReportFlowBizRequest{items[0].req}); err != nil {
+			log.Errorf(context.Background(), "write dead letter failure err:%v", err)
+		}
+		job.ack(items)
+		return nil
+	}
+	for index, item := range items {
+		if err = job.send([]*reportItem{item}); err != nil {
+			log.Warnf(context.Background(), "report bandwidth one by one failure, sent:%d err:%v", index, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (job *BandwidthReportJob) doSend(items []*reportItem) error {
+	reqs := make([]*model.ReportFlowBizRequest, 0, len(items))
+	for _, item := range items {
+		reqs = append(reqs, item.req)
+	}
+	start := time.Now()
+	err := job.client.ReportBandwidthData(job.sendCtx, reqs)
+	reportSendDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+	reportSent.Add(float64(len(items)))
+	job.ack(items)
+	return nil
+}
+
+// SetBufLen 修改环形队列的容量，队列里的数据比新容量多时等数据上报出队后再生效
+func (job *BandwidthReportJob) SetBufLen(bufLen int) {
+	if bufLen <= 0 {
+		bufLen = defaultBufLen
+	}
+	job.pendingLen.Store(int64(bufLen))
+	job.applyBufLen()
+}
+
+func (job *BandwidthReportJob) applyBufLen() {
+	bufLen := job.pendingLen.Load()
+	if bufLen == 0 {
+		return
+	}
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if job.ringBuff.Capacity() == int(bufLen) || job.ringBuff.Resize(int(bufLen)) {
+		job.pendingLen.CompareAndSwap(bufLen, 0)
+	}
+}
+
+// ack 出队已经处理完的数据，并commit其中最后一条来自wal的数据的位置
+func (job *BandwidthReportJob) ack(items []*reportItem) {
+	job.ringBuff.Discard(len(items))
+	job.applyBufLen()
+	for index := len(items) - 1; index >= 0; index-- {
+		if !items[index].persisted {
+			continue
+		}
+		if err := job.wal.Commit(items[index].pos); err != nil {
+			log.Warnf(context.Background(), "commit wal failure err:%v", err)
+		}
+		return
+	}
+}
+
+func (job *BandwidthReportJob) draining() bool {
+	select {
+	case <-job.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (job *BandwidthReportJob) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-job.stopping:
+	case <-job.sendCtx.Done():
+	}
+}
+
 func (job *BandwidthReportJob) Add(ctx context.Context, req *model.ReportFlowBizRequest, forceAdd bool) {
 	job.mutex.Lock()
 	defer job.mutex.Unlock()
 	job.doAdd(ctx, req, forceAdd)
+	select {
+	case job.notify <- struct{}{}:
+	default:
+	}
 }
 
 func (job *BandwidthReportJob) doAdd(ctx context.Context, req *model.ReportFlowBizRequest, forceAdd bool) {
-	//数据仅仅在buff里，才会试图进入到环形队列里面，否则就写到缓存里面
-	if (job.dataBuffOnly.Load() || forceAdd) && job.ringBuff.Enqueue(req) {
+	//数据仅仅在buff里，才会试图进入到环形队列里面，否则就写到wal里面，保证上报的顺序
+	if (job.dataBuffOnly.Load() || forceAdd) && job.ringBuff.Enqueue(&reportItem{req: req}) {
 		return
 	}
-	job.tmp = append(job.tmp, req)
-	if len(job.tmp) >= BufLen { //大于缓存就的写到文件里面
-		err := job.writeToFile(ctx, job.tmp)
-		if err != nil {
-			log.Warnf(context.Background(), "loadToFile failure err:%v", err)
-		} else {
-			job.tmp = nil
-		}
+	if job.dataBuffOnly.Load() {
+		job.spillBuffered(ctx)
+	}
+	if err := job.wal.Append(ctx, []*model.ReportFlowBizRequest{req}); err != nil {
+		log.Errorf(ctx, "write to wal failure err:%v", err)
 	}
 	job.dataBuffOnly.Store(false)
 }
 
+// spillBuffered 环形队列满了开始写wal时，先把队列里只在内存里的数据写到wal里，保证wal里的顺序和上报顺序一致，
+// 重启后不会出现后加的数据先上报；这些数据已经在队列里，wal的读取位置跳过它们
+func (job *BandwidthReportJob) spillBuffered(ctx context.Context) {
+	var items []*reportItem
+	var reqs []*model.ReportFlowBizRequest
+	for _, item := range job.ringBuff.Surplus() {
+		if !item.persisted && !item.spilled.Load() {
+			items = append(items, item)
+			reqs = append(reqs, item.req)
+		}
+	}
+	if len(reqs) == 0 {
+		return
+	}
+	if err := job.wal.Append(ctx, reqs); err != nil {
+		log.Errorf(ctx, "write buffered requests to wal failure err:%v", err)
+		return
+	}
+	if _, err := job.wal.Load(ctx, len(reqs)); err != nil {
+		log.Errorf(ctx, "skip buffered requests in wal failure err:%v", err)
+	}
+	for _, item := range items {
+		item.spilled.Store(true)
+	}
+}
+
 // 试着加载数据
-// 1、先加载文件里面的数据，如果文件的数据加载完了再tmp的数据
-// 2、加载完tmp的数据到环形队列里面，如果tmp加载完了的话，说明只有buff里面有数据,dataBuffOnly改成true
+// 1、从wal里加载数据填满环形队列，加载的数据上报成功后再commit
+// 2、wal里的数据都加载完了，说明只有buff里面有数据,dataBuffOnly改成true
 func (job *BandwidthReportJob) tryLoadData() {
 	job.mutex.Lock()
 	defer job.mutex.Unlock()
-	if job.ringBuff.SurplusCount() == 0 {
+	surplusCount := job.ringBuff.SurplusCount()
+	if surplusCount == 0 {
 		return
 	}
-	reportFlowBizRequests, err := job.loadFromFile(context.TODO(), job.ringBuff.SurplusCount())
+	entries, err := job.wal.Load(context.TODO(), surplusCount)
 	if err != nil {
-		log.Errorf(context.TODO(), "loadFromFile failure err:%v", err)
+		log.Errorf(context.TODO(), "load from wal failure err:%v", err)
 	}
-	for _, reportFlowBizRequest := range reportFlowBizRequests {
-		job.doAdd(context.TODO(), reportFlowBizRequest, true)
+	for _, entry := range entries {
+		job.ringBuff.Enqueue(&reportItem{req: entry.Value, pos: entry.Next, persisted: true})
 	}
-	index := 0
-	for _, t := range job.tmp {
-		if job.ringBuff.SurplusCount() > 0 {
-			job.doAdd(context.TODO(), t, true)
-		} else {
-			job.tmp = job.tmp[index:]
-			return
-		}
-		index++
+	if job.wal.Empty() {
+		job.dataBuffOnly.Store(true)
 	}
-	job.tmp = nil
-	job.dataBuffOnly.Store(true)
-
-}
-func (job *BandwidthReportJob) writeToFile(ctx context.Context, data []*model.ReportFlowBizRequest) error {
-	return job.store.Store(ctx, data)
-}
-func (job *BandwidthReportJob) loadFromFile(ctx context.Context, rows int) ([]*model.ReportFlowBizRequest, error) {
-	return job.store.Load(ctx, rows)
 }
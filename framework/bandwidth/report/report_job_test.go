@@ -0,0 +1,232 @@
+package report
+
+import (
+	"accumulation/framework/bandwidth/api"
+	"accumulation/framework/bandwidth/conf"
+	"accumulation/framework/bandwidth/model"
+	"accumulation/framework/bandwidth/store"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type fakeReportClient struct {
+	mutex   sync.Mutex
+	batches [][]*model.ReportFlowBizRequest
+	reject  int // 被拒绝的单条请求个数，还有要拒绝的请求时整批拒绝
+	fail    bool
+}
+
+func (c *fakeReportClient) ReportBandwidthData(ctx context.Context, data interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.fail {
+		return fmt.Errorf("unavailable")
+	}
+	reqs := data.([]*model.ReportFlowBizRequest)
+	if c.reject > 0 {
+		if len(reqs) == 1 {
+			c.reject--
+		}
+		return fmt.Errorf("%w: bad request", api.ErrInvalidReport)
+	}
+	c.batches = append(c.batches, reqs)
+	return nil
+}
+
+func (c *fakeReportClient) sent() (batches, total int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, batch := range c.batches {
+		total += len(batch)
+	}
+	return len(c.batches), total
+}
+
+func newTestJob(t *testing.T, client api.BandwidthReportClient, batchSize int32) (*BandwidthReportJob, string) {
+	dir := t.TempDir()
+	job := NewBandwidthReportJob(client, &conf.Data{Acl: &conf.Acl{ReportConfig: &conf.Acl_ReportConfig{
+		ReportJobBufLen: 100,
+		SpillDir:        filepath.Join(dir, "wal"),
+		DeadLetterPath:  filepath.Join(dir, "dead_letter.dat"),
+		ReportBatchSize: batchSize,
+		ReportBatchWait: durationpb.New(20 * time.Millisecond),
+	}}})
+	return job, dir
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReportJobBatch(t *testing.T) {
+	client := &fakeReportClient{}
+	job, _ := newTestJob(t, client, 5)
+	ctx := context.Background()
+	if err := job.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 12; i++ {
+		job.Add(ctx, &model.ReportFlowBizRequest{}, false)
+	}
+	waitFor(t, func() bool {
+		_, total := client.sent()
+		return total == 12
+	})
+	if batches, _ := client.sent(); batches >= 12 {
+		t.Fatalf("expected requests to be batched, got %d batches", batches)
+	}
+	if err := job.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReportJobDeadLetter(t *testing.T) {
+	client := &fakeReportClient{reject: 1}
+	job, dir := newTestJob(t, client, 10)
+	ctx := context.Background()
+	if err := job.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		job.Add(ctx, &model.ReportFlowBizRequest{}, false)
+	}
+	waitFor(t, func() bool {
+		_, total := client.sent()
+		return total == 2
+	})
+	if err := job.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deadLetter := store.NewFileStore[*model.ReportFlowBizRequest](filepath.Join(dir, "dead_letter.dat"))
+	if err := deadLetter.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer deadLetter.Close()
+	reqs, err := deadLetter.Load(ctx, 10)
+	if err != nil || len(reqs) != 1 {
+		t.Fatalf("expected one dead letter, got %d err:%v", len(reqs), err)
+	}
+}
+
+func TestReportJobStopPersistsUnsent(t *testing.T) {
+	client := &fakeReportClient{fail: true}
+	job, dir := newTestJob(t, client, 10)
+	ctx := context.Background()
+	if err := job.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		job.Add(ctx, &model.ReportFlowBizRequest{}, false)
+	}
+	// 上报服务不可用时Stop不会一直重试
+	start := time.Now()
+	if err := job.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= defaultDrainTimeout {
+		t.Fatalf("expected stop not to wait for upstream, took %v", elapsed)
+	}
+
+	wal := store.NewWAL[*model.ReportFlowBizRequest](store.WALOptions{Dir: filepath.Join(dir, "wal")})
+	if err := wal.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	entries, err := wal.Load(ctx, 10)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("expected unsent requests in wal, got %d err:%v", len(entries), err)
+	}
+}
+
+func TestReportJobStopKeepsOrder(t *testing.T) {
+	client := &fakeReportClient{fail: true}
+	job, dir := newTestJob(t, client, 10)
+	job.SetBufLen(2)
+	ctx := context.Background()
+	if err := job.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 前两条在队列里，后两条队列满了写到wal
+	for i := 0; i < 4; i++ {
+		req := &model.ReportFlowBizRequest{}
+		req.ReportId = fmt.Sprint(i)
+		job.Add(ctx, req, false)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := job.Stop(cancelled); err != nil {
+		t.Fatal(err)
+	}
+
+	wal := store.NewWAL[*model.ReportFlowBizRequest](store.WALOptions{Dir: filepath.Join(dir, "wal")})
+	if err := wal.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	entries, err := wal.Load(ctx, 10)
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("expected unsent requests in wal, got %d err:%v", len(entries), err)
+	}
+	for i, entry := range entries {
+		if entry.Value.ReportId != fmt.Sprint(i) {
+			t.Fatalf("expected request %d at %d, got %s", i, i, entry.Value.ReportId)
+		}
+	}
+}
+
+func TestReportJobBatchWait(t *testing.T) {
+	job := NewBandwidthReportJob(&fakeReportClient{}, &conf.Data{Acl: &conf.Acl{ReportConfig: &conf.Acl_ReportConfig{
+		ReportBatchWait: durationpb.New(0),
+	}}})
+	if job.batchWait != minBatchWait {
+		t.Fatalf("expected batch wait to be clamped to %v, got %v", minBatchWait, job.batchWait)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(100*time.Millisecond, time.Second)
+	for i, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		d := b.Next()
+		if d < max/2 || d > max {
+			t.Fatalf("attempt %d backoff %v not in [%v,%v]", i, d, max/2, max)
+		}
+	}
+	b.Reset()
+	if d := b.Next(); d > 100*time.Millisecond {
+		t.Fatalf("expected reset backoff, got %v", d)
+	}
+}
+
+func TestReportJobSetBufLen(t *testing.T) {
+	client := &fakeReportClient{fail: true}
+	job, _ := newTestJob(t, client, 1)
+	for i := 0; i < 5; i++ {
+		job.ringBuff.Enqueue(&reportItem{req: &model.ReportFlowBizRequest{}})
+	}
+	// 队列里的数据比新容量多，等数据出队后再缩容
+	job.SetBufLen(3)
+	if job.ringBuff.Capacity() != 100 {
+		t.Fatalf("expected resize to be pending")
+	}
+	job.ack(job.ringBuff.Peek(2))
+	if job.ringBuff.Capacity() != 3 || job.pendingLen.Load() != 0 {
+		t.Fatalf("expected pending resize to be applied, capacity %d", job.ringBuff.Capacity())
+	}
+	job.SetBufLen(0)
+	if job.ringBuff.Capacity() != defaultBufLen {
+		t.Fatalf("expected default buf len, got %d", job.ringBuff.Capacity())
+	}
+}
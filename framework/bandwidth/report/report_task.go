@@ -1,30 +1,70 @@
 package report
 
 import (
+	"accumulation/framework/bandwidth/anomaly"
 	"accumulation/framework/bandwidth/api"
+	"accumulation/framework/bandwidth/conf"
 	model2 "accumulation/framework/bandwidth/model"
 	"accumulation/framework/bandwidth/store"
+	"accumulation/pkg/hardware"
 	"context"
 	"fmt"
 	"github.com/go-kratos/kratos/v2/log"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/robfig/cron/v3"
 
 	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// TaskState 上报任务的状态，只能按 starting -> running -> stopping -> stopped 的顺序变化
+// starting状态的任务可以直接停止，不会再启动
+type TaskState int32
+
+const (
+	TaskStarting TaskState = iota
+	TaskRunning
+	TaskStopping
+	TaskStopped
+)
+
+func (s TaskState) String() string {
+	switch s {
+	case TaskStarting:
+		return "starting"
+	case TaskRunning:
+		return "running"
+	case TaskStopping:
+		return "stopping"
+	case TaskStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("TaskState(%d)", int32(s))
+}
+
+const defaultReportInterval = 10 * time.Second
+
 type BandwidthReportTask struct {
 	session        *model2.Session
-	isRunnable     atomic.Bool
+	state          atomic.Int32
+	mutex          sync.Mutex // 串行化Start和Stop
+	lastActive     atomic.Int64
+	release        func(ctx context.Context)
 	lastStatTime   int64
 	engine         store.BandwidthEngine
 	cron           *cron.Cron
+	entryID        cron.EntryID
+	reportInterval time.Duration
 	manager        api.BandwidthReportManager
-	sessionTimeout int64
+	sessionTimeout atomic.Int64
 	no             int32
 	job            *BandwidthReportJob
 	hardwareType   string
+	endpoint       atomic.Pointer[model2.StreamEndpoint]
+	detector       *anomaly.Detector
+	history        *trafficHistory
 }
 
 func NewBandwidthReportTask(
@@ -32,28 +72,61 @@ func NewBandwidthReportTask(
 	engine store.BandwidthEngine,
 	job *BandwidthReportJob,
 	manager api.BandwidthReportManager) *BandwidthReportTask {
-	return &BandwidthReportTask{session: sess,
-		engine:       engine,
-		manager:      manager,
-		job:          job,
-		hardwareType: fmt.Sprintf("%s%s", "", ""),
+	task := &BandwidthReportTask{session: sess,
+		engine:         engine,
+		manager:        manager,
+		job:            job,
+		reportInterval: defaultReportInterval,
+		hardwareType:   fmt.Sprintf("%s%s%s", hardware.CPUModel(), model2.Sep, hardware.GPUModel()),
 	}
+	task.endpoint.Store(&model2.StreamEndpoint{StreamIp: sess.StreamIp, StreamPorts: sess.StreamPorts, StreamProcs: sess.StreamProcs})
+	return task
+}
+
+// UpdateStreamEndpoint 替换串流地址，正在统计的周期不受影响，下一个周期生效
+func (trt *BandwidthReportTask) UpdateStreamEndpoint(endpoint *model2.StreamEndpoint) {
+	trt.endpoint.Store(endpoint)
+}
+
+func (trt *BandwidthReportTask) State() TaskState {
+	return TaskState(trt.state.Load())
 }
 
+// Stop 停止任务，等待正在执行的统计结束后释放共享的engine，多次调用只生效一次
 func (trt *BandwidthReportTask) Stop(ctx context.Context) {
-	trt.cron.Stop()
-	trt.isRunnable.Swap(false)
+	trt.mutex.Lock()
+	state := trt.State()
+	if state != TaskStarting && state != TaskRunning {
+		trt.mutex.Unlock()
+		return
+	}
+	trt.state.Store(int32(TaskStopping))
+	if trt.cron != nil {
+		<-trt.cron.Stop().Done()
+	}
+	trt.state.Store(int32(TaskStopped))
+	trt.mutex.Unlock()
 	log.Infof("flowId %s,max no %d", trt.session.FlowID, trt.no)
+	trt.unobserve()
+	if trt.release != nil {
+		trt.release(ctx)
+	}
 	trt.manager.RemoveTask(context.Background(), trt.session)
 }
 
+// Start 启动任务，任务已经被停止的话不会再启动
 func (trt *BandwidthReportTask) Start(ctx context.Context) {
+	trt.mutex.Lock()
+	defer trt.mutex.Unlock()
+	if trt.State() != TaskStarting {
+		return
+	}
 	log.Infof("session[%s]  start collect bandwidth",
 		trt.session.String())
-	trt.isRunnable.Swap(true)
 	trt.lastStatTime = trt.session.Start
+	trt.lastActive.Store(time.Now().Unix())
 	trt.backendReport()
-
+	trt.state.Store(int32(TaskRunning))
 }
 
 func (trt *BandwidthReportTask) backendReport() {
@@ -64,14 +137,41 @@ func (trt *BandwidthReportTask) backendReport() {
 	}()
 
 	trt.cron = cron.New(cron.WithSeconds())
-	_, err := trt.cron.AddFunc("*/10 * * * * *", func() {
+	var err error
+	trt.entryID, err = trt.cron.AddFunc(conf.CronSpec(trt.reportInterval), func() {
 		trt.periodFetchBandwidth()
 	})
 	trt.cron.Start()
 	if err != nil {
-		log.Errorf("c.AddFunc error|err=%v|stack=%v", err)
+		log.Errorf("c.AddFunc error|err=%v", err)
+	}
+
+}
+
+// SetReportInterval 修改统计周期，替换cron里的任务，下一个周期从上次统计的时间开始，不会丢失流量
+func (trt *BandwidthReportTask) SetReportInterval(interval time.Duration) error {
+	trt.mutex.Lock()
+	defer trt.mutex.Unlock()
+	if interval <= 0 || interval == trt.reportInterval {
+		return nil
 	}
+	if trt.State() == TaskRunning {
+		entryID, err := trt.cron.AddFunc(conf.CronSpec(interval), func() {
+			trt.periodFetchBandwidth()
+		})
+		if err != nil {
+			return err
+		}
+		trt.cron.Remove(trt.entryID)
+		trt.entryID = entryID
+	}
+	trt.reportInterval = interval
+	return nil
+}
 
+// SetSessionTimeout 修改会话空闲超时时间，单位秒，0表示不超时
+func (trt *BandwidthReportTask) SetSessionTimeout(sessionTimeout int64) {
+	trt.sessionTimeout.Store(sessionTimeout)
 }
 func (trt *BandwidthReportTask) periodFetchBandwidth() {
 	defer func() {
@@ -80,8 +180,18 @@ func (trt *BandwidthReportTask) periodFetchBandwidth() {
 		}
 	}()
 	now := time.Now().Unix()
+	if trt.expired(now) {
+		log.Infof("session[%s] idle for %ds, expire it", trt.session.SessionKey(), now-trt.lastActive.Load())
+		// Stop会等待正在执行的统计结束，不能在当前goroutine里调用
+		go trt.Stop(context.Background())
+		return
+	}
+	endpoint := trt.endpoint.Load()
+	if trt.history != nil {
+		trt.history.track(trt.session.SessionKey(), endpoint)
+	}
 	filter := func(bandwidth *model2.Bandwidth) bool {
-		if len(trt.session.StreamIp) > 0 && bandwidth.Ip != trt.session.StreamIp {
+		if len(endpoint.StreamIp) > 0 && bandwidth.Ip != endpoint.StreamIp {
 			return false
 		}
 		return true
@@ -92,33 +202,98 @@ func (trt *BandwidthReportTask) periodFetchBandwidth() {
 		return
 	}
 	if len(bandwidths) == 0 {
+		trt.detect(anomaly.Sample{Start: trt.lastStatTime, End: now})
 		return
 	}
+	trt.lastActive.Store(now)
+	start := trt.lastStatTime
 	trt.lastStatTime = now
 	upTotal, downTotal := model2.NewBandwidths(bandwidths).Group()
 	if upTotal+downTotal < 1 {
 		return
 	}
-	portFilter := func(bandwidth *model2.Bandwidth) bool {
-		if len(trt.session.StreamPorts) > 0 && !trt.session.StreamPorts.Contains(bandwidth.Port) {
-			return false
-		}
-		return true
-	}
-	upstream, downstream := model2.NewBandwidths(model2.NewBandwidths(bandwidths).Filter(portFilter)).Group()
+	streamBandwidths := model2.NewBandwidths(bandwidths).Filter(endpoint.Stream)
+	upstream, downstream := model2.NewBandwidths(streamBandwidths).Group()
+	trt.observe(endpoint, streamBandwidths, now-start)
+	trt.detect(anomaly.Sample{Start: start, End: now, Up: int64(upstream), Down: int64(downstream)})
 	if upstream+downstream < 1 {
 		return
 	}
-	trt.job.Add(context.TODO(), trt.buildReportFlowBizRequest(upTotal, downTotal, upstream, downstream), false)
 	trt.no++
+	trt.job.Add(context.TODO(), trt.buildReportFlowBizRequest(endpoint, start, now, upTotal, downTotal, upstream, downstream), false)
+}
+
+// observe 记录会话的流量指标，配置了串流端口时按端口区分，否则stream_port为空
+func (trt *BandwidthReportTask) observe(endpoint *model2.StreamEndpoint, bandwidths []*model2.Bandwidth, seconds int64) {
+	ports := map[string][2]int64{}
+	for _, bandwidth := range bandwidths {
+		port := ""
+		if len(endpoint.StreamPorts) > 0 {
+			port = bandwidth.Port
+		}
+		total := ports[port]
+		total[0] += int64(bandwidth.UpLen)
+		total[1] += int64(bandwidth.DownLen)
+		ports[port] = total
+	}
+	gid, vmid := fmt.Sprint(trt.session.GID), fmt.Sprint(trt.session.VMid)
+	for port, total := range ports {
+		sessionBytes.WithLabelValues(gid, vmid, trt.session.FlowID, port, directionUp).Add(float64(total[0]))
+		sessionBytes.WithLabelValues(gid, vmid, trt.session.FlowID, port, directionDown).Add(float64(total[1]))
+		if seconds > 0 {
+			sessionRate.WithLabelValues(gid, vmid, trt.session.FlowID, port, directionUp).Set(float64(total[0]) / float64(seconds))
+			sessionRate.WithLabelValues(gid, vmid, trt.session.FlowID, port, directionDown).Set(float64(total[1]) / float64(seconds))
+		}
+	}
 }
 
-func (trt *BandwidthReportTask) buildReportFlowBizRequest(upTotal, downTotal, upstream, downstream int32) *model2.ReportFlowBizRequest {
+// detect 检测串流流量是否异常
+func (trt *BandwidthReportTask) detect(sample anomaly.Sample) {
+	if trt.detector == nil {
+		return
+	}
+	trt.detector.Observe(context.TODO(), sample)
+}
 
-	return &model2.ReportFlowBizRequest{}
+// unobserve 会话结束后删除会话的指标，避免指标无限增长
+func (trt *BandwidthReportTask) unobserve() {
+	labels := prometheus.Labels{"vmid": fmt.Sprint(trt.session.VMid), "flow_id": trt.session.FlowID}
+	sessionBytes.DeletePartialMatch(labels)
+	sessionRate.DeletePartialMatch(labels)
+}
+
+// expired 超过sessionTimeout没有流量的会话认为已经结束，避免丢失结束事件的会话一直占用资源
+func (trt *BandwidthReportTask) expired(now int64) bool {
+	sessionTimeout := trt.sessionTimeout.Load()
+	return sessionTimeout > 0 && now-trt.lastActive.Load() > sessionTimeout
+}
 
+func (trt *BandwidthReportTask) buildReportFlowBizRequest(endpoint *model2.StreamEndpoint,
+	start, end int64, upTotal, downTotal, upstream, downstream int32) *model2.ReportFlowBizRequest {
+	req := &model2.ReportFlowBizRequest{}
+	req.ReportId = trt.session.ReportId()
+	req.FlowId = trt.session.FlowID
+	req.BizId = trt.session.BizID
+	req.Gid = trt.session.GID
+	req.Uuid = trt.session.UUID
+	req.Vmid = trt.session.VMid
+	req.AreaType = trt.session.AreaType
+	req.InstanceId = trt.session.InstanceId
+	req.Idc = trt.session.Idc
+	req.No = trt.no
+	req.StartTime = start
+	req.EndTime = end
+	req.UpTotal = int64(upTotal)
+	req.DownTotal = int64(downTotal)
+	req.StreamUp = int64(upstream)
+	req.StreamDown = int64(downstream)
+	req.StreamIp = endpoint.StreamIp
+	req.Eip = trt.session.EIP
+	req.ImageVersion = int32(trt.session.ImageVersion)
+	req.HardwareType = trt.hardwareType
+	return req
 }
 
 func (trt *BandwidthReportTask) IsRunnable() bool {
-	return trt.isRunnable.Load()
+	return trt.State() == TaskRunning
 }
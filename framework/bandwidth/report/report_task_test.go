@@ -0,0 +1,159 @@
+package report
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeEngine struct {
+	mutex sync.Mutex
+	data  model.Bandwidths
+}
+
+func (e *fakeEngine) Start(ctx context.Context) error { return nil }
+
+func (e *fakeEngine) Stop(ctx context.Context) error { return nil }
+
+func (e *fakeEngine) Store(ctx context.Context, bandwidths []*model.Bandwidth) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.data = append(e.data, bandwidths...)
+	return nil
+}
+
+func (e *fakeEngine) Query(ctx context.Context, filter model.Filters, startTime, endTime int64) ([]*model.Bandwidth, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.data.Filter(filter), nil
+}
+
+func TestNotifyAccessInfo(t *testing.T) {
+	client := &fakeReportClient{}
+	job, _ := newTestJob(t, client, 1)
+	ctx := context.Background()
+	if err := job.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer job.Stop(ctx)
+	engine := &fakeEngine{data: model.Bandwidths{
+		{Ip: "10.0.0.1", Port: "8000", UpLen: 10, DownLen: 100},
+		{Ip: "10.0.0.1", Port: "9000", UpLen: 20, DownLen: 200},
+		{Ip: "10.0.0.2", Port: "8000", UpLen: 40, DownLen: 400},
+	}}
+	manager := &bandwidthReportManager{mutex: &sync.Mutex{}, tasks: map[string]*BandwidthReportTask{}}
+	session := &model.Session{InstanceId: "i-1", VMid: 7, Start: time.Now().Unix() - 10}
+	task := NewBandwidthReportTask(session, engine, job, manager)
+	manager.tasks[session.SessionKey()] = task
+
+	// 串流地址还没有分配，统计所有流量
+	task.periodFetchBandwidth()
+	if err := manager.NotifyAccessInfo(ctx, 7, "10.0.0.1", model.StreamPorts{{Port: 9000}}); err != nil {
+		t.Fatal(err)
+	}
+	task.periodFetchBandwidth()
+	if err := manager.NotifyAccessInfo(ctx, 8, "10.0.0.1", nil); err == nil {
+		t.Fatalf("expected unknown vmid to fail")
+	}
+
+	waitFor(t, func() bool {
+		_, total := client.sent()
+		return total == 2
+	})
+	var got []string
+	for _, batch := range client.batches {
+		for _, req := range batch {
+			got = append(got, fmt.Sprintf("%s:%d/%d:%d/%d", req.StreamIp, req.UpTotal, req.DownTotal, req.StreamUp, req.StreamDown))
+		}
+	}
+	want := []string{":70/700:70/700", "10.0.0.1:30/300:20/200"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got reports %v, want %v", got, want)
+	}
+}
+
+func TestSessionMetrics(t *testing.T) {
+	client := &fakeReportClient{}
+	job, _ := newTestJob(t, client, 1)
+	engine := &fakeEngine{data: model.Bandwidths{
+		{Ip: "10.0.0.1", Port: "8000", UpLen: 10, DownLen: 100},
+		{Ip: "10.0.0.1", Port: "9000", UpLen: 20, DownLen: 200},
+	}}
+	manager := &bandwidthReportManager{mutex: &sync.Mutex{}, tasks: map[string]*BandwidthReportTask{}}
+	session := &model.Session{InstanceId: "i-1", GID: 3, VMid: 9, FlowID: "metrics", Start: time.Now().Unix() - 10,
+		StreamIp: "10.0.0.1", StreamPorts: model.StreamPorts{{Port: 9000}}}
+	task := NewBandwidthReportTask(session, engine, job, manager)
+	manager.tasks[session.SessionKey()] = task
+	task.periodFetchBandwidth()
+	task.periodFetchBandwidth()
+
+	if got := testutil.ToFloat64(sessionBytes.WithLabelValues("3", "9", "metrics", "9000", directionDown)); got != 400 {
+		t.Fatalf("unexpected session down bytes %v", got)
+	}
+	if got := testutil.ToFloat64(sessionRate.WithLabelValues("3", "9", "metrics", "9000", directionUp)); got <= 0 {
+		t.Fatalf("unexpected session up rate %v", got)
+	}
+	before := testutil.CollectAndCount(sessionBytes)
+	task.unobserve()
+	if got := testutil.CollectAndCount(sessionBytes); got != before-2 {
+		t.Fatalf("expected session metrics to be deleted, %d before %d after", before, got)
+	}
+}
+
+func TestStreamProcessFilter(t *testing.T) {
+	client := &fakeReportClient{}
+	job, _ := newTestJob(t, client, 1)
+	ctx := context.Background()
+	if err := job.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer job.Stop(ctx)
+	engine := &fakeEngine{data: model.Bandwidths{
+		{Ip: "10.0.0.1", Port: "9000", UpLen: 10, DownLen: 100, Pid: 100, Process: "game"},
+		{Ip: "10.0.0.1", Port: "50000", UpLen: 20, DownLen: 200, Pid: 100, Process: "game"},
+		{Ip: "10.0.0.1", Port: "9000", UpLen: 40, DownLen: 400, Pid: 200, Process: "sidecar"},
+	}}
+	manager := &bandwidthReportManager{mutex: &sync.Mutex{}, tasks: map[string]*BandwidthReportTask{}}
+	session := &model.Session{InstanceId: "i-1", VMid: 7, Start: time.Now().Unix() - 10,
+		StreamProcs: model.StreamProcs{"game"}}
+	task := NewBandwidthReportTask(session, engine, job, manager)
+	manager.tasks[session.SessionKey()] = task
+
+	// 只配置串流进程时统计进程的所有端口
+	task.periodFetchBandwidth()
+	// 同时配置端口时端口和进程都要匹配，串流进程不会被通知覆盖
+	if err := manager.NotifyAccessInfo(ctx, 7, "10.0.0.1", model.StreamPorts{{Port: 9000}}); err != nil {
+		t.Fatal(err)
+	}
+	task.periodFetchBandwidth()
+
+	waitFor(t, func() bool {
+		_, total := client.sent()
+		return total == 2
+	})
+	var got []string
+	for _, batch := range client.batches {
+		for _, req := range batch {
+			got = append(got, fmt.Sprintf("%d/%d:%d/%d", req.UpTotal, req.DownTotal, req.StreamUp, req.StreamDown))
+		}
+	}
+	want := []string{"70/700:30/300", "70/700:10/100"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got reports %v, want %v", got, want)
+	}
+	// 没有开启ProcessAttribution时流量没有进程名，不按进程过滤
+	endpoint := model.StreamEndpoint{StreamProcs: model.StreamProcs{"game"}}
+	if !endpoint.Stream(&model.Bandwidth{Ip: "10.0.0.1", Port: "9000"}) {
+		t.Fatal("expected traffic without process to be stream traffic")
+	}
+	// 网卡级别的流量没有端口，不按端口过滤
+	endpoint = model.StreamEndpoint{StreamPorts: model.StreamPorts{{Port: 9000}}}
+	if !endpoint.Stream(&model.Bandwidth{Ip: "10.0.0.1"}) || endpoint.Stream(&model.Bandwidth{Ip: "10.0.0.1", Port: "8000"}) {
+		t.Fatal("expected interface traffic to be stream traffic and other ports not")
+	}
+}
@@ -0,0 +1,72 @@
+package report
+
+import (
+	"accumulation/framework/bandwidth/collector"
+	"accumulation/framework/bandwidth/store"
+	"context"
+	"sync"
+)
+
+// sharedEngine 所有会话共享的collector和engine，第一个会话开始时创建，最后一个会话结束时销毁
+type sharedEngine struct {
+	mutex      sync.Mutex
+	refs       int
+	engine     store.BandwidthEngine
+	collectors []collector.Collector
+	build      func(ctx context.Context) ([]collector.Collector, store.BandwidthEngine, error)
+}
+
+func newSharedEngine(build func(ctx context.Context) ([]collector.Collector, store.BandwidthEngine, error)) *sharedEngine {
+	return &sharedEngine{build: build}
+}
+
+// Acquire 引用计数加一，需要的时候创建collector和engine
+func (s *sharedEngine) Acquire(ctx context.Context) (store.BandwidthEngine, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.engine == nil {
+		collectors, engine, err := s.build(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.collectors = collectors
+		s.engine = engine
+	}
+	s.refs++
+	return s.engine, nil
+}
+
+// Release 引用计数减一，没有引用时停止collector和engine
+func (s *sharedEngine) Release(ctx context.Context) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.refs == 0 {
+		return
+	}
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	for _, collector := range s.collectors {
+		collector.Stop(ctx)
+	}
+	s.engine.Stop(ctx)
+	s.collectors = nil
+	s.engine = nil
+}
+
+func (s *sharedEngine) Refs() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.refs
+}
+
+// Reconfigure 在锁里修改正在使用的collector和engine，还没有创建的时候不做处理，创建时会读取最新的配置
+func (s *sharedEngine) Reconfigure(apply func(collectors []collector.Collector, engine store.BandwidthEngine)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.engine == nil {
+		return
+	}
+	apply(s.collectors, s.engine)
+}
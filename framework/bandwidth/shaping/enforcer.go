@@ -0,0 +1,262 @@
+package shaping
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+const (
+	minClassMinor = 0x10
+	maxClassMinor = 0xfffe
+)
+
+// shaped 已经限速的会话
+type shaped struct {
+	limit  *Limit
+	device string
+	minor  uint16 // htb class的minor，同时作为filter的优先级
+}
+
+// Enforcer 按会话对串流端口的出方向限速：
+// 网卡上的根qdisc是htb(1:)，每个会话一个htb class(1:minor)，class下面挂fq，
+// u32 filter按源ip和串流端口把包分到会话的class，没有匹配的包不限速
+type Enforcer struct {
+	executor Executor
+	deviceOf func(ip string) (string, error)
+	mutex    sync.Mutex
+	sessions map[string]*shaped
+	pending  map[string]*Limit // 限速还没有生效的会话，串流地址更新时重试
+	devices  map[string]int    // 网卡上限速的会话个数，为0时删除根qdisc
+	minors   map[uint16]bool
+}
+
+func NewEnforcer(executor Executor) *Enforcer {
+	return &Enforcer{
+		executor: executor,
+		deviceOf: deviceOfIP,
+		sessions: map[string]*shaped{},
+		pending:  map[string]*Limit{},
+		devices:  map[string]int{},
+		minors:   map[uint16]bool{},
+	}
+}
+
+// Apply 按会话Extra里的限速配置对串流地址限速，没有配置时取消会话原来的限速。
+// 限速失败时(比如串流地址还没有分配)保留限速配置，Update时重试
+func (e *Enforcer) Apply(ctx context.Context, session *model.Session, endpoint *model.StreamEndpoint) error {
+	limit, err := ParseLimit(session.Extra)
+	if err != nil {
+		return err
+	}
+	key := session.SessionKey()
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.pending, key)
+	if old, ok := e.sessions[key]; ok {
+		if err = e.remove(ctx, key, old); err != nil {
+			return err
+		}
+	}
+	if limit == nil {
+		return nil
+	}
+	if err = e.apply(ctx, key, limit, endpoint); err != nil {
+		e.pending[key] = limit
+		return err
+	}
+	return nil
+}
+
+func (e *Enforcer) apply(ctx context.Context, key string, limit *Limit, endpoint *model.StreamEndpoint) error {
+	if err := checkStreamIP(endpoint.StreamIp); err != nil {
+		return err
+	}
+	device := limit.Device
+	if len(device) == 0 {
+		var err error
+		if device, err = e.deviceOf(endpoint.StreamIp); err != nil {
+			return err
+		}
+	}
+	minor, err := e.allocMinor()
+	if err != nil {
+		return err
+	}
+	state := &shaped{limit: limit, device: device, minor: minor}
+	var ops []Operation
+	if e.devices[device] == 0 {
+		ops = append(ops, Operation{Action: ActionReplace, Object: ObjectQdisc, Device: device,
+			Parent: handleRoot, Handle: makeHandle(rootMajor, 0), Kind: "htb"})
+	}
+	ops = append(ops,
+		Operation{Action: ActionAdd, Object: ObjectClass, Device: device, Parent: makeHandle(rootMajor, 0),
+			Handle: state.classID(), Kind: "htb", Rate: limit.Rate, Ceil: limit.Ceil, Burst: limit.Burst},
+		Operation{Action: ActionAdd, Object: ObjectQdisc, Device: device, Parent: state.classID(),
+			Handle: makeHandle(minor, 0), Kind: "fq"})
+	ops = append(ops, state.filters(endpoint)...)
+	if err = e.executor.Execute(ctx, ops); err != nil {
+		// 失败时尽量清理已经生效的操作
+		if e.devices[device] == 0 {
+			e.executor.Execute(ctx, []Operation{state.deleteRoot()})
+		} else {
+			e.executor.Execute(ctx, state.deletes())
+		}
+		delete(e.minors, minor)
+		return err
+	}
+	e.sessions[key] = state
+	e.devices[device]++
+	log.Infof("session[%s] shaping %s rate:%s ceil:%s class:%s", key, device,
+		formatRate(limit.Rate), formatRate(limit.Ceil), formatHandle(state.classID()))
+	return nil
+}
+
+// Update 串流地址变化后替换会话的filter，会话的限速还没有生效时按新的串流地址重试，会话没有限速时不做处理
+func (e *Enforcer) Update(ctx context.Context, sessionKey string, endpoint *model.StreamEndpoint) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	state, ok := e.sessions[sessionKey]
+	if !ok {
+		limit, pending := e.pending[sessionKey]
+		if !pending {
+			return nil
+		}
+		if err := e.apply(ctx, sessionKey, limit, endpoint); err != nil {
+			return err
+		}
+		delete(e.pending, sessionKey)
+		return nil
+	}
+	if err := checkStreamIP(endpoint.StreamIp); err != nil {
+		return err
+	}
+	return e.executor.Execute(ctx, append([]Operation{state.deleteFilters()}, state.filters(endpoint)...))
+}
+
+// Remove 取消会话的限速，网卡上没有限速的会话时删除根qdisc
+func (e *Enforcer) Remove(ctx context.Context, sessionKey string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.pending, sessionKey)
+	state, ok := e.sessions[sessionKey]
+	if !ok {
+		return nil
+	}
+	return e.remove(ctx, sessionKey, state)
+}
+
+func (e *Enforcer) remove(ctx context.Context, sessionKey string, state *shaped) error {
+	ops := state.deletes()
+	if e.devices[state.device] == 1 {
+		ops = []Operation{state.deleteRoot()}
+	}
+	if err := e.executor.Execute(ctx, ops); err != nil {
+		return err
+	}
+	delete(e.sessions, sessionKey)
+	delete(e.minors, state.minor)
+	if e.devices[state.device]--; e.devices[state.device] <= 0 {
+		delete(e.devices, state.device)
+	}
+	log.Infof("session[%s] shaping removed from %s", sessionKey, state.device)
+	return nil
+}
+
+// Sessions 正在限速的会话个数
+func (e *Enforcer) Sessions() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.sessions)
+}
+
+func (e *Enforcer) allocMinor() (uint16, error) {
+	for minor := uint16(minClassMinor); minor <= maxClassMinor; minor++ {
+		if !e.minors[minor] {
+			e.minors[minor] = true
+			return minor, nil
+		}
+	}
+	return 0, errors.New("no free htb class")
+}
+
+func (s *shaped) classID() uint32 {
+	return makeHandle(rootMajor, s.minor)
+}
+
+// filters 每个串流端口一个filter，没有串流端口时匹配串流ip的所有流量，
+// 串流ip还没有分配时不添加filter，不会把其他流量分到会话的class
+func (s *shaped) filters(endpoint *model.StreamEndpoint) []Operation {
+	if len(endpoint.StreamIp) == 0 {
+		return nil
+	}
+	filter := Operation{Action: ActionAdd, Object: ObjectFilter, Device: s.device, Parent: makeHandle(rootMajor, 0),
+		Handle: s.classID(), Kind: "u32", Prio: s.minor, SrcIP: endpoint.StreamIp}
+	if len(endpoint.StreamPorts) == 0 {
+		return []Operation{filter}
+	}
+	ops := make([]Operation, 0, len(endpoint.StreamPorts))
+	for _, port := range endpoint.StreamPorts {
+		filter.Port = uint16(port.Port)
+		ops = append(ops, filter)
+	}
+	return ops
+}
+
+func (s *shaped) deleteFilters() Operation {
+	return Operation{Action: ActionDelete, Object: ObjectFilter, Device: s.device, Parent: makeHandle(rootMajor, 0),
+		Kind: "u32", Prio: s.minor}
+}
+
+// deletes 删除会话的filter和class，class下面的fq随class一起删除
+func (s *shaped) deletes() []Operation {
+	return []Operation{s.deleteFilters(),
+		{Action: ActionDelete, Object: ObjectClass, Device: s.device, Parent: makeHandle(rootMajor, 0),
+			Handle: s.classID(), Kind: "htb"}}
+}
+
+// deleteRoot 删除根qdisc，网卡上所有的class和filter随之删除
+func (s *shaped) deleteRoot() Operation {
+	return Operation{Action: ActionDelete, Object: ObjectQdisc, Device: s.device, Parent: handleRoot,
+		Handle: makeHandle(rootMajor, 0), Kind: "htb"}
+}
+
+// deviceOfIP 找到配置了ip的网卡
+// checkStreamIP u32 filter只按IPv4的包头匹配，串流ip是IPv6时不能限速，为空时等串流地址分配后再添加filter
+func checkStreamIP(ip string) error {
+	if len(ip) == 0 {
+		return nil
+	}
+	if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil {
+		return fmt.Errorf("stream ip %q is not IPv4, shaping only supports IPv4", ip)
+	}
+	return nil
+}
+
+func deviceOfIP(ip string) (string, error) {
+	target := net.ParseIP(ip)
+	if target == nil {
+		return "", fmt.Errorf("invalid stream ip %q, shaping device is required", ip)
+	}
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, inter := range interfaces {
+		addrs, err := inter.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(target) {
+				return inter.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface has stream ip %s", ip)
+}
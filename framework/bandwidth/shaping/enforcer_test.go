@@ -0,0 +1,189 @@
+package shaping
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func newTestEnforcer(executor Executor) *Enforcer {
+	enforcer := NewEnforcer(executor)
+	enforcer.deviceOf = func(ip string) (string, error) {
+		if ip == "10.0.0.1" {
+			return "eth0", nil
+		}
+		return "", fmt.Errorf("no interface has stream ip %s", ip)
+	}
+	return enforcer
+}
+
+func commands(ops []Operation) string {
+	var lines []string
+	for _, op := range ops {
+		lines = append(lines, op.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func expectCommands(t *testing.T, dryRun *DryRun, want ...string) {
+	t.Helper()
+	if got := commands(dryRun.Operations()); got != strings.Join(want, "\n") {
+		t.Fatalf("got operations:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestEnforcer(t *testing.T) {
+	dryRun := &DryRun{}
+	enforcer := newTestEnforcer(dryRun)
+	ctx := context.Background()
+	first := &model.Session{InstanceId: "i-1", VMid: 1, Extra: `{"shaping":{"rate":"20mbit"}}`}
+	endpoint := &model.StreamEndpoint{StreamIp: "10.0.0.1", StreamPorts: model.StreamPorts{{Port: 9000}, {Port: 9001}}}
+	if err := enforcer.Apply(ctx, first, endpoint); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, dryRun,
+		"tc qdisc replace dev eth0 root handle 1: htb",
+		"tc class add dev eth0 parent 1: classid 1:10 htb rate 20mbit ceil 20mbit",
+		"tc qdisc add dev eth0 parent 1:10 handle 10: fq",
+		"tc filter add dev eth0 parent 1: protocol ip prio 16 u32 match ip src 10.0.0.1/32 match ip sport 9000 0xffff flowid 1:10",
+		"tc filter add dev eth0 parent 1: protocol ip prio 16 u32 match ip src 10.0.0.1/32 match ip sport 9001 0xffff flowid 1:10")
+
+	// 同一个网卡上的第二个会话复用根qdisc
+	second := &model.Session{InstanceId: "i-1", VMid: 2, Extra: `{"shaping":{"rate":"10mbit","ceil":"15mbit","device":"eth0"}}`}
+	if err := enforcer.Apply(ctx, second, &model.StreamEndpoint{}); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, dryRun,
+		"tc class add dev eth0 parent 1: classid 1:11 htb rate 10mbit ceil 15mbit",
+		"tc qdisc add dev eth0 parent 1:11 handle 11: fq")
+
+	if err := enforcer.Update(ctx, second.SessionKey(), &model.StreamEndpoint{StreamIp: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, dryRun,
+		"tc filter del dev eth0 parent 1: protocol ip prio 17",
+		"tc filter add dev eth0 parent 1: protocol ip prio 17 u32 match ip src 10.0.0.1/32 flowid 1:11")
+
+	if err := enforcer.Remove(ctx, first.SessionKey()); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, dryRun,
+		"tc filter del dev eth0 parent 1: protocol ip prio 16",
+		"tc class del dev eth0 parent 1: classid 1:10")
+	if err := enforcer.Remove(ctx, second.SessionKey()); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, dryRun, "tc qdisc del dev eth0 root handle 1:")
+	if enforcer.Sessions() != 0 || len(enforcer.minors) != 0 || len(enforcer.devices) != 0 {
+		t.Fatalf("expected all shaping state to be released")
+	}
+}
+
+func TestEnforcerWithoutLimit(t *testing.T) {
+	dryRun := &DryRun{}
+	enforcer := newTestEnforcer(dryRun)
+	ctx := context.Background()
+	session := &model.Session{InstanceId: "i-1", VMid: 1, Extra: `{"shaping":{"rate":"20mbit"}}`}
+	if err := enforcer.Apply(ctx, session, &model.StreamEndpoint{StreamIp: "10.0.0.2"}); err == nil {
+		t.Fatalf("expected unknown stream ip to fail")
+	}
+	if err := enforcer.Apply(ctx, session, &model.StreamEndpoint{StreamIp: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	dryRun.Operations()
+	// 重新开始的会话没有限速配置时取消原来的限速
+	session = &model.Session{InstanceId: "i-1", VMid: 1}
+	if err := enforcer.Apply(ctx, session, &model.StreamEndpoint{StreamIp: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, dryRun, "tc qdisc del dev eth0 root handle 1:")
+}
+
+func TestEnforcerPending(t *testing.T) {
+	dryRun := &DryRun{}
+	enforcer := newTestEnforcer(dryRun)
+	ctx := context.Background()
+	session := &model.Session{InstanceId: "i-1", VMid: 1, Extra: `{"shaping":{"rate":"20mbit"}}`}
+	// 串流地址还没有分配时限速失败，地址更新后重试
+	if err := enforcer.Apply(ctx, session, &model.StreamEndpoint{}); err == nil {
+		t.Fatalf("expected missing stream ip to fail")
+	}
+	if err := enforcer.Update(ctx, session.SessionKey(), &model.StreamEndpoint{StreamIp: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if enforcer.Sessions() != 1 || len(enforcer.pending) != 0 {
+		t.Fatalf("expected pending shaping to be applied")
+	}
+	expectCommands(t, dryRun,
+		"tc qdisc replace dev eth0 root handle 1: htb",
+		"tc class add dev eth0 parent 1: classid 1:10 htb rate 20mbit ceil 20mbit",
+		"tc qdisc add dev eth0 parent 1:10 handle 10: fq",
+		"tc filter add dev eth0 parent 1: protocol ip prio 16 u32 match ip src 10.0.0.1/32 flowid 1:10")
+
+	// 会话结束时丢弃没有生效的限速
+	other := &model.Session{InstanceId: "i-1", VMid: 2, Extra: `{"shaping":{"rate":"20mbit"}}`}
+	enforcer.Apply(ctx, other, &model.StreamEndpoint{})
+	if err := enforcer.Remove(ctx, other.SessionKey()); err != nil {
+		t.Fatal(err)
+	}
+	if len(enforcer.pending) != 0 {
+		t.Fatalf("expected pending shaping to be dropped")
+	}
+}
+
+func TestEnforcerRejectsIPv6(t *testing.T) {
+	dryRun := &DryRun{}
+	enforcer := newTestEnforcer(dryRun)
+	ctx := context.Background()
+	// 指定了网卡也不能对IPv6限速，不会生成匹配所有包的filter
+	session := &model.Session{InstanceId: "i-1", VMid: 1, Extra: `{"shaping":{"rate":"20mbit","device":"eth0"}}`}
+	if err := enforcer.Apply(ctx, session, &model.StreamEndpoint{StreamIp: "fd00::1", StreamPorts: model.StreamPorts{{Port: 9000}}}); err == nil {
+		t.Fatalf("expected IPv6 stream ip to fail")
+	}
+	if ops := dryRun.Operations(); len(ops) != 0 {
+		t.Fatalf("expected no operations, got %s", commands(ops))
+	}
+	// 还没有分配串流ip时只创建class，端口不单独生成filter
+	if err := enforcer.Apply(ctx, session, &model.StreamEndpoint{StreamPorts: model.StreamPorts{{Port: 9000}}}); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, dryRun,
+		"tc qdisc replace dev eth0 root handle 1: htb",
+		"tc class add dev eth0 parent 1: classid 1:10 htb rate 20mbit ceil 20mbit",
+		"tc qdisc add dev eth0 parent 1:10 handle 10: fq")
+	if err := enforcer.Update(ctx, session.SessionKey(), &model.StreamEndpoint{StreamIp: "fd00::1"}); err == nil {
+		t.Fatalf("expected IPv6 stream ip update to fail")
+	}
+	if ops := dryRun.Operations(); len(ops) != 0 {
+		t.Fatalf("expected no operations, got %s", commands(ops))
+	}
+}
+
+type failingExecutor struct {
+	DryRun
+	fail int // 第几批操作失败
+}
+
+func (e *failingExecutor) Execute(ctx context.Context, ops []Operation) error {
+	e.fail--
+	if e.fail == 0 {
+		return errors.New("operation not permitted")
+	}
+	return e.DryRun.Execute(ctx, ops)
+}
+
+func TestEnforcerRollback(t *testing.T) {
+	executor := &failingExecutor{fail: 1}
+	enforcer := newTestEnforcer(executor)
+	session := &model.Session{InstanceId: "i-1", VMid: 1, Extra: `{"shaping":{"rate":"20mbit"}}`}
+	if err := enforcer.Apply(context.Background(), session, &model.StreamEndpoint{StreamIp: "10.0.0.1"}); err == nil {
+		t.Fatalf("expected apply to fail")
+	}
+	expectCommands(t, &executor.DryRun, "tc qdisc del dev eth0 root handle 1:")
+	if enforcer.Sessions() != 0 || len(enforcer.minors) != 0 {
+		t.Fatalf("expected failed session to be released")
+	}
+}
@@ -0,0 +1,38 @@
+package shaping
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+// Executor 执行tc操作，按顺序执行，遇到失败立即返回
+type Executor interface {
+	Execute(ctx context.Context, ops []Operation) error
+}
+
+// DryRun 只记录并打印tc操作，不修改系统，用于测试和上线前确认
+type DryRun struct {
+	mutex sync.Mutex
+	ops   []Operation
+}
+
+func (d *DryRun) Execute(ctx context.Context, ops []Operation) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, op := range ops {
+		log.Infof("shaping dry run: %s", op)
+	}
+	d.ops = append(d.ops, ops...)
+	return nil
+}
+
+// Operations 返回记录的操作并清空
+func (d *DryRun) Operations() []Operation {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	ops := d.ops
+	d.ops = nil
+	return ops
+}
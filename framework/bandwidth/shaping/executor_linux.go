@@ -0,0 +1,98 @@
+//go:build linux
+// +build linux
+
+package shaping
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// NetlinkExecutor 通过netlink修改tc配置，需要CAP_NET_ADMIN
+type NetlinkExecutor struct{}
+
+func NewNetlinkExecutor() Executor {
+	return NetlinkExecutor{}
+}
+
+func (NetlinkExecutor) Execute(ctx context.Context, ops []Operation) error {
+	for _, op := range ops {
+		if err := execute(op); err != nil {
+			return fmt.Errorf("%s failure err:%w", op, err)
+		}
+	}
+	return nil
+}
+
+func execute(op Operation) error {
+	link, err := netlink.LinkByName(op.Device)
+	if err != nil {
+		return err
+	}
+	index := link.Attrs().Index
+	switch op.Object {
+	case ObjectQdisc:
+		attrs := netlink.QdiscAttrs{LinkIndex: index, Parent: op.Parent, Handle: op.Handle}
+		var qdisc netlink.Qdisc
+		switch op.Kind {
+		case "fq":
+			qdisc = netlink.NewFq(attrs)
+		default:
+			qdisc = netlink.NewHtb(attrs)
+		}
+		switch op.Action {
+		case ActionDelete:
+			return netlink.QdiscDel(qdisc)
+		case ActionAdd:
+			return netlink.QdiscAdd(qdisc)
+		default:
+			return netlink.QdiscReplace(qdisc)
+		}
+	case ObjectClass:
+		class := netlink.NewHtbClass(netlink.ClassAttrs{LinkIndex: index, Parent: op.Parent, Handle: op.Handle},
+			netlink.HtbClassAttrs{Rate: op.Rate, Ceil: op.Ceil, Buffer: op.Burst, Cbuffer: op.Burst})
+		switch op.Action {
+		case ActionDelete:
+			return netlink.ClassDel(class)
+		case ActionAdd:
+			return netlink.ClassAdd(class)
+		default:
+			return netlink.ClassReplace(class)
+		}
+	case ObjectFilter:
+		filter := &netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{LinkIndex: index, Parent: op.Parent, Priority: op.Prio, Protocol: unix.ETH_P_IP},
+			ClassId:     op.Handle,
+		}
+		if op.Action == ActionDelete {
+			return netlink.FilterDel(filter)
+		}
+		sel, err := u32Selector(op)
+		if err != nil {
+			return err
+		}
+		filter.Sel = sel
+		return netlink.FilterAdd(filter)
+	}
+	return fmt.Errorf("unknown tc object %s", op.Object)
+}
+
+// u32Selector 匹配源ip和源端口，和 "match ip src x/32 match ip sport x 0xffff" 一样假设ip头没有选项
+// 源ip必须是IPv4，不会生成匹配所有包的filter
+func u32Selector(op Operation) (*netlink.TcU32Sel, error) {
+	ip := net.ParseIP(op.SrcIP).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("filter src ip %q is not IPv4", op.SrcIP)
+	}
+	sel := &netlink.TcU32Sel{Flags: netlink.TC_U32_TERMINAL}
+	sel.Keys = append(sel.Keys, netlink.TcU32Key{Mask: 0xffffffff, Val: binary.BigEndian.Uint32(ip), Off: srcIPKeyOff})
+	if op.Port > 0 {
+		sel.Keys = append(sel.Keys, netlink.TcU32Key{Mask: 0xffff0000, Val: uint32(op.Port) << 16, Off: portKeyOff})
+	}
+	return sel, nil
+}
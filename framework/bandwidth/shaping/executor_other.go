@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package shaping
+
+import (
+	"context"
+	"errors"
+)
+
+// NetlinkExecutor 只支持linux
+type NetlinkExecutor struct{}
+
+func NewNetlinkExecutor() Executor {
+	return NetlinkExecutor{}
+}
+
+func (NetlinkExecutor) Execute(ctx context.Context, ops []Operation) error {
+	return errors.New("tc shaping is only supported on linux")
+}
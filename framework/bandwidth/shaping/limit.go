@@ -0,0 +1,114 @@
+package shaping
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Limit 会话串流端口的出方向限速，配置在会话Extra的shaping字段里：
+//
+//	{"shaping":{"rate":"20mbit","ceil":"30mbit","burst":"64kb","device":"eth0"}}
+//
+// ceil为空时等于rate，device为空时使用串流ip所在的网卡
+type Limit struct {
+	Rate   uint64 // bit/s
+	Ceil   uint64 // bit/s
+	Burst  uint32 // 字节
+	Device string
+}
+
+// ParseLimit 从会话的Extra里解析限速配置，没有配置时返回nil
+func ParseLimit(extra string) (*Limit, error) {
+	if len(extra) == 0 || !gjson.Valid(extra) {
+		return nil, nil
+	}
+	shaping := gjson.Get(extra, "shaping")
+	if !shaping.IsObject() {
+		return nil, nil
+	}
+	rate, err := ParseRate(shaping.Get("rate").String())
+	if err != nil {
+		return nil, err
+	}
+	if rate == 0 {
+		return nil, fmt.Errorf("shaping rate is required")
+	}
+	limit := &Limit{Rate: rate, Ceil: rate, Device: shaping.Get("device").String()}
+	if ceil := shaping.Get("ceil").String(); len(ceil) > 0 {
+		if limit.Ceil, err = ParseRate(ceil); err != nil {
+			return nil, err
+		}
+		if limit.Ceil < limit.Rate {
+			return nil, fmt.Errorf("shaping ceil %s less than rate", ceil)
+		}
+	}
+	if burst := shaping.Get("burst").String(); len(burst) > 0 {
+		size, err := ParseSize(burst)
+		if err != nil {
+			return nil, err
+		}
+		limit.Burst = uint32(size)
+	}
+	return limit, nil
+}
+
+var rateUnits = []struct {
+	suffix string
+	factor uint64
+}{
+	{"gbit", 1000 * 1000 * 1000}, {"mbit", 1000 * 1000}, {"kbit", 1000}, {"bit", 1},
+	{"gbps", 8 * 1000 * 1000 * 1000}, {"mbps", 8 * 1000 * 1000}, {"kbps", 8 * 1000}, {"bps", 8},
+}
+
+// ParseRate 解析tc格式的速率，单位是bit/kbit/mbit/gbit或者bps/kbps/mbps/gbps(字节每秒)，没有单位时是bit/s
+func ParseRate(rate string) (uint64, error) {
+	rate = strings.ToLower(strings.TrimSpace(rate))
+	if len(rate) == 0 {
+		return 0, nil
+	}
+	factor := uint64(1)
+	for _, unit := range rateUnits {
+		if strings.HasSuffix(rate, unit.suffix) {
+			rate, factor = strings.TrimSuffix(rate, unit.suffix), unit.factor
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(rate, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid rate %q", rate)
+	}
+	return uint64(value * float64(factor)), nil
+}
+
+// ParseSize 解析tc格式的大小，单位是b/kb/mb，没有单位时是字节
+func ParseSize(size string) (uint64, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+	factor := uint64(1)
+	for _, unit := range []struct {
+		suffix string
+		factor uint64
+	}{{"mb", 1024 * 1024}, {"kb", 1024}, {"b", 1}} {
+		if strings.HasSuffix(size, unit.suffix) {
+			size, factor = strings.TrimSuffix(size, unit.suffix), unit.factor
+			break
+		}
+	}
+	value, err := strconv.ParseUint(size, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return value * factor, nil
+}
+
+// formatRate 按tc的习惯输出速率
+func formatRate(rate uint64) string {
+	for _, unit := range rateUnits[:4] {
+		if rate >= unit.factor && rate%unit.factor == 0 {
+			return fmt.Sprintf("%d%s", rate/unit.factor, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dbit", rate)
+}
@@ -0,0 +1,47 @@
+package shaping
+
+import (
+	"testing"
+)
+
+func TestParseRate(t *testing.T) {
+	for rate, want := range map[string]uint64{
+		"":        0,
+		"20mbit":  20000000,
+		"1.5Gbit": 1500000000,
+		"512kbit": 512000,
+		"100":     100,
+		"2mbps":   16000000,
+	} {
+		got, err := ParseRate(rate)
+		if err != nil || got != want {
+			t.Fatalf("parse %q got %d err:%v, want %d", rate, got, err, want)
+		}
+	}
+	if _, err := ParseRate("fast"); err == nil {
+		t.Fatalf("expected invalid rate to fail")
+	}
+	if got := formatRate(20000000); got != "20mbit" {
+		t.Fatalf("unexpected format %s", got)
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit(`{"shaping":{"rate":"20mbit","ceil":"30mbit","burst":"64kb","device":"eth1"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *limit != (Limit{Rate: 20000000, Ceil: 30000000, Burst: 65536, Device: "eth1"}) {
+		t.Fatalf("unexpected limit %+v", limit)
+	}
+	for _, extra := range []string{"", "not json", `{"other":1}`} {
+		if limit, err = ParseLimit(extra); limit != nil || err != nil {
+			t.Fatalf("expected no limit for %q, got %+v err:%v", extra, limit, err)
+		}
+	}
+	for _, extra := range []string{`{"shaping":{}}`, `{"shaping":{"rate":"20mbit","ceil":"10mbit"}}`} {
+		if _, err = ParseLimit(extra); err == nil {
+			t.Fatalf("expected %s to fail", extra)
+		}
+	}
+}
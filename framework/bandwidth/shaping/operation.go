@@ -0,0 +1,95 @@
+package shaping
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Action string
+
+const (
+	ActionReplace Action = "replace"
+	ActionAdd     Action = "add"
+	ActionDelete  Action = "del"
+)
+
+type Object string
+
+const (
+	ObjectQdisc  Object = "qdisc"
+	ObjectClass  Object = "class"
+	ObjectFilter Object = "filter"
+)
+
+const (
+	rootMajor   = 1  // 根htb qdisc的handle 1:
+	portKeyOff  = 20 // 没有ip选项时传输层源端口的偏移
+	srcIPKeyOff = 12
+)
+
+// Operation 一个tc操作，对应一次netlink请求，String输出等价的tc命令
+type Operation struct {
+	Action Action
+	Object Object
+	Device string
+	Parent uint32
+	Handle uint32 // qdisc的handle，class的classid，filter指向的classid
+	Kind   string // htb/fq/u32
+	Rate   uint64
+	Ceil   uint64
+	Burst  uint32
+	Prio   uint16 // filter的优先级，一个会话的filter使用同一个优先级，删除时按优先级删除
+	SrcIP  string // filter匹配的源ip，添加filter时必须是IPv4
+	Port   uint16 // filter匹配的源端口，为0时不匹配端口
+}
+
+func (op Operation) String() string {
+	args := []string{"tc", string(op.Object), string(op.Action), "dev", op.Device}
+	if op.Parent == handleRoot {
+		args = append(args, "root")
+	} else {
+		args = append(args, "parent", formatHandle(op.Parent))
+	}
+	switch op.Object {
+	case ObjectQdisc:
+		args = append(args, "handle", formatHandle(op.Handle))
+		if op.Action != ActionDelete {
+			args = append(args, op.Kind)
+		}
+	case ObjectClass:
+		args = append(args, "classid", formatHandle(op.Handle))
+		if op.Action != ActionDelete {
+			args = append(args, op.Kind, "rate", formatRate(op.Rate), "ceil", formatRate(op.Ceil))
+			if op.Burst > 0 {
+				args = append(args, "burst", fmt.Sprintf("%db", op.Burst))
+			}
+		}
+	case ObjectFilter:
+		args = append(args, "protocol", "ip", "prio", fmt.Sprint(op.Prio))
+		if op.Action != ActionDelete {
+			args = append(args, op.Kind)
+			if len(op.SrcIP) > 0 {
+				args = append(args, "match", "ip", "src", op.SrcIP+"/32")
+			}
+			if op.Port > 0 {
+				args = append(args, "match", "ip", "sport", fmt.Sprint(op.Port), "0xffff")
+			}
+			args = append(args, "flowid", formatHandle(op.Handle))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+const handleRoot uint32 = 0xFFFFFFFF
+
+func makeHandle(major, minor uint16) uint32 {
+	return uint32(major)<<16 | uint32(minor)
+}
+
+func formatHandle(handle uint32) string {
+	major, minor := handle>>16, handle&0xffff
+	if minor == 0 {
+		return fmt.Sprintf("%x:", major)
+	}
+	return fmt.Sprintf("%x:%x", major, minor)
+}
@@ -2,11 +2,13 @@ package store
 
 import (
 	"accumulation/framework/bandwidth/collector"
+	"accumulation/framework/bandwidth/conf"
 	"accumulation/framework/bandwidth/model"
 	"context"
 	"fmt"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/robfig/cron/v3"
@@ -19,14 +21,22 @@ type BandwidthEngine interface {
 	Query(ctx context.Context, filter model.Filters, startTime, endTime int64) ([]*model.Bandwidth, error)
 }
 
+// Reconfigurable 支持运行时修改配置的engine
+type Reconfigurable interface {
+	SetCapacity(capacity int)
+	SetCollectInterval(interval time.Duration) error
+}
+
 type RejectPolicy interface {
 	Handle(traffics []*model.Bandwidth)
 }
 
 type fileEngine struct {
 	data       model.Bandwidths
-	collectors []*collector.BandwidthCollector
+	collectors []collector.Collector
 	cron       *cron.Cron
+	entryID    cron.EntryID
+	interval   time.Duration
 	mutex      *sync.RWMutex
 	capacity   int
 }
@@ -60,16 +70,51 @@ func (t *fileEngine) backendCollector() {
 		}
 	}()
 	t.cron = cron.New(cron.WithSeconds())
-	_, err := t.cron.AddFunc("*/5 * * * * *", func() {
+	var err error
+	t.entryID, err = t.cron.AddFunc(conf.CronSpec(t.interval), func() {
 		t.collector()
 	})
 	t.cron.Start()
 	if err != nil {
-		log.Errorf("c.AddFunc error|err=%v|stack=%v", err)
+		log.Errorf("c.AddFunc error|err=%v", err)
 	}
 
 }
 
+// SetCollectInterval 修改导出流量的周期，替换cron里的任务，已经导出的流量不受影响
+func (t *fileEngine) SetCollectInterval(interval time.Duration) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if interval <= 0 || interval == t.interval {
+		return nil
+	}
+	if t.cron != nil {
+		entryID, err := t.cron.AddFunc(conf.CronSpec(interval), func() {
+			t.collector()
+		})
+		if err != nil {
+			return err
+		}
+		t.cron.Remove(t.entryID)
+		t.entryID = entryID
+	}
+	t.interval = interval
+	return nil
+}
+
+// SetCapacity 修改最多保存的流量条数，容量变小时丢弃最早的数据
+func (t *fileEngine) SetCapacity(capacity int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	t.capacity = capacity
+	if reduce := len(t.data) - capacity; reduce > 0 {
+		t.data = t.data[reduce:]
+	}
+}
+
 func (t *fileEngine) collector() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
@@ -101,11 +146,17 @@ func (t *fileEngine) Add(bandwidths []*model.Bandwidth) {
 func (t *fileEngine) Store(ctx context.Context, bandwidths []*model.Bandwidth) error {
 	return fmt.Errorf("not implement")
 }
-func NewBandwidthEngine(collectors []*collector.BandwidthCollector, capacity int) BandwidthEngine {
-	if capacity > 0 {
-		defaultCapacity = capacity
+func NewBandwidthEngine(collectors []collector.Collector, capacity int, interval time.Duration) BandwidthEngine {
+	if capacity <= 0 {
+		capacity = defaultCapacity
 	}
-	return &fileEngine{collectors: collectors, mutex: &sync.RWMutex{}, capacity: defaultCapacity}
+	if interval <= 0 {
+		interval = defaultCollectInterval
+	}
+	return &fileEngine{collectors: collectors, mutex: &sync.RWMutex{}, capacity: capacity, interval: interval}
 }
 
-var defaultCapacity = 4000
+const (
+	defaultCapacity        = 4000
+	defaultCollectInterval = 5 * time.Second
+)
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"accumulation/framework/bandwidth/collector"
+	"accumulation/framework/bandwidth/model"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFileEngineReconfigure(t *testing.T) {
+	engine := NewBandwidthEngine(nil, 0, 0).(*fileEngine)
+	if engine.capacity != defaultCapacity || engine.interval != defaultCollectInterval {
+		t.Fatalf("unexpected defaults capacity %d interval %v", engine.capacity, engine.interval)
+	}
+	ctx := context.Background()
+	if err := engine.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Stop(ctx)
+	for i := 0; i < 10; i++ {
+		engine.Add([]*model.Bandwidth{{CollectTime: int64(i)}})
+	}
+	engine.SetCapacity(4)
+	if len(engine.data) != 4 || engine.data[0].CollectTime != 6 {
+		t.Fatalf("expected oldest data to be dropped, got %d items", len(engine.data))
+	}
+	entryID := engine.entryID
+	if err := engine.SetCollectInterval(2 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if engine.entryID == entryID || len(engine.cron.Entries()) != 1 {
+		t.Fatalf("expected collect entry to be replaced")
+	}
+}
+
+type fakeCollector struct {
+	bandwidths []*model.Bandwidth
+}
+
+func (c *fakeCollector) Start(ctx context.Context) error { return nil }
+
+func (c *fakeCollector) Stop(ctx context.Context) error { return nil }
+
+func (c *fakeCollector) ExportAndClean() []*model.Bandwidth {
+	bandwidths := c.bandwidths
+	c.bandwidths = nil
+	return bandwidths
+}
+
+func (c *fakeCollector) Stats() collector.Stats { return collector.Stats{Name: "fake"} }
+
+func TestFileEngineCollect(t *testing.T) {
+	collectors := []collector.Collector{
+		&fakeCollector{bandwidths: []*model.Bandwidth{{Ip: "10.0.0.1", Port: "9000", UpLen: 10, StartTime: 1, CollectTime: 2}}},
+		&fakeCollector{bandwidths: []*model.Bandwidth{{Ip: "10.0.0.2", Port: "9000", DownLen: 20, StartTime: 1, CollectTime: 2}}},
+	}
+	engine := NewBandwidthEngine(collectors, 0, 0).(*fileEngine)
+	engine.collector()
+	engine.collector()
+	bandwidths, err := engine.Query(context.Background(), func(*model.Bandwidth) bool { return true }, 0, 10)
+	if err != nil || len(bandwidths) != 2 {
+		t.Fatalf("expected bandwidths of all collectors, got %v err:%v", bandwidths, err)
+	}
+}
@@ -2,10 +2,14 @@ package store
 
 import (
 	"accumulation/framework/bandwidth/model"
+	"accumulation/pkg/log"
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
@@ -16,6 +20,30 @@ var (
 	cleanOffsetThreshold = 1024 * 1024
 )
 
+// 文件格式:
+//
+//	文件头: magic(4) | version(1) | reserved(3)
+//	记录:   magic(2) | version(1) | length(4) | crc32c(4) | data(length)
+//
+// 所有整数都是小端序，crc32c只对data部分计算
+const (
+	fileMagic      = "BWFS"
+	fileVersion    = 1
+	fileHeaderSize = 8
+
+	recordMagic      uint16 = 0xB5D7
+	recordVersion           = 1
+	recordHeaderSize        = 11
+	maxRecordSize           = 4 * 1024 * 1024
+)
+
+var (
+	ErrCorruptRecord = errors.New("corrupt record")
+	ErrBadFileHeader = errors.New("bad file header")
+
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
 type FileStore[T model.Serialization[T]] struct {
 	store  *os.File
 	path   string
@@ -42,7 +70,33 @@ func (fs *FileStore[T]) Open() (err error) {
 			return err
 		}
 	}
-	return nil
+	fs.offset = fileHeaderSize
+	err = checkFileHeader(fs.store)
+	if err == nil {
+		return fs.truncateTornTail()
+	}
+	// 空文件或者旧格式的文件，旧格式的数据已经没办法可靠解析，直接重建
+	if !errors.Is(err, io.EOF) {
+		log.Warnf(context.TODO(), "file %s has unknown format, rebuild it err:%v", fs.path, err)
+	}
+	return resetFile(fs.store)
+}
+
+// truncateTornTail 截断上次进程退出时写了一半的记录，否则后续追加的记录会跟在半条记录后面
+func (fs *FileStore[T]) truncateTornTail() error {
+	end, err := recordsEnd(fs.store)
+	if err != nil {
+		return err
+	}
+	size, err := fs.store.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if end >= size {
+		return nil
+	}
+	log.Warnf(context.TODO(), "file %s truncate %d bytes of torn tail", fs.path, size-end)
+	return fs.store.Truncate(end)
 }
 func (fs *FileStore[T]) Close() (err error) {
 	return fs.store.Close()
@@ -50,56 +104,95 @@ func (fs *FileStore[T]) Close() (err error) {
 func (fs *FileStore[T]) Store(ctx context.Context, reqs []T) error {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
+	if _, err := fs.store.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
 	return fs.doWrite(ctx, reqs)
 
 }
+
+// doWrite 先把所有请求编码，全部成功后才写入，避免一批数据只写了一半
 func (fs *FileStore[T]) doWrite(ctx context.Context, reqs []T) error {
-	writer := bufio.NewWriter(fs.store)
+	records := make([]*Record, 0, len(reqs))
 	for _, req := range reqs {
 		data, err := req.Encode()
 		if err != nil {
+			return fmt.Errorf("encode request failure err:%w", err)
+		}
+		if len(data) > maxRecordSize {
+			return fmt.Errorf("record size %d exceeds limit %d", len(data), maxRecordSize)
 		}
-		if err = NewRecord(data).write(writer); err != nil {
+		records = append(records, NewRecord(data))
+	}
+	writer := bufio.NewWriter(fs.store)
+	for _, record := range records {
+		if err := record.write(writer); err != nil {
 			return err
 		}
 	}
 	return writer.Flush()
 
 }
+
+// Load 从当前offset开始最多加载rows条记录
+// 遇到损坏的记录会跳到下一条合法记录继续读取，文件末尾写了一半的记录在Open时已经截断
 func (fs *FileStore[T]) Load(ctx context.Context, rows int) ([]T, error) {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
-	fs.store.Seek(fs.offset, io.SeekStart)
+	if _, err := fs.store.Seek(fs.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
 	reader := bufio.NewReader(fs.store)
 	var reqs []T
-	index := 0
-	var record *Record
-	var err error
-	dataLen := 0
-	for record, err = read(reader); index < rows && record != nil && err == nil; record, err = read(reader) {
-		var r T
-		req := r.Instance()
-		if err = req.Decode(record.data); err != nil {
-			if err = fs.store.Truncate(0); err != nil {
-				return nil, err
-			}
-			if _, err = fs.store.Seek(0, 0); err != nil {
-				return nil, err
+	for len(reqs) < rows {
+		record, err := read(reader)
+		if err == nil && record == nil {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err == nil {
+			var r T
+			req := r.Instance()
+			if err = req.Decode(record.data); err == nil {
+				reqs = append(reqs, req)
+				fs.offset += int64(record.DataLen())
+				continue
 			}
-			fs.offset = 0
-			return reqs, nil
+			err = fmt.Errorf("%w: decode failure err:%v", ErrCorruptRecord, err)
+		}
+		if !errors.Is(err, ErrCorruptRecord) {
+			return reqs, err
+		}
+		log.Warnf(ctx, "file %s skip corrupt record at offset %d err:%v", fs.path, fs.offset, err)
+		if reader, err = fs.resync(fs.offset + 1); err != nil {
+			return reqs, err
 		}
-		reqs = append(reqs, req)
-		dataLen += record.DataLen()
-		index++
 	}
-	fs.offset += int64(dataLen)
 	if int64(cleanOffsetThreshold) < fs.offset {
-		fs.Truncate(ctx, nil, nil)
+		if err := fs.Truncate(ctx, nil, nil); err != nil {
+			log.Warnf(ctx, "file %s truncate failure err:%v", fs.path, err)
+		}
 	}
 	return reqs, nil
 }
 
+// resync 从from开始查找下一个记录头，offset移动到该位置
+func (fs *FileStore[T]) resync(from int64) (*bufio.Reader, error) {
+	if _, err := fs.store.Seek(from, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(fs.store)
+	skipped, err := scanRecordMagic(reader)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	fs.offset = from + int64(skipped)
+	return reader, nil
+}
+
+// Truncate 丢弃offset之前已经读取的数据，并在剩余数据的前后分别写入before和after
 func (fs *FileStore[T]) Truncate(ctx context.Context, before, after []T) error {
 	fileInfo, err := fs.store.Stat()
 	if err != nil {
@@ -107,17 +200,17 @@ func (fs *FileStore[T]) Truncate(ctx context.Context, before, after []T) error {
 	}
 	fileSize := fileInfo.Size()
 	keepLength := fileSize - fs.offset
+	if keepLength < 0 {
+		keepLength = 0
+	}
 	// 读取需要保留的内容
 	data := make([]byte, keepLength)
 	_, err = fs.store.ReadAt(data, fs.offset)
-	if err != nil {
+	if err != nil && err != io.EOF {
 		return err
 	}
-	// 截断文件到保留内容的长度
-	if err = fs.store.Truncate(0); err != nil {
-		return err
-	}
-	if _, err = fs.store.Seek(0, 0); err != nil {
+	// 截断文件并重写文件头
+	if err = resetFile(fs.store); err != nil {
 		return err
 	}
 	if err = fs.doWrite(ctx, before); err != nil {
@@ -129,29 +222,68 @@ func (fs *FileStore[T]) Truncate(ctx context.Context, before, after []T) error {
 	if err = fs.doWrite(ctx, after); err != nil {
 		return err
 	}
-	fs.offset = 0
+	fs.offset = fileHeaderSize
 	return nil
 }
 
+func checkFileHeader(f *os.File) error {
+	head := make([]byte, fileHeaderSize)
+	if _, err := f.ReadAt(head, 0); err != nil {
+		if err == io.EOF && len(bytes.Trim(head, "\x00")) > 0 {
+			return fmt.Errorf("%w: short header", ErrBadFileHeader)
+		}
+		return err
+	}
+	if string(head[:len(fileMagic)]) != fileMagic {
+		return fmt.Errorf("%w: magic %q", ErrBadFileHeader, head[:len(fileMagic)])
+	}
+	if head[len(fileMagic)] != fileVersion {
+		return fmt.Errorf("%w: version %d", ErrBadFileHeader, head[len(fileMagic)])
+	}
+	return nil
+}
+
+func resetFile(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	head := make([]byte, fileHeaderSize)
+	copy(head, fileMagic)
+	head[len(fileMagic)] = fileVersion
+	_, err := f.Write(head)
+	return err
+}
+
 type Record struct {
-	dLen int16
-	data []byte
+	version byte
+	dLen    uint32
+	crc     uint32
+	data    []byte
 }
 
+// DataLen 记录在文件中占用的字节数
 func (r *Record) DataLen() int {
-	return len(r.data) + 2
+	return len(r.data) + recordHeaderSize
 }
 func NewRecord(data []byte) *Record {
 	return &Record{
-		dLen: int16(len(data)),
-		data: data,
+		version: recordVersion,
+		dLen:    uint32(len(data)),
+		crc:     crc32.Checksum(data, crc32cTable),
+		data:    data,
 	}
 }
 
 func (r *Record) write(w *bufio.Writer) error {
-	dLen := make([]byte, 2)
-	dLen[0] = byte(r.dLen)
-	if _, err := w.Write(dLen); err != nil {
+	head := make([]byte, recordHeaderSize)
+	binary.LittleEndian.PutUint16(head[0:2], recordMagic)
+	head[2] = r.version
+	binary.LittleEndian.PutUint32(head[3:7], r.dLen)
+	binary.LittleEndian.PutUint32(head[7:11], r.crc)
+	if _, err := w.Write(head); err != nil {
 		return err
 	}
 	if _, err := w.Write(r.data); err != nil {
@@ -159,49 +291,76 @@ func (r *Record) write(w *bufio.Writer) error {
 	}
 	return nil
 }
+
+// read 读取一条记录，正常读到文件末尾时返回nil,nil
+// 记录只写了一半返回io.ErrUnexpectedEOF，记录内容不合法返回ErrCorruptRecord
 func read(r *bufio.Reader) (*Record, error) {
-	head, err := readFixedLength(r, 2)
+	head, err := readFixedLength(r, recordHeaderSize)
 	if err == io.EOF {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	dLen := int16(binary.LittleEndian.Uint16(head))
+	if magic := binary.LittleEndian.Uint16(head[0:2]); magic != recordMagic {
+		return nil, fmt.Errorf("%w: magic %#x", ErrCorruptRecord, magic)
+	}
+	if head[2] != recordVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrCorruptRecord, head[2])
+	}
+	dLen := binary.LittleEndian.Uint32(head[3:7])
+	if dLen > maxRecordSize {
+		return nil, fmt.Errorf("%w: length %d", ErrCorruptRecord, dLen)
+	}
+	crc := binary.LittleEndian.Uint32(head[7:11])
 
 	data, err := readFixedLength(r, int(dLen))
 	if err == io.EOF {
-		return nil, nil
+		return nil, io.ErrUnexpectedEOF
 	}
 	if err != nil {
 		return nil, err
 	}
+	if sum := crc32.Checksum(data, crc32cTable); sum != crc {
+		return nil, fmt.Errorf("%w: crc %#x want %#x", ErrCorruptRecord, sum, crc)
+	}
 	return &Record{
-		data: data,
-		dLen: dLen,
+		version: head[2],
+		dLen:    dLen,
+		crc:     crc,
+		data:    data,
 	}, nil
 
 }
 
-// 每次buffer都是固定长度，这里就有可能导致一个结构体对象不完全在当次的buff里面，需要二次buff进行拼接
-// 这里有个前提是一个结构体的大小小于4096，如果对象大于这个值需要修改默认大小或多次读入
+// readFixedLength 读取n个字节，bufio一次Read可能只返回buffer里剩余的部分，所以用io.ReadFull读满
+// 一个字节都没有读到返回io.EOF，读到一部分返回io.ErrUnexpectedEOF
 func readFixedLength(r *bufio.Reader, n int) ([]byte, error) {
 	data := make([]byte, n)
-	remaining := 0
-	if remaining = r.Buffered(); remaining < n {
-		data = make([]byte, remaining)
-	}
-	_, err := r.Read(data)
-	if err != nil {
+	if _, err := io.ReadFull(r, data); err != nil {
 		return nil, err
 	}
-	if remaining < n {
-		remainData := make([]byte, n-remaining)
-		_, err = r.Read(remainData)
+	return data, nil
+}
+
+// scanRecordMagic 跳过字节直到下一个可能的记录头，返回跳过的字节数
+func scanRecordMagic(r *bufio.Reader) (int, error) {
+	magic := make([]byte, 2)
+	binary.LittleEndian.PutUint16(magic, recordMagic)
+	skipped := 0
+	for {
+		b, err := r.Peek(len(magic))
 		if err != nil {
-			return nil, err
+			// 剩余不足一个magic，全部跳过
+			n, _ := r.Discard(len(b))
+			return skipped + n, err
+		}
+		if bytes.Equal(b, magic) {
+			return skipped, nil
 		}
-		data = append(data, remainData...)
+		if _, err = r.Discard(1); err != nil {
+			return skipped, err
+		}
+		skipped++
 	}
-	return data, nil
 }
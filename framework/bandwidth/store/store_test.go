@@ -0,0 +1,267 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testReq struct {
+	Value string `json:"value"`
+}
+
+func (r *testReq) Encode() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *testReq) Decode(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+func (r *testReq) Instance() *testReq {
+	return &testReq{}
+}
+
+func newTestStore(t *testing.T) *FileStore[*testReq] {
+	fs := NewFileStore[*testReq](filepath.Join(t.TempDir(), "report", "store.dat"))
+	if err := fs.Open(); err != nil {
+		t.Fatalf("open failure err:%v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func encodeRecords(t testing.TB, values ...string) []byte {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for _, v := range values {
+		if err := NewRecord([]byte(v)).write(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+func TestFileStoreStoreAndLoad(t *testing.T) {
+	fs := newTestStore(t)
+	ctx := context.Background()
+	var reqs []*testReq
+	// 大于256字节的记录，旧格式只写了长度的低字节
+	for _, v := range []string{"a", string(bytes.Repeat([]byte("b"), 300)), "c"} {
+		reqs = append(reqs, &testReq{Value: v})
+	}
+	if err := fs.Store(ctx, reqs); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.Load(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Value != "a" || got[1].Value != reqs[1].Value {
+		t.Fatalf("unexpected records %v", got)
+	}
+	got, err = fs.Load(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Value != "c" {
+		t.Fatalf("unexpected records %v", got)
+	}
+}
+
+func TestFileStoreSkipCorruptRecord(t *testing.T) {
+	fs := newTestStore(t)
+	ctx := context.Background()
+	if err := fs.Store(ctx, []*testReq{{Value: "first"}, {Value: "second"}, {Value: "third"}}); err != nil {
+		t.Fatal(err)
+	}
+	// 破坏第二条记录的数据，crc校验会失败
+	first := NewRecord([]byte(`{"value":"first"}`)).DataLen()
+	if _, err := fs.store.WriteAt([]byte("X"), int64(fileHeaderSize+first+recordHeaderSize+2)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.Load(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Value != "first" || got[1].Value != "third" {
+		t.Fatalf("unexpected records %v", got)
+	}
+}
+
+func TestFileStoreTornTail(t *testing.T) {
+	fs := newTestStore(t)
+	ctx := context.Background()
+	if err := fs.Store(ctx, []*testReq{{Value: "first"}}); err != nil {
+		t.Fatal(err)
+	}
+	torn := encodeRecords(t, `{"value":"torn"}`)
+	if _, err := fs.store.Write(torn[:len(torn)-3]); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.Load(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("unexpected records %v", got)
+	}
+	if err = fs.Store(ctx, []*testReq{{Value: "next"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = fs.Load(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Value != "next" {
+		t.Fatalf("unexpected records %v", got)
+	}
+}
+
+func TestFileStoreTruncateTornTailOnOpen(t *testing.T) {
+	fs := newTestStore(t)
+	ctx := context.Background()
+	if err := fs.Store(ctx, []*testReq{{Value: "first"}}); err != nil {
+		t.Fatal(err)
+	}
+	torn := encodeRecords(t, `{"value":"torn"}`)
+	if _, err := fs.store.Write(torn[:len(torn)-3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fs = NewFileStore[*testReq](fs.path)
+	if err := fs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if err := fs.Store(ctx, []*testReq{{Value: "next"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.Load(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Value != "first" || got[1].Value != "next" {
+		t.Fatalf("unexpected records %v", got)
+	}
+}
+
+func TestFileStoreRebuildUnknownFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.dat")
+	if err := os.WriteFile(path, []byte{0x05, 0x00, 'h', 'e', 'l', 'l', 'o', '!', '!'}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewFileStore[*testReq](path)
+	if err := fs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	got, err := fs.Load(context.Background(), 10)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("unexpected records %v err:%v", got, err)
+	}
+	if err = checkFileHeader(fs.store); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStoreTruncate(t *testing.T) {
+	fs := newTestStore(t)
+	ctx := context.Background()
+	if err := fs.Store(ctx, []*testReq{{Value: "1"}, {Value: "2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Load(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Truncate(ctx, []*testReq{{Value: "0"}}, []*testReq{{Value: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.Load(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, r := range got {
+		values = append(values, r.Value)
+	}
+	if len(values) != 3 || values[0] != "0" || values[1] != "2" || values[2] != "3" {
+		t.Fatalf("unexpected records %v", values)
+	}
+}
+
+func TestRead(t *testing.T) {
+	data := encodeRecords(t, "hello", "")
+	r := bufio.NewReader(bytes.NewReader(data))
+	for _, want := range []string{"hello", ""} {
+		record, err := read(r)
+		if err != nil || record == nil || string(record.data) != want {
+			t.Fatalf("read record %v err:%v", record, err)
+		}
+	}
+	if record, err := read(r); record != nil || err != nil {
+		t.Fatalf("expected eof got %v err:%v", record, err)
+	}
+
+	bad := append([]byte(nil), data...)
+	bad[recordHeaderSize] ^= 0xff
+	if _, err := read(bufio.NewReader(bytes.NewReader(bad))); !errors.Is(err, ErrCorruptRecord) {
+		t.Fatalf("expected corrupt record err:%v", err)
+	}
+	if _, err := read(bufio.NewReader(bytes.NewReader(data[:recordHeaderSize+2]))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected unexpected eof err:%v", err)
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	f.Add(encodeRecords(f, "hello"))
+	f.Add(encodeRecords(f, "", "world"))
+	f.Add([]byte{0xd7, 0xb5, 0x01, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bufio.NewReader(bytes.NewReader(data))
+		for {
+			record, err := read(r)
+			if err != nil || record == nil {
+				return
+			}
+			if NewRecord(record.data).crc != record.crc {
+				t.Fatalf("record returned with bad crc")
+			}
+		}
+	})
+}
+
+func FuzzReadFixedLength(f *testing.F) {
+	f.Add([]byte("hello world"), 5)
+	f.Add([]byte{}, 0)
+	f.Add(bytes.Repeat([]byte{1}, 5000), 4097)
+	f.Fuzz(func(t *testing.T, data []byte, n int) {
+		if n < 0 || n > 1<<16 {
+			return
+		}
+		got, err := readFixedLength(bufio.NewReader(bytes.NewReader(data)), n)
+		switch {
+		case n <= len(data):
+			if err != nil || !bytes.Equal(got, data[:n]) {
+				t.Fatalf("read %d bytes got %d err:%v", n, len(got), err)
+			}
+		case len(data) == 0:
+			if err != io.EOF {
+				t.Fatalf("expected eof err:%v", err)
+			}
+		default:
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("expected unexpected eof err:%v", err)
+			}
+		}
+	})
+}
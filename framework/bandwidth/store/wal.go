@@ -0,0 +1,514 @@
+package store
+
+import (
+	"accumulation/framework/bandwidth/model"
+	"accumulation/pkg/log"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy 写入后的刷盘策略
+type FsyncPolicy int
+
+const (
+	FsyncNone       FsyncPolicy = iota // 交给操作系统刷盘
+	FsyncEveryWrite                    // 每次写入都刷盘
+	FsyncInterval                      // 定时刷盘
+)
+
+func ParseFsyncPolicy(policy string) (FsyncPolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", "none":
+		return FsyncNone, nil
+	case "always", "every_write":
+		return FsyncEveryWrite, nil
+	case "interval":
+		return FsyncInterval, nil
+	}
+	return FsyncNone, fmt.Errorf("unknown fsync policy %s", policy)
+}
+
+const (
+	segmentSuffix        = ".wal"
+	checkpointName       = "checkpoint"
+	checkpointSize       = 20
+	defaultSegmentSize   = 4 * 1024 * 1024
+	defaultFsyncInterval = time.Second
+	firstSegment         = 1
+)
+
+type WALOptions struct {
+	Dir           string
+	SegmentSize   int64
+	FsyncPolicy   FsyncPolicy
+	FsyncInterval time.Duration
+}
+
+// WALPosition 日志中的位置，Offset是段文件内的偏移
+type WALPosition struct {
+	Segment uint64
+	Offset  int64
+}
+
+func (p WALPosition) Before(other WALPosition) bool {
+	if p.Segment != other.Segment {
+		return p.Segment < other.Segment
+	}
+	return p.Offset < other.Offset
+}
+
+// WALEntry 读取到的一条数据，Next是该条数据之后的位置，数据处理完成后用它来Commit
+type WALEntry[T any] struct {
+	Value T
+	Next  WALPosition
+}
+
+// WAL 分段的预写日志
+// 数据追加写到当前段文件，段文件超过SegmentSize就滚动出新段；已经处理完成的位置记录在checkpoint文件里，
+// 重启后从checkpoint继续读取；checkpoint之前的段文件会被删除，不需要重写文件
+type WAL[T model.Serialization[T]] struct {
+	opts      WALOptions
+	mutex     *sync.Mutex
+	segments  []uint64
+	active    *os.File
+	activeID  uint64
+	size      int64
+	end       int64 // 当前段最后一条完整记录的结束位置，段末尾有写了一半的记录时小于size
+	dirty     bool
+	reader    *os.File
+	readPos   WALPosition
+	committed WALPosition
+	done      chan struct{}
+}
+
+func NewWAL[T model.Serialization[T]](opts WALOptions) *WAL[T] {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.FsyncInterval <= 0 {
+		opts.FsyncInterval = defaultFsyncInterval
+	}
+	return &WAL[T]{opts: opts, mutex: &sync.Mutex{}}
+}
+
+func (w *WAL[T]) Open() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := os.MkdirAll(w.opts.Dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory:%v", err)
+	}
+	segments, err := listSegments(w.opts.Dir)
+	if err != nil {
+		return err
+	}
+	w.segments = segments
+	if len(w.segments) == 0 {
+		w.segments = []uint64{firstSegment}
+	}
+	committed, err := readCheckpoint(w.checkpointPath())
+	if err != nil {
+		log.Warnf(context.TODO(), "wal %s read checkpoint failure, read from the first segment err:%v", w.opts.Dir, err)
+	}
+	if err != nil || committed.Segment < w.segments[0] || committed.Segment > w.segments[len(w.segments)-1] {
+		committed = WALPosition{Segment: w.segments[0], Offset: fileHeaderSize}
+	}
+	w.committed = committed
+	w.readPos = committed
+	if err = w.openActive(w.segments[len(w.segments)-1]); err != nil {
+		return err
+	}
+	if w.opts.FsyncPolicy == FsyncInterval {
+		w.done = make(chan struct{})
+		go w.loopSync(w.done)
+	}
+	return nil
+}
+
+func (w *WAL[T]) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.done != nil {
+		close(w.done)
+		w.done = nil
+	}
+	w.closeReader()
+	if w.active == nil {
+		return nil
+	}
+	err := w.active.Sync()
+	if cerr := w.active.Close(); err == nil {
+		err = cerr
+	}
+	w.active = nil
+	return err
+}
+
+// Append 追加数据，先全部编码，编码失败不会写入任何数据
+func (w *WAL[T]) Append(ctx context.Context, reqs []T) error {
+	if len(reqs) == 0 {
+		return nil
+	}
+	records := make([]*Record, 0, len(reqs))
+	for _, req := range reqs {
+		data, err := req.Encode()
+		if err != nil {
+			return fmt.Errorf("encode request failure err:%w", err)
+		}
+		if len(data) > maxRecordSize {
+			return fmt.Errorf("record size %d exceeds limit %d", len(data), maxRecordSize)
+		}
+		records = append(records, NewRecord(data))
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.active == nil {
+		return fmt.Errorf("wal %s is closed", w.opts.Dir)
+	}
+	if w.size >= w.opts.SegmentSize {
+		if err := w.roll(); err != nil {
+			return err
+		}
+	}
+	writer := bufio.NewWriter(w.active)
+	for _, record := range records {
+		if err := record.write(writer); err != nil {
+			return err
+		}
+		w.size += int64(record.DataLen())
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	w.end = w.size
+	w.dirty = true
+	if w.opts.FsyncPolicy == FsyncEveryWrite {
+		return w.sync()
+	}
+	return nil
+}
+
+// Load 从读取位置开始最多读取rows条数据，读取位置随之前移，但不会改变checkpoint
+func (w *WAL[T]) Load(ctx context.Context, rows int) ([]WALEntry[T], error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var entries []WALEntry[T]
+	for len(entries) < rows {
+		if w.reader == nil {
+			reader, err := os.Open(w.segmentPath(w.readPos.Segment))
+			if err != nil {
+				return entries, err
+			}
+			w.reader = reader
+		}
+		loaded, err := w.loadSegment(ctx, rows-len(entries))
+		entries = append(entries, loaded...)
+		if err != nil {
+			return entries, err
+		}
+		if len(entries) >= rows || w.readPos.Segment >= w.activeID {
+			break
+		}
+		// 当前段已经读完，切换到下一个段
+		w.closeReader()
+		w.readPos = WALPosition{Segment: w.nextSegment(w.readPos.Segment), Offset: fileHeaderSize}
+	}
+	return entries, nil
+}
+
+func (w *WAL[T]) loadSegment(ctx context.Context, rows int) ([]WALEntry[T], error) {
+	if _, err := w.reader.Seek(w.readPos.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(w.reader)
+	var entries []WALEntry[T]
+	for len(entries) < rows {
+		record, err := read(reader)
+		if err == nil && record == nil {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// 当前段末尾写了一半的记录，非当前段的话直接跳过
+			break
+		}
+		if err == nil {
+			var r T
+			req := r.Instance()
+			if err = req.Decode(record.data); err == nil {
+				w.readPos.Offset += int64(record.DataLen())
+				entries = append(entries, WALEntry[T]{Value: req, Next: w.readPos})
+				continue
+			}
+			err = fmt.Errorf("%w: decode failure err:%v", ErrCorruptRecord, err)
+		}
+		if !errors.Is(err, ErrCorruptRecord) {
+			return entries, err
+		}
+		log.Warnf(ctx, "wal %s skip corrupt record at segment %d offset %d err:%v",
+			w.opts.Dir, w.readPos.Segment, w.readPos.Offset, err)
+		from := w.readPos.Offset + 1
+		if _, err = w.reader.Seek(from, io.SeekStart); err != nil {
+			return entries, err
+		}
+		reader = bufio.NewReader(w.reader)
+		skipped, err := scanRecordMagic(reader)
+		if err != nil && err != io.EOF {
+			return entries, err
+		}
+		w.readPos.Offset = from + int64(skipped)
+	}
+	return entries, nil
+}
+
+// Commit 记录pos之前的数据已经处理完成，并删除不再需要的段文件
+func (w *WAL[T]) Commit(pos WALPosition) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.committed.Before(pos) {
+		return nil
+	}
+	if err := writeCheckpoint(w.checkpointPath(), pos, w.opts.FsyncPolicy != FsyncNone); err != nil {
+		return err
+	}
+	w.committed = pos
+	var kept []uint64
+	for _, id := range w.segments {
+		if id >= pos.Segment {
+			kept = append(kept, id)
+			continue
+		}
+		if err := os.Remove(w.segmentPath(id)); err != nil && !os.IsNotExist(err) {
+			log.Warnf(context.TODO(), "wal %s remove segment %d failure err:%v", w.opts.Dir, id, err)
+			kept = append(kept, id)
+		}
+	}
+	w.segments = kept
+	return nil
+}
+
+// Empty 是否还有没读取的数据，当前段末尾写了一半的记录不算
+func (w *WAL[T]) Empty() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.readPos.Segment == w.activeID && w.readPos.Offset >= w.end
+}
+
+// Size 所有段文件的总大小
+func (w *WAL[T]) Size() int64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var total int64
+	for _, id := range w.segments {
+		if id == w.activeID {
+			total += w.size
+			continue
+		}
+		if info, err := os.Stat(w.segmentPath(id)); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+func (w *WAL[T]) roll() error {
+	if err := w.active.Sync(); err != nil {
+		return err
+	}
+	if err := w.active.Close(); err != nil {
+		return err
+	}
+	w.active = nil
+	id := w.activeID + 1
+	w.segments = append(w.segments, id)
+	return w.openActive(id)
+}
+
+func (w *WAL[T]) openActive(id uint64) error {
+	f, err := os.OpenFile(w.segmentPath(id), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err = checkFileHeader(f); err != nil {
+		if !errors.Is(err, io.EOF) {
+			log.Warnf(context.TODO(), "wal segment %s has unknown format, rebuild it err:%v", f.Name(), err)
+		}
+		if err = resetFile(f); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	end, err := recordsEnd(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if end < size {
+		log.Warnf(context.TODO(), "wal segment %s has %d bytes of torn tail", f.Name(), size-end)
+	}
+	w.active = f
+	w.activeID = id
+	w.size = size
+	w.end = end
+	return nil
+}
+
+// recordsEnd 段文件里最后一条完整记录的结束位置，中间损坏的记录跳过
+func recordsEnd(f *os.File) (int64, error) {
+	offset, end := int64(fileHeaderSize), int64(fileHeaderSize)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(f)
+	for {
+		record, err := read(reader)
+		if (err == nil && record == nil) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return end, nil
+		}
+		if err == nil {
+			offset += int64(record.DataLen())
+			end = offset
+			continue
+		}
+		if !errors.Is(err, ErrCorruptRecord) {
+			return 0, err
+		}
+		if _, err = f.Seek(offset+1, io.SeekStart); err != nil {
+			return 0, err
+		}
+		reader = bufio.NewReader(f)
+		skipped, err := scanRecordMagic(reader)
+		if err == io.EOF {
+			return end, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		offset += 1 + int64(skipped)
+	}
+}
+
+func (w *WAL[T]) nextSegment(id uint64) uint64 {
+	for _, seg := range w.segments {
+		if seg > id {
+			return seg
+		}
+	}
+	return w.activeID
+}
+
+func (w *WAL[T]) closeReader() {
+	if w.reader != nil {
+		w.reader.Close()
+		w.reader = nil
+	}
+}
+
+func (w *WAL[T]) sync() error {
+	if !w.dirty || w.active == nil {
+		return nil
+	}
+	w.dirty = false
+	return w.active.Sync()
+}
+
+func (w *WAL[T]) loopSync(done chan struct{}) {
+	ticker := time.NewTicker(w.opts.FsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			w.mutex.Lock()
+			if err := w.sync(); err != nil {
+				log.Warnf(context.TODO(), "wal %s sync failure err:%v", w.opts.Dir, err)
+			}
+			w.mutex.Unlock()
+		}
+	}
+}
+
+func (w *WAL[T]) segmentPath(id uint64) string {
+	return filepath.Join(w.opts.Dir, fmt.Sprintf("%020d"+segmentSuffix, id))
+}
+
+func (w *WAL[T]) checkpointPath() string {
+	return filepath.Join(w.opts.Dir, checkpointName)
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, id)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// checkpoint文件: segment(8) | offset(8) | crc32c(4)
+func readCheckpoint(path string) (WALPosition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return WALPosition{}, err
+	}
+	if len(data) != checkpointSize {
+		return WALPosition{}, fmt.Errorf("checkpoint size %d", len(data))
+	}
+	if crc := crc32.Checksum(data[:16], crc32cTable); crc != binary.LittleEndian.Uint32(data[16:]) {
+		return WALPosition{}, fmt.Errorf("checkpoint crc mismatch")
+	}
+	return WALPosition{
+		Segment: binary.LittleEndian.Uint64(data[0:8]),
+		Offset:  int64(binary.LittleEndian.Uint64(data[8:16])),
+	}, nil
+}
+
+// writeCheckpoint 先写临时文件再rename，保证checkpoint不会只写一半
+func writeCheckpoint(path string, pos WALPosition, fsync bool) error {
+	data := make([]byte, checkpointSize)
+	binary.LittleEndian.PutUint64(data[0:8], pos.Segment)
+	binary.LittleEndian.PutUint64(data[8:16], uint64(pos.Offset))
+	binary.LittleEndian.PutUint32(data[16:], crc32.Checksum(data[:16], crc32cTable))
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil && fsync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestWAL(t *testing.T, opts WALOptions) *WAL[*testReq] {
+	w := NewWAL[*testReq](opts)
+	if err := w.Open(); err != nil {
+		t.Fatalf("open wal failure err:%v", err)
+	}
+	return w
+}
+
+func appendValues(t *testing.T, w *WAL[*testReq], values ...string) {
+	for _, v := range values {
+		if err := w.Append(context.Background(), []*testReq{{Value: v}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func loadValues(t *testing.T, w *WAL[*testReq], rows int) ([]string, WALPosition) {
+	entries, err := w.Load(context.Background(), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	var last WALPosition
+	for _, entry := range entries {
+		values = append(values, entry.Value.Value)
+		last = entry.Next
+	}
+	return values, last
+}
+
+func TestWALRollAndCommit(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, WALOptions{Dir: dir, SegmentSize: 64})
+	defer w.Close()
+	var values []string
+	for i := 0; i < 10; i++ {
+		values = append(values, fmt.Sprintf("value-%d", i))
+	}
+	appendValues(t, w, values...)
+	segments, _ := listSegments(dir)
+	if len(segments) < 3 {
+		t.Fatalf("expected segments to roll, got %v", segments)
+	}
+	got, pos := loadValues(t, w, 20)
+	if fmt.Sprint(got) != fmt.Sprint(values) {
+		t.Fatalf("unexpected values %v", got)
+	}
+	if !w.Empty() {
+		t.Fatalf("expected wal to be empty")
+	}
+	if err := w.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	segments, _ = listSegments(dir)
+	if len(segments) != 1 || segments[0] != pos.Segment {
+		t.Fatalf("expected acknowledged segments to be deleted, got %v", segments)
+	}
+}
+
+func TestWALRecoverFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, WALOptions{Dir: dir, SegmentSize: 64, FsyncPolicy: FsyncEveryWrite})
+	appendValues(t, w, "a", "b", "c", "d", "e")
+	got, pos := loadValues(t, w, 2)
+	if fmt.Sprint(got) != "[a b]" {
+		t.Fatalf("unexpected values %v", got)
+	}
+	if err := w.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	// 读取了但没有commit的数据，重启后需要重新读取
+	if got, _ = loadValues(t, w, 1); fmt.Sprint(got) != "[c]" {
+		t.Fatalf("unexpected values %v", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openTestWAL(t, WALOptions{Dir: dir, SegmentSize: 64})
+	defer w.Close()
+	appendValues(t, w, "f")
+	if got, _ = loadValues(t, w, 10); fmt.Sprint(got) != "[c d e f]" {
+		t.Fatalf("unexpected values %v", got)
+	}
+}
+
+func TestWALBadCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, WALOptions{Dir: dir})
+	appendValues(t, w, "a", "b")
+	_, pos := loadValues(t, w, 1)
+	if err := w.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := os.WriteFile(w.checkpointPath(), []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w = openTestWAL(t, WALOptions{Dir: dir})
+	defer w.Close()
+	if got, _ := loadValues(t, w, 10); fmt.Sprint(got) != "[a b]" {
+		t.Fatalf("unexpected values %v", got)
+	}
+}
+
+func TestWALFsyncInterval(t *testing.T) {
+	w := openTestWAL(t, WALOptions{Dir: t.TempDir(), FsyncPolicy: FsyncInterval, FsyncInterval: 10 * time.Millisecond})
+	appendValues(t, w, "a")
+	time.Sleep(50 * time.Millisecond)
+	w.mutex.Lock()
+	dirty := w.dirty
+	w.mutex.Unlock()
+	if dirty {
+		t.Fatalf("expected wal to be synced")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append(context.Background(), []*testReq{{Value: "b"}}); err == nil {
+		t.Fatalf("expected append to closed wal to fail")
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, WALOptions{Dir: dir})
+	appendValues(t, w, "first")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟崩溃时当前段末尾只写了一半的记录
+	f, err := os.OpenFile(w.segmentPath(firstSegment), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := encodeRecords(t, `{"value":"torn"}`)
+	if _, err = f.Write(torn[:len(torn)-3]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	w = openTestWAL(t, WALOptions{Dir: dir})
+	defer w.Close()
+	if w.Empty() {
+		t.Fatal("expected wal not to be empty")
+	}
+	if values, _ := loadValues(t, w, 10); fmt.Sprint(values) != "[first]" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if !w.Empty() {
+		t.Fatal("expected wal to be empty after the torn tail")
+	}
+	appendValues(t, w, "next")
+	if w.Empty() {
+		t.Fatal("expected appended record to be readable")
+	}
+	if values, _ := loadValues(t, w, 10); fmt.Sprint(values) != "[next]" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if !w.Empty() {
+		t.Fatal("expected wal to be empty")
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for policy, want := range map[string]FsyncPolicy{"": FsyncNone, "always": FsyncEveryWrite, "Interval": FsyncInterval} {
+		got, err := ParseFsyncPolicy(policy)
+		if err != nil || got != want {
+			t.Fatalf("parse %q got %v err:%v", policy, got, err)
+		}
+	}
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Fatalf("expected unknown policy to fail")
+	}
+}
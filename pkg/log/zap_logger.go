@@ -18,8 +18,8 @@ import (
 
 // DefaultMessageKey default message key.
 var DefaultMessageKey = "M"
-var sprint func(...interface{}) string
-var sprintf func(format string, a ...interface{}) string
+var sprint = fmt.Sprint
+var sprintf = fmt.Sprintf
 
 var (
 	logger log.Logger
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"accumulation/pkg/log"
+	"accumulation/pkg/nnet"
+	"bufio"
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// AccessLogEntry 一个请求的访问日志
+type AccessLogEntry struct {
+	Method   string
+	Path     string
+	Route    string // 匹配的钩子路径模板，没有时和Path相同
+	Upstream string // 上游名，没有上游时为空
+	Backend  string // 实际转发的后端地址，命中缓存时为空
+	Status   int
+	BytesIn  int64
+	BytesOut int64
+	Latency  time.Duration
+	ClientIP string
+	TraceID  string
+	Body     string // 请求体，SetBodyLog设置了limit时才有，敏感字段已经隐藏
+	Err      error
+}
+
+// AccessLogger 输出访问日志，默认用log.Infow输出结构化字段
+type AccessLogger func(ctx context.Context, entry *AccessLogEntry)
+
+// AccessLogOptions 访问日志配置
+type AccessLogOptions struct {
+	// SampleRate 2xx~4xx请求的采样率，0~1，5xx和出错的请求总是输出，默认1
+	SampleRate float64
+	Disabled   bool
+	Logger     AccessLogger
+}
+
+// SetAccessLog 设置访问日志
+func (p *ReverseProxy) SetAccessLog(opts AccessLogOptions) {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	if opts.Logger == nil {
+		opts.Logger = defaultAccessLogger
+	}
+	p.mutex.Lock()
+	p.accessLog = opts
+	p.mutex.Unlock()
+}
+
+func defaultAccessLogger(ctx context.Context, entry *AccessLogEntry) {
+	keyvals := []interface{}{
+		log.DefaultMessageKey, "access",
+		"method", entry.Method,
+		"path", entry.Path,
+		"route", entry.Route,
+		"upstream", entry.Upstream,
+		"backend", entry.Backend,
+		"status", entry.Status,
+		"bytes_in", entry.BytesIn,
+		"bytes_out", entry.BytesOut,
+		"latency_ms", float64(entry.Latency.Microseconds()) / 1000,
+		"client_ip", entry.ClientIP,
+		"trace_id", entry.TraceID,
+	}
+	if len(entry.Body) > 0 {
+		keyvals = append(keyvals, "req_body", entry.Body)
+	}
+	if entry.Err != nil {
+		keyvals = append(keyvals, "err", entry.Err.Error())
+		log.Warnw(ctx, keyvals...)
+		return
+	}
+	log.Infow(ctx, keyvals...)
+}
+
+func (p *ReverseProxy) writeAccessLog(ctx context.Context, r *http.Request, entry *AccessLogEntry) {
+	p.mutex.RLock()
+	opts := p.accessLog
+	p.mutex.RUnlock()
+	if opts.Disabled {
+		return
+	}
+	if entry.Status < http.StatusInternalServerError && entry.Err == nil && opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate {
+		return
+	}
+	entry.ClientIP = nnet.GetClientIP(r)
+	if spanContext := oteltrace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		entry.TraceID = spanContext.TraceID().String()
+	}
+	opts.Logger(ctx, entry)
+}
+
+// accessWriter 记录响应的状态码和字节数
+type accessWriter struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Hijack websocket和CONNECT隧道劫持连接，之后的数据不经过accessWriter
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, buf, err
+}
+
+// Unwrap 让http.ResponseController能找到原始的ResponseWriter
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusCode 隧道建立后没有经过WriteHeader，按请求类型给出状态码
+func (w *accessWriter) statusCode(r *http.Request) int {
+	switch {
+	case w.status != 0:
+		return w.status
+	case w.hijacked && r.Method == http.MethodConnect:
+		return http.StatusOK
+	case w.hijacked:
+		return http.StatusSwitchingProtocols
+	}
+	return http.StatusOK
+}
+
+// backendRecorder 记录实际转发的后端，缓存在后台更新时也会写入
+type backendRecorder struct {
+	mutex   sync.Mutex
+	backend string
+}
+
+func (r *backendRecorder) set(backend string) {
+	r.mutex.Lock()
+	r.backend = backend
+	r.mutex.Unlock()
+}
+
+func (r *backendRecorder) get() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.backend
+}
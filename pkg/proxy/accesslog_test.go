@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordTracer 记录创建的span，父子关系用SpanContext表示
+type recordTracer struct {
+	noop.Tracer
+	mutex sync.Mutex
+	next  byte
+	spans []*recordSpan
+}
+
+type recordSpan struct {
+	noop.Span
+	name    string
+	kind    oteltrace.SpanKind
+	parent  oteltrace.SpanContext
+	context oteltrace.SpanContext
+	status  codes.Code
+	ended   bool
+}
+
+func (t *recordTracer) Start(ctx context.Context, name string, opts ...oteltrace.SpanStartOption) (context.Context, oteltrace.Span) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	parent := oteltrace.SpanContextFromContext(ctx)
+	traceID := parent.TraceID()
+	if !traceID.IsValid() {
+		traceID = oteltrace.TraceID{0xee}
+	}
+	t.next++
+	config := oteltrace.NewSpanStartConfig(opts...)
+	span := &recordSpan{
+		name:    name,
+		kind:    config.SpanKind(),
+		parent:  parent,
+		context: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{TraceID: traceID, SpanID: oteltrace.SpanID{t.next}, TraceFlags: oteltrace.FlagsSampled}),
+	}
+	t.spans = append(t.spans, span)
+	return oteltrace.ContextWithSpan(ctx, span), span
+}
+
+func (t *recordTracer) reset() []*recordSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	spans := t.spans
+	t.spans = nil
+	return spans
+}
+
+func (s *recordSpan) SpanContext() oteltrace.SpanContext          { return s.context }
+func (s *recordSpan) IsRecording() bool                           { return true }
+func (s *recordSpan) SetStatus(code codes.Code, _ string)         { s.status = code }
+func (s *recordSpan) SetAttributes(...attribute.KeyValue)         {}
+func (s *recordSpan) End(...oteltrace.SpanEndOption)              { s.ended = true }
+func (s *recordSpan) RecordError(error, ...oteltrace.EventOption) {}
+
+func TestReverseProxyTracing(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer backend.Close()
+	tracer := &recordTracer{}
+	proxy := NewReverseProxy(tracer)
+	proxy.ResetProxyPort(int32(backendPort(t, backend)))
+	proxy.SetAccessLog(AccessLogOptions{Disabled: true})
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+	do := func(path string) {
+		req, _ := http.NewRequest(http.MethodGet, front.URL+path, nil)
+		req.Header.Set("Traceparent", "00-0102030405060708090a0b0c0d0e0f10-1112131415161718-01")
+		resp, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	do("/ok")
+	spans := tracer.reset()
+	if len(spans) != 2 {
+		t.Fatalf("expected server and client span, got %d", len(spans))
+	}
+	server, client := spans[0], spans[1]
+	if server.kind != oteltrace.SpanKindServer || server.parent.SpanID().String() != "1112131415161718" ||
+		server.context.TraceID().String() != "0102030405060708090a0b0c0d0e0f10" {
+		t.Fatalf("expected server span to continue incoming trace, got parent %v", server.parent)
+	}
+	if client.kind != oteltrace.SpanKindClient || client.parent.SpanID() != server.context.SpanID() {
+		t.Fatal("expected client span to be a child of the server span")
+	}
+	if want := "00-0102030405060708090a0b0c0d0e0f10-" + client.context.SpanID().String() + "-01"; traceparent != want {
+		t.Fatalf("expected backend to receive client span %s, got %s", want, traceparent)
+	}
+	if !server.ended || !client.ended || server.status == codes.Error || client.status == codes.Error {
+		t.Fatal("expected successful spans to be ended without error")
+	}
+
+	do("/missing")
+	spans = tracer.reset()
+	if spans[0].status == codes.Error || spans[1].status != codes.Error {
+		t.Fatal("expected 404 to be an error only on the client span")
+	}
+
+	backend.Close()
+	do("/down")
+	spans = tracer.reset()
+	if spans[0].status != codes.Error || spans[1].status != codes.Error {
+		t.Fatal("expected 502 to be an error on both spans")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/games/7/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		io.WriteString(w, "hello")
+	}))
+	defer backend.Close()
+	proxy := NewReverseProxy(&recordTracer{})
+	proxy.ResetProxyPort(int32(backendPort(t, backend)))
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+	var mutex sync.Mutex
+	var entries []*AccessLogEntry
+	logger := func(ctx context.Context, entry *AccessLogEntry) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		entries = append(entries, entry)
+	}
+	proxy.SetAccessLog(AccessLogOptions{Logger: logger})
+	proxy.Use(Hook{Name: "games", Pattern: "/games/{gid}/{action}"})
+	do := func(path, body string) *AccessLogEntry {
+		req, _ := http.NewRequest(http.MethodPost, front.URL+path, strings.NewReader(body))
+		req.Header.Set("X-Forwarded-For", "10.1.1.1")
+		resp, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		mutex.Lock()
+		defer mutex.Unlock()
+		if len(entries) == 0 {
+			return nil
+		}
+		entry := entries[len(entries)-1]
+		entries = nil
+		return entry
+	}
+
+	entry := do("/games/7/start", `{"token":"abc"}`)
+	if entry == nil || entry.Method != http.MethodPost || entry.Path != "/games/7/start" || entry.Route != "/games/{gid}/{action}" ||
+		entry.Status != http.StatusOK || entry.BytesIn != 15 || entry.BytesOut != 5 || entry.ClientIP != "10.1.1.1" ||
+		entry.Backend != fmt.Sprintf("localhost:%d", backendPort(t, backend)) || entry.Latency <= 0 || len(entry.TraceID) != 32 || entry.Body != "" {
+		t.Fatalf("unexpected access log %+v", entry)
+	}
+
+	proxy.SetBodyLog(64, defaultRedactKeys...)
+	if entry = do("/games/7/start", `{"token":"abc"}`); entry == nil || entry.Body != `{"token":"***"}` {
+		t.Fatalf("expected redacted body in access log, got %+v", entry)
+	}
+
+	// 采样只影响没有出错的请求
+	proxy.SetAccessLog(AccessLogOptions{Logger: logger, SampleRate: 1e-9})
+	if entry = do("/games/7/start", ""); entry != nil {
+		t.Fatalf("expected successful request to be sampled out, got %+v", entry)
+	}
+	if entry = do("/games/7/fail", ""); entry == nil || entry.Status != http.StatusInternalServerError {
+		t.Fatalf("expected failed request to be logged, got %+v", entry)
+	}
+	backend.Close()
+	if entry = do("/games/7/start", ""); entry == nil || entry.Status != http.StatusBadGateway || entry.Err == nil {
+		t.Fatalf("expected proxy error to be logged, got %+v", entry)
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Balancer 从可用的后端里选择一个，backends不为空
+type Balancer interface {
+	Pick(req *http.Request, backends []*Backend) *Backend
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin 按顺序轮流选择后端
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(_ *http.Request, backends []*Backend) *Backend {
+	return backends[(b.next.Add(1)-1)%uint64(len(backends))]
+}
+
+type leastConnections struct {
+	roundRobin
+}
+
+// LeastConnections 选择正在处理的请求最少的后端，请求数相同时轮流选择
+func LeastConnections() Balancer {
+	return &leastConnections{}
+}
+
+func (b *leastConnections) Pick(req *http.Request, backends []*Backend) *Backend {
+	offset := int(b.next.Add(1) - 1)
+	var picked *Backend
+	for index := range backends {
+		backend := backends[(offset+index)%len(backends)]
+		if picked == nil || backend.Active() < picked.Active() {
+			picked = backend
+		}
+	}
+	return picked
+}
+
+// hashReplicas 每个后端在哈希环上的虚拟节点数
+const hashReplicas = 100
+
+type consistentHash struct {
+	header   string
+	fallback roundRobin
+	mutex    sync.Mutex
+	key      string // 构建哈希环的后端列表，后端可用状态变化时重建
+	ring     []uint32
+	nodes    map[uint32]*Backend
+}
+
+// ConsistentHash 按请求头header的值做一致性哈希，同一个值总是转发到同一个后端(比如同一个vmid的请求)，
+// 后端不可用时只有它上面的值会迁移到其他后端。请求没有这个头时轮流选择
+func ConsistentHash(header string) Balancer {
+	return &consistentHash{header: header}
+}
+
+func (b *consistentHash) Pick(req *http.Request, backends []*Backend) *Backend {
+	value := req.Header.Get(b.header)
+	if len(value) == 0 {
+		return b.fallback.Pick(req, backends)
+	}
+	hash := crc32.ChecksumIEEE([]byte(value))
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.build(backends)
+	index := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i] >= hash
+	})
+	if index == len(b.ring) {
+		index = 0
+	}
+	return b.nodes[b.ring[index]]
+}
+
+func (b *consistentHash) build(backends []*Backend) {
+	addrs := make([]string, 0, len(backends))
+	for _, backend := range backends {
+		addrs = append(addrs, backend.Addr)
+	}
+	key := strings.Join(addrs, ",")
+	if key == b.key {
+		return
+	}
+	b.key = key
+	b.ring = b.ring[:0]
+	b.nodes = make(map[uint32]*Backend, len(backends)*hashReplicas)
+	for _, backend := range backends {
+		for replica := 0; replica < hashReplicas; replica++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(replica) + "#" + backend.Addr))
+			if _, ok := b.nodes[hash]; ok {
+				continue
+			}
+			b.nodes[hash] = backend
+			b.ring = append(b.ring, hash)
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool {
+		return b.ring[i] < b.ring[j]
+	})
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const redactedValue = "***"
+
+// defaultRedactKeys 打印请求体时隐藏这些json字段的值
+var defaultRedactKeys = []string{"password", "passwd", "token", "secret", "access_key", "authorization"}
+
+// bodyTee 转发请求体的同时保留前limit个字节用于打印日志，不改变转发的数据
+type bodyTee struct {
+	io.ReadCloser
+	limit int
+	head  bytes.Buffer
+	total int64
+}
+
+func newBodyTee(body io.ReadCloser, limit int) *bodyTee {
+	return &bodyTee{ReadCloser: body, limit: limit}
+}
+
+func (t *bodyTee) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if remain := t.limit - t.head.Len(); remain > 0 && n > 0 {
+		t.head.Write(p[:min(n, remain)])
+	}
+	t.total += int64(n)
+	return n, err
+}
+
+// String 打印用的请求体，超过limit的部分被截断
+func (t *bodyTee) String(redactor *regexp.Regexp) string {
+	head := t.head.Bytes()
+	if redactor != nil {
+		head = redactor.ReplaceAll(head, []byte(`"$1"$2"`+redactedValue+`"`))
+	}
+	if t.total > int64(t.head.Len()) {
+		return fmt.Sprintf("%s...(truncated, %d bytes)", head, t.total)
+	}
+	return string(head)
+}
+
+// newRedactor 匹配 "key": "value" 形式的字段，key不区分大小写。日志里的请求体可能在值的中间被截断，
+// 没有结束引号的值一直匹配到结尾
+func newRedactor(keys []string) *regexp.Regexp {
+	if len(keys) == 0 {
+		return nil
+	}
+	quoted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		quoted = append(quoted, regexp.QuoteMeta(key))
+	}
+	return regexp.MustCompile(`(?i)"(` + strings.Join(quoted, "|") + `)"(\s*:\s*)"(?:[^"\\]|\\.)*(?:"|\\?$)`)
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"accumulation/pkg/log"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 上游熔断中，代理返回503
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const breakerBuckets = 10
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	}
+	return "closed"
+}
+
+// BreakerOptions 熔断配置，为0的字段使用默认值
+type BreakerOptions struct {
+	Window        time.Duration // 统计错误率和慢请求比例的滑动窗口，默认10秒
+	MinRequests   int           // 窗口内请求数少于MinRequests时不熔断，默认20
+	ErrorRate     float64       // 连接失败和5xx的比例超过ErrorRate时熔断，默认0.5
+	SlowThreshold time.Duration // 响应头超过SlowThreshold算慢请求，0不统计
+	SlowRate      float64       // 慢请求比例超过SlowRate时熔断，默认0.5
+	OpenDuration  time.Duration // 熔断时间，之后半开放行探测请求，默认30秒
+	Probes        int           // 半开时放行的请求数，都成功后恢复，默认1
+}
+
+func (opts BreakerOptions) withDefaults() BreakerOptions {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 20
+	}
+	if opts.ErrorRate <= 0 {
+		opts.ErrorRate = 0.5
+	}
+	if opts.SlowRate <= 0 {
+		opts.SlowRate = 0.5
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 30 * time.Second
+	}
+	if opts.Probes <= 0 {
+		opts.Probes = 1
+	}
+	return opts
+}
+
+type breakerBucket struct {
+	start    int64 // 桶的开始时间，UnixNano
+	requests int
+	failures int
+	slow     int
+}
+
+// circuitBreaker 按窗口内的错误率和慢请求比例熔断，窗口分成breakerBuckets个桶滑动
+type circuitBreaker struct {
+	name      string
+	opts      BreakerOptions
+	mutex     sync.Mutex
+	state     BreakerState
+	buckets   [breakerBuckets]breakerBucket
+	openedAt  time.Time
+	probing   int // 半开时已经放行的请求数
+	succeeded int // 半开时成功的探测请求数
+}
+
+func newCircuitBreaker(name string, opts BreakerOptions) *circuitBreaker {
+	breaker := &circuitBreaker{name: name, opts: opts.withDefaults()}
+	breakerState.WithLabelValues(name).Set(float64(BreakerClosed))
+	return breaker
+}
+
+// State 当前状态，熔断时间过了之后返回半开
+func (b *circuitBreaker) State() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.expire(time.Now())
+	return b.state
+}
+
+// allow 是否放行一个请求，放行的请求必须调用record
+func (b *circuitBreaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.expire(time.Now())
+	switch b.state {
+	case BreakerOpen:
+		return fmt.Errorf("upstream %s: %w", b.name, ErrCircuitOpen)
+	case BreakerHalfOpen:
+		if b.probing >= b.opts.Probes {
+			return fmt.Errorf("upstream %s: %w", b.name, ErrCircuitOpen)
+		}
+		b.probing++
+	}
+	return nil
+}
+
+func (b *circuitBreaker) record(failed bool, latency time.Duration) {
+	now := time.Now()
+	slow := b.opts.SlowThreshold > 0 && latency >= b.opts.SlowThreshold
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed || slow {
+			b.transit(BreakerOpen, now)
+			return
+		}
+		b.succeeded++
+		if b.succeeded >= b.opts.Probes {
+			b.transit(BreakerClosed, now)
+		}
+		return
+	case BreakerOpen:
+		// 熔断前放行的请求
+		return
+	}
+	bucket := b.bucket(now)
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+	if slow {
+		bucket.slow++
+	}
+	var requests, failures, slows int
+	for index := range b.buckets {
+		if now.UnixNano()-b.buckets[index].start < int64(b.opts.Window) {
+			requests += b.buckets[index].requests
+			failures += b.buckets[index].failures
+			slows += b.buckets[index].slow
+		}
+	}
+	if requests < b.opts.MinRequests {
+		return
+	}
+	errorRate, slowRate := float64(failures)/float64(requests), float64(slows)/float64(requests)
+	if errorRate >= b.opts.ErrorRate || (b.opts.SlowThreshold > 0 && slowRate >= b.opts.SlowRate) {
+		log.Warnf(context.TODO(), "upstream %s circuit breaker open, requests:%d error rate:%.2f slow rate:%.2f",
+			b.name, requests, errorRate, slowRate)
+		b.transit(BreakerOpen, now)
+	}
+}
+
+// bucket 返回now所在的桶，过期的桶清零
+func (b *circuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := int64(b.opts.Window) / breakerBuckets
+	start := now.UnixNano() / width * width
+	bucket := &b.buckets[(start/width)%breakerBuckets]
+	if bucket.start != start {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+func (b *circuitBreaker) expire(now time.Time) {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.opts.OpenDuration {
+		b.transit(BreakerHalfOpen, now)
+	}
+}
+
+func (b *circuitBreaker) transit(state BreakerState, now time.Time) {
+	if state != b.state {
+		log.Infof(context.TODO(), "upstream %s circuit breaker %s -> %s", b.name, b.state, state)
+	}
+	b.state = state
+	b.probing, b.succeeded = 0, 0
+	switch state {
+	case BreakerOpen:
+		b.openedAt = now
+	case BreakerClosed:
+		b.buckets = [breakerBuckets]breakerBucket{}
+	}
+	breakerState.WithLabelValues(b.name).Set(float64(state))
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := newCircuitBreaker("errors", BreakerOptions{MinRequests: 4, ErrorRate: 0.5, OpenDuration: 50 * time.Millisecond, Probes: 2})
+	for _, failed := range []bool{false, true, false} {
+		if err := breaker.allow(); err != nil {
+			t.Fatal(err)
+		}
+		breaker.record(failed, 0)
+	}
+	if breaker.State() != BreakerClosed {
+		t.Fatal("expected breaker to stay closed below min requests")
+	}
+	breaker.record(true, 0)
+	if breaker.State() != BreakerOpen || breaker.allow() == nil {
+		t.Fatal("expected breaker to open at 50% error rate")
+	}
+	if got := testutil.ToFloat64(breakerState.WithLabelValues("errors")); got != float64(BreakerOpen) {
+		t.Fatalf("unexpected state metric %v", got)
+	}
+	time.Sleep(60 * time.Millisecond)
+	// 半开时只放行Probes个请求，都成功后恢复
+	if breaker.allow() != nil || breaker.allow() != nil || breaker.allow() == nil {
+		t.Fatal("expected half open breaker to allow 2 probes")
+	}
+	breaker.record(false, 0)
+	if breaker.State() != BreakerHalfOpen {
+		t.Fatal("expected breaker to wait for all probes")
+	}
+	breaker.record(false, 0)
+	if breaker.State() != BreakerClosed {
+		t.Fatal("expected breaker to close after probes succeed")
+	}
+
+	slow := newCircuitBreaker("slow", BreakerOptions{MinRequests: 2, SlowThreshold: 10 * time.Millisecond, SlowRate: 0.5})
+	slow.record(false, time.Millisecond)
+	slow.record(false, 20*time.Millisecond)
+	if slow.State() != BreakerOpen {
+		t.Fatal("expected breaker to open on slow requests")
+	}
+}
+
+func TestUpstreamCircuitBreaker(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	backend := namedBackend(t, "backend", &status)
+	upstream, _ := NewUpstream("breaker", []string{backend.Listener.Addr().String()}, UpstreamOptions{
+		Breaker: &BreakerOptions{MinRequests: 3, OpenDuration: time.Minute},
+	})
+	front := newUpstreamProxy(t, upstream)
+	codes := map[int]int{}
+	for i := 0; i < 5; i++ {
+		code, _ := request(t, front, http.MethodGet)
+		codes[code]++
+	}
+	if codes[http.StatusInternalServerError] != 3 || codes[http.StatusServiceUnavailable] != 2 || upstream.BreakerState() != BreakerOpen {
+		t.Fatalf("unexpected status codes %v", codes)
+	}
+}
@@ -0,0 +1,516 @@
+package proxy
+
+import (
+	"accumulation/pkg/log"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultCacheMemoryBytes  = 64 << 20
+	defaultCacheDiskBytes    = 1 << 30
+	defaultCacheMaxEntrySize = 1 << 20
+
+	cacheHit         = "hit"
+	cacheMiss        = "miss"
+	cacheStale       = "stale"       // stale-while-revalidate，返回过期的缓存并在后台更新
+	cacheRevalidated = "revalidated" // 后端返回304
+	cacheBypass      = "bypass"      // 请求或者响应不能缓存
+)
+
+// CacheOptions 响应缓存配置，为0的字段使用默认值
+type CacheOptions struct {
+	MemoryBytes  int64  // 内存lru的字节数，默认64MB
+	Dir          string // 不为空时从内存淘汰的条目保存到磁盘
+	DiskMaxBytes int64  // 磁盘缓存的字节数，默认1GB
+}
+
+// CacheRule 缓存规则，只缓存GET请求
+type CacheRule struct {
+	Name    string
+	Pattern string // gorilla/mux的路径模板，为空时匹配所有路径
+	// DefaultTTL 响应没有Cache-Control max-age和Expires时的缓存时间，0时这种响应只在有ETag或Last-Modified时保存，每次都重新验证
+	DefaultTTL   time.Duration
+	MaxEntrySize int64 // 响应体超过MaxEntrySize时不缓存，默认1MB
+}
+
+type cacheRule struct {
+	CacheRule
+	route *mux.Route
+}
+
+// Cache 遵循RFC 7234的共享缓存，处理Cache-Control、Expires、ETag/Last-Modified重新验证、Vary和stale-while-revalidate
+type Cache struct {
+	memory       *lruStore
+	disk         *diskStore
+	mutex        sync.Mutex
+	variants     map[string]*cacheVariants // url对应的变体
+	revalidating map[string]bool
+}
+
+// cacheVariants 一个url按Vary请求头保存的所有条目
+type cacheVariants struct {
+	vary []string
+	keys map[string]bool
+}
+
+func NewCache(opts CacheOptions) (*Cache, error) {
+	if opts.MemoryBytes <= 0 {
+		opts.MemoryBytes = defaultCacheMemoryBytes
+	}
+	if opts.DiskMaxBytes <= 0 {
+		opts.DiskMaxBytes = defaultCacheDiskBytes
+	}
+	cache := &Cache{variants: make(map[string]*cacheVariants), revalidating: make(map[string]bool)}
+	evict := func(item *lruItem) {
+		cache.forget(item.key)
+	}
+	if len(opts.Dir) > 0 {
+		disk, err := newDiskStore(opts.Dir, opts.DiskMaxBytes, cache.forget)
+		if err != nil {
+			return nil, fmt.Errorf("cache dir %s err:%w", opts.Dir, err)
+		}
+		cache.disk = disk
+		evict = func(item *lruItem) {
+			disk.set(item.entry)
+		}
+	}
+	cache.memory = newLRUStore(opts.MemoryBytes, evict)
+	return cache, nil
+}
+
+// SetCache 设置响应缓存，cache为nil时关闭缓存
+func (p *ReverseProxy) SetCache(cache *Cache) {
+	p.mutex.Lock()
+	p.cache = cache
+	p.mutex.Unlock()
+}
+
+// AddCacheRule 添加缓存规则，匹配多个规则时使用第一个
+func (p *ReverseProxy) AddCacheRule(rule CacheRule) error {
+	route := mux.NewRouter().NewRoute()
+	if len(rule.Pattern) > 0 {
+		route = route.Path(rule.Pattern)
+	}
+	if err := route.GetError(); err != nil {
+		return fmt.Errorf("cache rule %s pattern %s err:%w", rule.Name, rule.Pattern, err)
+	}
+	if rule.MaxEntrySize <= 0 {
+		rule.MaxEntrySize = defaultCacheMaxEntrySize
+	}
+	p.mutex.Lock()
+	p.cacheRules = append(p.cacheRules, &cacheRule{CacheRule: rule, route: route})
+	p.mutex.Unlock()
+	return nil
+}
+
+func (p *ReverseProxy) matchCacheRule(r *http.Request) (*Cache, *cacheRule) {
+	p.mutex.RLock()
+	cache, rules := p.cache, p.cacheRules
+	p.mutex.RUnlock()
+	if cache == nil {
+		return nil, nil
+	}
+	for _, rule := range rules {
+		var match mux.RouteMatch
+		if rule.route.Match(r, &match) {
+			return cache, rule
+		}
+	}
+	return nil, nil
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+// cacheControl Cache-Control的指令，没有值的指令值为空
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if len(directive) == 0 {
+				continue
+			}
+			name, arg, _ := strings.Cut(directive, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// primaryKey 按转发的目标缓存，req.URL.Host是director设置的后端地址或者upstream.<name>，
+// 不能用客户端的Host，同一个Host的请求可能按请求头转发到不同的后端。scope是路由请求头的值
+func primaryKey(req *http.Request, scope string) string {
+	return req.URL.Host + "|" + scope + "|" + req.URL.RequestURI()
+}
+
+// variantKey 加上Vary请求头的值
+func variantKey(primary string, vary []string, header http.Header) string {
+	if len(vary) == 0 {
+		return primary
+	}
+	var builder strings.Builder
+	builder.WriteString(primary)
+	for _, name := range vary {
+		builder.WriteString("\n" + name + ":" + strings.Join(header.Values(name), ","))
+	}
+	return builder.String()
+}
+
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Cache) get(key string) (*cacheEntry, bool) {
+	if item, ok := c.memory.get(key); ok {
+		return item.entry, true
+	}
+	if c.disk == nil {
+		return nil, false
+	}
+	entry, ok := c.disk.get(key)
+	if ok {
+		// 提升到内存，磁盘上的文件等内存淘汰时覆盖
+		c.memory.add(&lruItem{key: key, size: entry.size(), entry: entry})
+	}
+	return entry, ok
+}
+
+func (c *Cache) set(primary string, vary []string, entry *cacheEntry) {
+	c.mutex.Lock()
+	variants, ok := c.variants[primary]
+	if !ok || strings.Join(variants.vary, ",") != strings.Join(vary, ",") {
+		variants = &cacheVariants{vary: vary, keys: map[string]bool{}}
+		c.variants[primary] = variants
+	}
+	variants.keys[entry.Key] = true
+	c.mutex.Unlock()
+	if !c.memory.add(&lruItem{key: entry.Key, size: entry.size(), entry: entry}) && c.disk != nil {
+		c.disk.set(entry)
+	}
+	c.updateMetrics()
+}
+
+// forget 条目被淘汰时删除变体的记录
+func (c *Cache) forget(key string) {
+	primary, _, _ := strings.Cut(key, "\n")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if variants, ok := c.variants[primary]; ok {
+		delete(variants.keys, key)
+		if len(variants.keys) == 0 {
+			delete(c.variants, primary)
+		}
+	}
+}
+
+// remove 删除url的所有变体
+func (c *Cache) remove(primary string) {
+	keys := []string{primary}
+	c.mutex.Lock()
+	if variants, ok := c.variants[primary]; ok {
+		for key := range variants.keys {
+			keys = append(keys, key)
+		}
+	}
+	delete(c.variants, primary)
+	c.mutex.Unlock()
+	for _, key := range keys {
+		c.memory.remove(key)
+		if c.disk != nil {
+			c.disk.remove(key)
+		}
+	}
+	c.updateMetrics()
+}
+
+func (c *Cache) updateMetrics() {
+	cacheBytes.WithLabelValues("memory").Set(float64(c.memory.size()))
+	if c.disk != nil {
+		cacheBytes.WithLabelValues("disk").Set(float64(c.disk.index.size()))
+	}
+}
+
+// freshness 响应的新鲜时间，s-maxage优先，然后是max-age、Expires和规则的DefaultTTL
+func (e *cacheEntry) freshness(rule *cacheRule) time.Duration {
+	cc := parseCacheControl(e.Header)
+	if cc.has("no-cache") {
+		return 0
+	}
+	if maxAge, ok := cc.seconds("s-maxage"); ok {
+		return maxAge
+	}
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+	if expires := e.Header.Get("Expires"); len(expires) > 0 {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(e.Header.Get("Date"))
+		if err != nil {
+			date = e.ResponseTime
+		}
+		return expiresAt.Sub(date)
+	}
+	return rule.DefaultTTL
+}
+
+// age RFC 7234 4.2.3的当前年龄
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	age := e.ResponseTime.Sub(e.RequestTime)
+	if seconds, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+	return age + now.Sub(e.ResponseTime)
+}
+
+func (e *cacheEntry) hasValidator() bool {
+	return len(e.Header.Get("ETag")) > 0 || len(e.Header.Get("Last-Modified")) > 0
+}
+
+func (e *cacheEntry) response(req *http.Request, now time.Time, result string) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	header.Set("X-Cache", result)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMovedPermanently,
+		http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// storable RFC 7234 3，共享缓存不保存private和带Authorization的响应
+func storable(req *http.Request, resp *http.Response, rule *cacheRule) bool {
+	if !cacheableStatus(resp.StatusCode) || parseCacheControl(req.Header).has("no-store") {
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	// 带Set-Cookie的响应是给某个客户端的
+	if len(resp.Header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	if len(req.Header.Get("Authorization")) > 0 && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	if cc.has("max-age") || cc.has("s-maxage") || len(resp.Header.Get("Expires")) > 0 || rule.DefaultTTL > 0 {
+		return true
+	}
+	return len(resp.Header.Get("ETag")) > 0 || len(resp.Header.Get("Last-Modified")) > 0
+}
+
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// roundTrip 先查缓存，没有或者过期时通过next请求后端，scope不同的请求不共用缓存
+func (c *Cache) roundTrip(rule *cacheRule, req *http.Request, scope string, next roundTripFunc) (*http.Response, error) {
+	primary := primaryKey(req, scope)
+	if req.Method != http.MethodGet {
+		resp, err := next(req)
+		// RFC 7234 4.4 不安全的方法成功后删除缓存
+		if err == nil && unsafeMethod(req.Method) && resp.StatusCode < 400 {
+			c.remove(primary)
+		}
+		return resp, err
+	}
+	reqCC := parseCacheControl(req.Header)
+	if reqCC.has("no-store") {
+		cacheRequests.WithLabelValues(rule.Name, cacheBypass).Inc()
+		return next(req)
+	}
+	var vary []string
+	c.mutex.Lock()
+	if variants, ok := c.variants[primary]; ok {
+		vary = variants.vary
+	}
+	c.mutex.Unlock()
+	key := variantKey(primary, vary, req.Header)
+	entry, ok := c.get(key)
+	if !ok {
+		if reqCC.has("only-if-cached") {
+			cacheRequests.WithLabelValues(rule.Name, cacheMiss).Inc()
+			return &http.Response{StatusCode: http.StatusGatewayTimeout, Status: "504 Gateway Timeout", Proto: "HTTP/1.1",
+				ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+		}
+		return c.fetch(rule, req, primary, nil, next)
+	}
+	now := time.Now()
+	age, freshness := entry.age(now), entry.freshness(rule)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && maxAge < freshness {
+		freshness = maxAge
+	}
+	noCache := reqCC.has("no-cache") || req.Header.Get("Pragma") == "no-cache"
+	if !noCache && age < freshness {
+		cacheRequests.WithLabelValues(rule.Name, cacheHit).Inc()
+		return entry.response(req, now, cacheHit), nil
+	}
+	respCC := parseCacheControl(entry.Header)
+	if swr, ok := respCC.seconds("stale-while-revalidate"); ok && !noCache && !respCC.has("must-revalidate") &&
+		!respCC.has("no-cache") && age < freshness+swr {
+		c.revalidateAsync(rule, req, primary, entry, next)
+		cacheRequests.WithLabelValues(rule.Name, cacheStale).Inc()
+		return entry.response(req, now, cacheStale), nil
+	}
+	return c.fetch(rule, req, primary, entry, next)
+}
+
+func (c *Cache) revalidateAsync(rule *cacheRule, req *http.Request, primary string, entry *cacheEntry, next roundTripFunc) {
+	c.mutex.Lock()
+	if c.revalidating[entry.Key] {
+		c.mutex.Unlock()
+		return
+	}
+	c.revalidating[entry.Key] = true
+	c.mutex.Unlock()
+	background := req.Clone(context.WithoutCancel(req.Context()))
+	go func() {
+		defer func() {
+			c.mutex.Lock()
+			delete(c.revalidating, entry.Key)
+			c.mutex.Unlock()
+		}()
+		resp, err := c.fetch(rule, background, primary, entry, next)
+		if err != nil {
+			log.Warnf(background.Context(), "revalidate %s failure err:%v", primary, err)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
+
+// fetch 请求后端，entry不为空时带上条件请求头，304时更新缓存
+func (c *Cache) fetch(rule *cacheRule, req *http.Request, primary string, entry *cacheEntry, next roundTripFunc) (*http.Response, error) {
+	if entry != nil && entry.hasValidator() {
+		if etag := entry.Header.Get("ETag"); len(etag) > 0 {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); len(lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	requestTime := time.Now()
+	resp, err := next(req)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := time.Now()
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		updated := *entry
+		updated.Header = entry.Header.Clone()
+		for name, values := range resp.Header {
+			switch name {
+			case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+				continue
+			}
+			updated.Header[name] = values
+		}
+		updated.RequestTime, updated.ResponseTime = requestTime, responseTime
+		c.set(primary, varyHeaders(updated.Header), &updated)
+		cacheRequests.WithLabelValues(rule.Name, cacheRevalidated).Inc()
+		return updated.response(req, responseTime, cacheRevalidated), nil
+	}
+	if !storable(req, resp, rule) {
+		if entry != nil {
+			c.remove(primary)
+		}
+		cacheRequests.WithLabelValues(rule.Name, cacheBypass).Inc()
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, rule.MaxEntrySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > rule.MaxEntrySize {
+		// 太大的响应不缓存，已经读出的部分和剩下的一起返回
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		cacheRequests.WithLabelValues(rule.Name, cacheBypass).Inc()
+		return resp, nil
+	}
+	resp.Body.Close()
+	vary := varyHeaders(resp.Header)
+	stored := &cacheEntry{
+		Key:          variantKey(primary, vary, req.Header),
+		Status:       resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+	stored.Header.Del("X-Cache")
+	c.set(primary, vary, stored)
+	cacheRequests.WithLabelValues(rule.Name, cacheMiss).Inc()
+	return stored.response(req, responseTime, cacheMiss), nil
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"accumulation/pkg/log"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// cacheEntry 缓存的响应
+type cacheEntry struct {
+	Key          string
+	Status       int
+	Header       http.Header
+	Body         []byte
+	RequestTime  time.Time // 发出请求的时间
+	ResponseTime time.Time // 收到响应的时间
+}
+
+func (e *cacheEntry) size() int64 {
+	size := int64(len(e.Key) + len(e.Body))
+	for key, values := range e.Header {
+		for _, value := range values {
+			size += int64(len(key) + len(value))
+		}
+	}
+	return size
+}
+
+type lruItem struct {
+	key      string
+	size     int64
+	entry    *cacheEntry // 磁盘上的条目为空
+	cacheKey string      // 磁盘上的条目对应的缓存key，启动时加载的文件为空
+}
+
+// lruStore 按字节数限制的lru，evict在持有锁的时候调用
+type lruStore struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	bytes    int64
+	order    *list.List // 前面是最近使用的
+	items    map[string]*list.Element
+	evict    func(item *lruItem)
+}
+
+func newLRUStore(maxBytes int64, evict func(item *lruItem)) *lruStore {
+	return &lruStore{maxBytes: maxBytes, order: list.New(), items: make(map[string]*list.Element), evict: evict}
+}
+
+func (s *lruStore) get(key string) (*lruItem, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	element, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return element.Value.(*lruItem), true
+}
+
+// add 条目比maxBytes大时不保存
+func (s *lruStore) add(item *lruItem) bool {
+	if item.size > s.maxBytes {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if element, ok := s.items[item.key]; ok {
+		s.bytes -= element.Value.(*lruItem).size
+		s.order.Remove(element)
+	}
+	s.items[item.key] = s.order.PushFront(item)
+	s.bytes += item.size
+	for s.bytes > s.maxBytes {
+		oldest := s.order.Back()
+		evicted := s.order.Remove(oldest).(*lruItem)
+		delete(s.items, evicted.key)
+		s.bytes -= evicted.size
+		if s.evict != nil {
+			s.evict(evicted)
+		}
+	}
+	return true
+}
+
+func (s *lruStore) remove(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	element, ok := s.items[key]
+	if !ok {
+		return false
+	}
+	s.order.Remove(element)
+	delete(s.items, key)
+	s.bytes -= element.Value.(*lruItem).size
+	return true
+}
+
+func (s *lruStore) size() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.bytes
+}
+
+// diskStore 磁盘缓存，文件名是key的sha256，内存里只保存文件的lru索引
+type diskStore struct {
+	dir   string
+	index *lruStore
+}
+
+// newDiskStore evict在文件被淘汰时调用
+func newDiskStore(dir string, maxBytes int64, evict func(cacheKey string)) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	store := &diskStore{dir: dir}
+	store.index = newLRUStore(maxBytes, func(item *lruItem) {
+		os.Remove(store.path(item.key))
+		if len(item.cacheKey) > 0 {
+			evict(item.cacheKey)
+		}
+	})
+	// 加载上次保存的文件，按修改时间排成lru
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || filepath.Ext(entry.Name()) == ".tmp" {
+			continue
+		}
+		files = append(files, file{name: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, file := range files {
+		store.index.add(&lruItem{key: file.name, size: file.size})
+	}
+	return store, nil
+}
+
+func (s *diskStore) name(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *diskStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *diskStore) get(key string) (*cacheEntry, bool) {
+	name := s.name(key)
+	if _, ok := s.index.get(name); !ok {
+		return nil, false
+	}
+	file, err := os.Open(s.path(name))
+	if err != nil {
+		s.index.remove(name)
+		return nil, false
+	}
+	defer file.Close()
+	var entry cacheEntry
+	if err = gob.NewDecoder(file).Decode(&entry); err != nil || entry.Key != key {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (s *diskStore) set(entry *cacheEntry) {
+	name := s.name(entry.Key)
+	tmp, err := os.CreateTemp(s.dir, name+"-*.tmp")
+	if err != nil {
+		log.Warnf(context.TODO(), "create cache file failure err:%v", err)
+		return
+	}
+	err = gob.NewEncoder(tmp).Encode(entry)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	info, statErr := os.Stat(tmp.Name())
+	if err == nil {
+		err = statErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Warnf(context.TODO(), "write cache file failure err:%v", err)
+		return
+	}
+	if !s.index.add(&lruItem{key: name, size: info.Size(), cacheKey: entry.Key}) {
+		os.Remove(s.path(name))
+	}
+}
+
+func (s *diskStore) remove(key string) {
+	name := s.name(key)
+	if s.index.remove(name) {
+		os.Remove(s.path(name))
+	}
+}
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cacheBackend 按路径返回不同的缓存头，统计每个路径的请求数
+type cacheBackend struct {
+	hits    [8]atomic.Int32
+	version atomic.Int32
+}
+
+func (b *cacheBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	paths := []string{"/fresh", "/etag", "/private", "/vary", "/swr", "/nocache"}
+	for index, path := range paths {
+		if r.URL.Path == path {
+			b.hits[index].Add(1)
+		}
+	}
+	version := fmt.Sprintf(`"v%d"`, b.version.Load())
+	switch r.URL.Path {
+	case "/fresh", "/nocache":
+		w.Header().Set("Cache-Control", "max-age=60")
+	case "/etag":
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", version)
+		if r.Header.Get("If-None-Match") == version {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	case "/private":
+		w.Header().Set("Cache-Control", "private, max-age=60")
+	case "/vary":
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		io.WriteString(w, r.Header.Get("Accept-Language")+":")
+	case "/swr":
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+	}
+	io.WriteString(w, r.URL.Path+version)
+}
+
+func (b *cacheBackend) count(path string) int32 {
+	paths := []string{"/fresh", "/etag", "/private", "/vary", "/swr", "/nocache"}
+	for index, p := range paths {
+		if p == path {
+			return b.hits[index].Load()
+		}
+	}
+	return -1
+}
+
+func TestReverseProxyCache(t *testing.T) {
+	handler := &cacheBackend{}
+	backend := httptest.NewServer(handler)
+	defer backend.Close()
+	proxy, front := newTestProxy(t, backend)
+	cache, err := NewCache(CacheOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.SetCache(cache)
+	for _, pattern := range []string{"/fresh", "/etag", "/private", "/vary", "/swr"} {
+		if err = proxy.AddCacheRule(CacheRule{Name: strings.TrimPrefix(pattern, "/"), Pattern: pattern}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	do := func(method, path string, header ...string) (string, string) {
+		req, _ := http.NewRequest(method, front.URL+path, nil)
+		for index := 0; index+1 < len(header); index += 2 {
+			req.Header.Set(header[index], header[index+1])
+		}
+		resp, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Header.Get("X-Cache"), string(body)
+	}
+	expect := func(path string, gotResult, gotBody, result, body string, hits int32) {
+		t.Helper()
+		if gotResult != result || gotBody != body || handler.count(path) != hits {
+			t.Fatalf("%s: got %q %q with %d backend hits, want %q %q %d", path, gotResult, gotBody, handler.count(path), result, body, hits)
+		}
+	}
+
+	result, body := do(http.MethodGet, "/fresh")
+	expect("/fresh", result, body, cacheMiss, `/fresh"v0"`, 1)
+	result, body = do(http.MethodGet, "/fresh")
+	expect("/fresh", result, body, cacheHit, `/fresh"v0"`, 1)
+	result, body = do(http.MethodGet, "/fresh", "Cache-Control", "no-store")
+	expect("/fresh", result, body, "", `/fresh"v0"`, 2)
+	// 不安全的方法删除缓存
+	do(http.MethodPost, "/fresh")
+	result, body = do(http.MethodGet, "/fresh")
+	expect("/fresh", result, body, cacheMiss, `/fresh"v0"`, 4)
+
+	result, body = do(http.MethodGet, "/etag")
+	expect("/etag", result, body, cacheMiss, `/etag"v0"`, 1)
+	result, body = do(http.MethodGet, "/etag")
+	expect("/etag", result, body, cacheRevalidated, `/etag"v0"`, 2)
+	handler.version.Store(1)
+	result, body = do(http.MethodGet, "/etag")
+	expect("/etag", result, body, cacheMiss, `/etag"v1"`, 3)
+
+	do(http.MethodGet, "/private")
+	result, body = do(http.MethodGet, "/private")
+	expect("/private", result, body, "", `/private"v1"`, 2)
+
+	do(http.MethodGet, "/vary", "Accept-Language", "en")
+	result, body = do(http.MethodGet, "/vary", "Accept-Language", "fr")
+	expect("/vary", result, body, cacheMiss, `fr:/vary"v1"`, 2)
+	result, body = do(http.MethodGet, "/vary", "Accept-Language", "en")
+	expect("/vary", result, body, cacheHit, `en:/vary"v1"`, 2)
+
+	do(http.MethodGet, "/swr")
+	handler.version.Store(2)
+	result, body = do(http.MethodGet, "/swr")
+	if result != cacheStale || body != `/swr"v1"` {
+		t.Fatalf("expected stale response, got %q %q", result, body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for handler.count("/swr") != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected stale response to be revalidated in background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 没有匹配规则的路径不缓存
+	do(http.MethodGet, "/nocache")
+	result, _ = do(http.MethodGet, "/nocache")
+	if result != "" || handler.count("/nocache") != 2 {
+		t.Fatalf("expected path without rule not to be cached, got %q", result)
+	}
+}
+
+func TestCacheTiers(t *testing.T) {
+	dir := t.TempDir()
+	rule := &cacheRule{CacheRule: CacheRule{Name: "tier", DefaultTTL: time.Minute, MaxEntrySize: 1 << 10}}
+	var hits atomic.Int32
+	next := func(req *http.Request) (*http.Response, error) {
+		hits.Add(1)
+		body := strings.Repeat("x", 300)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	}
+	get := func(cache *Cache, path string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://game"+path, nil)
+		resp, err := cache.roundTrip(rule, req, "", next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("X-Cache")
+	}
+	cache, err := NewCache(CacheOptions{MemoryBytes: 1 << 10, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		get(cache, fmt.Sprintf("/%d", i))
+	}
+	if cache.memory.size() > 1<<10 || cache.disk.index.size() == 0 {
+		t.Fatalf("expected old entries to be moved to disk, memory %d disk %d", cache.memory.size(), cache.disk.index.size())
+	}
+	if result := get(cache, "/0"); result != cacheHit || hits.Load() != 5 {
+		t.Fatalf("expected disk hit, got %q with %d backend hits", result, hits.Load())
+	}
+
+	// 重启后从磁盘加载
+	reloaded, err := NewCache(CacheOptions{MemoryBytes: 1 << 10, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := get(reloaded, "/1"); result != cacheHit || hits.Load() != 5 {
+		t.Fatalf("expected hit after reload, got %q with %d backend hits", result, hits.Load())
+	}
+
+	// 超过MaxEntrySize的响应不缓存，响应体完整返回
+	rule.MaxEntrySize = 100
+	req := httptest.NewRequest(http.MethodGet, "http://game/large", nil)
+	resp, _ := cache.roundTrip(rule, req, "", next)
+	body, _ := io.ReadAll(resp.Body)
+	if len(body) != 300 || resp.Header.Get("X-Cache") != "" {
+		t.Fatalf("unexpected large response %d bytes %q", len(body), resp.Header.Get("X-Cache"))
+	}
+}
+
+func TestCacheHeaderRoutes(t *testing.T) {
+	newNamed := func(name string) (*httptest.Server, *atomic.Int32) {
+		var hits atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			if r.URL.Path == "/cookie" {
+				w.Header().Set("Set-Cookie", "session="+r.Header.Get("X-Vmid"))
+			}
+			io.WriteString(w, name+":"+r.Header.Get("X-Vmid"))
+		}))
+		t.Cleanup(backend.Close)
+		return backend, &hits
+	}
+	a, aHits := newNamed("a")
+	b, bHits := newNamed("b")
+	proxy, front := newTestProxy(t, a)
+	cache, _ := NewCache(CacheOptions{})
+	proxy.SetCache(cache)
+	proxy.AddCacheRule(CacheRule{Name: "config"})
+	proxy.ResetProxyHeader("X-Vmid")
+	proxy.SetHeaderRoutes(HeaderRoutes{Targets: map[string]HeaderTarget{
+		"1": {Port: backendPort(t, a)},
+		"2": {Port: backendPort(t, b)},
+		"3": {Port: backendPort(t, a)},
+	}})
+	do := func(path, vmid string) string {
+		req, _ := http.NewRequest(http.MethodGet, front.URL+path, nil)
+		req.Header.Set("X-Vmid", vmid)
+		resp, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	// 路径相同，按请求头转发到不同后端的响应不能共用
+	for round := 0; round < 2; round++ {
+		for vmid, want := range map[string]string{"1": "a:1", "2": "b:2", "3": "a:3"} {
+			if got := do("/config", vmid); got != want {
+				t.Fatalf("round %d vmid %s: got %s, want %s", round, vmid, got, want)
+			}
+		}
+	}
+	if aHits.Load() != 2 || bHits.Load() != 1 {
+		t.Fatalf("expected one backend request per vmid, got a:%d b:%d", aHits.Load(), bHits.Load())
+	}
+	do("/cookie", "1")
+	do("/cookie", "1")
+	if aHits.Load() != 4 {
+		t.Fatalf("expected response with Set-Cookie not to be cached, got %d backend hits", aHits.Load())
+	}
+}
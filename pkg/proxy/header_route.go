@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// MissingHeaderPolicy 请求没有带proxyHeader时的处理方式，都会上报not_carry_vmid_req
+type MissingHeaderPolicy int
+
+const (
+	MissingHeaderPass    MissingHeaderPolicy = iota // 按PortRoute、UpstreamRoute和proxyPort正常转发
+	MissingHeaderReject                             // 返回400
+	MissingHeaderDefault                            // 转发到HeaderRoutes.Default
+)
+
+// HeaderTarget 请求头路由的目标，Upstream不为空时转发到上游，否则转发到本地地址的Port
+type HeaderTarget struct {
+	Port     int
+	Upstream *Upstream
+}
+
+func (t HeaderTarget) valid() bool {
+	return t.Upstream != nil || t.Port > 0
+}
+
+// HeaderRoutes 按ResetProxyHeader设置的请求头(比如vmid)路由
+type HeaderRoutes struct {
+	Targets map[string]HeaderTarget // 请求头的值对应的目标
+	Default HeaderTarget            // 值没有匹配的目标或者Missing是MissingHeaderDefault时使用，为空时按PortRoute等正常转发
+	Missing MissingHeaderPolicy
+}
+
+// SetHeaderRoutes 替换请求头路由规则，proxyHeader为空时不生效
+func (p *ReverseProxy) SetHeaderRoutes(routes HeaderRoutes) {
+	targets := make(map[string]HeaderTarget, len(routes.Targets))
+	for value, target := range routes.Targets {
+		targets[value] = target
+	}
+	routes.Targets = targets
+	p.mutex.Lock()
+	p.headerRoutes = routes
+	p.mutex.Unlock()
+}
+
+// headerTarget 按请求头选择目标，没有目标时返回false
+func (p *ReverseProxy) headerTarget(r *http.Request) (HeaderTarget, bool, error) {
+	p.mutex.RLock()
+	header, routes := p.proxyHeader, p.headerRoutes
+	p.mutex.RUnlock()
+	if len(header) == 0 {
+		return HeaderTarget{}, false, nil
+	}
+	value := r.Header.Get(header)
+	if len(value) == 0 {
+		ReportNotCarryVmidFromHeader(r.Method, routeHandler(r))
+		switch routes.Missing {
+		case MissingHeaderReject:
+			return HeaderTarget{}, false, &HookError{Status: http.StatusBadRequest, Message: fmt.Sprintf("missing header %s", header)}
+		case MissingHeaderDefault:
+			return routes.Default, routes.Default.valid(), nil
+		}
+		return HeaderTarget{}, false, nil
+	}
+	if target, ok := routes.Targets[value]; ok && target.valid() {
+		return target, true, nil
+	}
+	return routes.Default, routes.Default.valid(), nil
+}
+
+// routeHandler 监控用的handler标签，用mux路由的名称或者路径模板，不用请求路径避免标签无限增长
+func routeHandler(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "proxy"
+	}
+	if name := route.GetName(); len(name) > 0 {
+		return name
+	}
+	if template, err := route.GetPathTemplate(); err == nil {
+		return template
+	}
+	return "proxy"
+}
+
+// route 选择转发的上游或者本地端口，请求头路由优先，然后是UpstreamRoute和PortRoute
+func (p *ReverseProxy) route(ctx context.Context, r *http.Request, pc *Context) (int32, error) {
+	target, ok, err := p.headerTarget(r)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		if target.Upstream != nil {
+			pc.upstream = target.Upstream
+			return 0, nil
+		}
+		return int32(target.Port), nil
+	}
+	if p.upstreamRoute != nil {
+		if pc.upstream = p.upstreamRoute(ctx, r); pc.upstream != nil {
+			return 0, nil
+		}
+	}
+	return p.destPort(ctx, r), nil
+}
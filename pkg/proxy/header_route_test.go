@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHeaderRoutes(t *testing.T) {
+	var status atomic.Int32
+	fallback := namedBackend(t, "fallback", &status)
+	port := namedBackend(t, "port", &status)
+	pool := namedBackend(t, "pool", &status)
+	upstream, _ := NewUpstream("pool", []string{pool.Listener.Addr().String()}, UpstreamOptions{})
+	proxy, front := newTestProxy(t, fallback)
+	proxy.ResetProxyHeader("X-Vmid")
+	routes := HeaderRoutes{Targets: map[string]HeaderTarget{
+		"1": {Port: backendPort(t, port)},
+		"2": {Upstream: upstream},
+	}}
+	proxy.SetHeaderRoutes(routes)
+	do := func(vmid string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, front.URL+"/route", nil)
+		if len(vmid) > 0 {
+			req.Header.Set("X-Vmid", vmid)
+		}
+		resp, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	missing := func() float64 {
+		return testutil.ToFloat64(notCarryVmidFromHeader.WithLabelValues(http.MethodGet, "proxy"))
+	}
+
+	for vmid, want := range map[string]string{"1": "port", "2": "pool", "3": "fallback", "": "fallback"} {
+		if code, body := do(vmid); code != http.StatusOK || body != want {
+			t.Fatalf("vmid %q: got %d %s, want %s", vmid, code, body, want)
+		}
+	}
+	count := missing()
+	if count == 0 {
+		t.Fatal("expected missing header to be counted")
+	}
+
+	routes.Missing = MissingHeaderReject
+	proxy.SetHeaderRoutes(routes)
+	if code, _ := do(""); code != http.StatusBadRequest {
+		t.Fatalf("expected missing header to be rejected, got %d", code)
+	}
+	routes.Missing = MissingHeaderDefault
+	routes.Default = HeaderTarget{Upstream: upstream}
+	proxy.SetHeaderRoutes(routes)
+	if code, body := do(""); code != http.StatusOK || body != "pool" {
+		t.Fatalf("expected missing header to use default route, got %d %s", code, body)
+	}
+	if code, body := do("3"); code != http.StatusOK || body != "pool" {
+		t.Fatalf("expected unknown vmid to use default route, got %d %s", code, body)
+	}
+	if got := missing(); got != count+2 {
+		t.Fatalf("got missing count %v, want %v", got, count+2)
+	}
+
+	// 没有配置请求头时不按请求头路由，也不上报
+	proxy.ResetProxyHeader("")
+	if code, body := do("1"); code != http.StatusOK || body != "fallback" {
+		t.Fatalf("unexpected response without proxy header %d %s", code, body)
+	}
+	do("")
+	if missing() != count+2 {
+		t.Fatal("expected no missing header metric without proxy header")
+	}
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RequestHook 转发前执行，可以修改请求头和请求体，返回错误时不再转发，错误是*HookError时按它的状态码响应
+type RequestHook func(ctx *Context) error
+
+// ResponseHook 收到后端响应后执行，可以修改状态码、响应头和响应体
+type ResponseHook func(ctx *Context) error
+
+// Hook 一组匹配同一个路径的钩子
+type Hook struct {
+	Name    string
+	Pattern string   // gorilla/mux的路径模板，比如 /games/{gid}/start，为空时匹配所有路径
+	Methods []string // 为空时匹配所有方法
+	// Order 小的请求钩子先执行，响应钩子按相反的顺序执行，Order相同时按注册顺序
+	Order int
+	// KeepRequestBody 响应钩子需要读取请求体时设置，请求体会被读到内存里
+	KeepRequestBody bool
+	OnRequest       RequestHook
+	OnResponse      ResponseHook
+}
+
+// HookError 钩子返回的错误，代理按Status响应，Message作为响应体
+type HookError struct {
+	Status  int
+	Message string
+	Header  http.Header // 额外的响应头
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+type registeredHook struct {
+	Hook
+	seq   int
+	route *mux.Route
+}
+
+type contextKey struct{}
+
+// Use 注册钩子，Pattern不是合法的路径模板时返回错误
+func (p *ReverseProxy) Use(hook Hook) error {
+	route := mux.NewRouter().NewRoute()
+	if len(hook.Pattern) > 0 {
+		route = route.Path(hook.Pattern)
+	}
+	if len(hook.Methods) > 0 {
+		route = route.Methods(hook.Methods...)
+	}
+	if err := route.GetError(); err != nil {
+		return fmt.Errorf("hook %s pattern %s err:%w", hook.Name, hook.Pattern, err)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	hooks := append(append([]*registeredHook(nil), p.hooks...), &registeredHook{Hook: hook, seq: len(p.hooks), route: route})
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].Order < hooks[j].Order
+	})
+	p.hooks = hooks
+	return nil
+}
+
+// matchHooks 返回匹配请求的钩子和路径参数，多个钩子的路径参数合并
+func (p *ReverseProxy) matchHooks(r *http.Request) ([]*registeredHook, map[string]string) {
+	p.mutex.RLock()
+	hooks := p.hooks
+	p.mutex.RUnlock()
+	var matched []*registeredHook
+	vars := map[string]string{}
+	for _, hook := range hooks {
+		var match mux.RouteMatch
+		if !hook.route.Match(r, &match) {
+			continue
+		}
+		matched = append(matched, hook)
+		for key, value := range match.Vars {
+			vars[key] = value
+		}
+	}
+	return matched, vars
+}
+
+func keepRequestBody(hooks []*registeredHook) bool {
+	for _, hook := range hooks {
+		if hook.KeepRequestBody {
+			return true
+		}
+	}
+	return false
+}
+
+func (pc *Context) runRequestHooks() error {
+	for _, hook := range pc.hooks {
+		if hook.OnRequest == nil {
+			continue
+		}
+		if err := hook.OnRequest(pc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pc *Context) runResponseHooks() error {
+	for index := len(pc.hooks) - 1; index >= 0; index-- {
+		hook := pc.hooks[index]
+		if hook.OnResponse == nil {
+			continue
+		}
+		if err := hook.OnResponse(pc); err != nil {
+			return fmt.Errorf("hook %s: %w", hook.Name, err)
+		}
+	}
+	return nil
+}
+
+func withProxyContext(r *http.Request, pc *Context) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), contextKey{}, pc))
+	pc.Context = r.Context()
+	pc.req = r
+	return r
+}
+
+func proxyContext(r *http.Request) (*Context, bool) {
+	pc, ok := r.Context().Value(contextKey{}).(*Context)
+	return pc, ok
+}
+
+// Request 正在转发的请求，请求钩子里修改请求头会转发给后端
+func (pc *Context) Request() *http.Request {
+	return pc.req
+}
+
+// Response 后端的响应，只有响应钩子里不为空
+func (pc *Context) Response() *http.Response {
+	return pc.resp
+}
+
+// Vars 路径模板里的参数
+func (pc *Context) Vars() map[string]string {
+	return pc.vars
+}
+
+// RequestBody 读取请求体到内存里，读取后请求体仍然会转发给后端
+func (pc *Context) RequestBody() ([]byte, error) {
+	if pc.req.GetBody == nil {
+		if err := keepBody(pc.req); err != nil {
+			return nil, err
+		}
+	}
+	reader, err := pc.req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// SetRequestBody 替换转发给后端的请求体
+func (pc *Context) SetRequestBody(body []byte) {
+	pc.req.Body = io.NopCloser(bytes.NewReader(body))
+	pc.req.ContentLength = int64(len(body))
+	pc.req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	pc.req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+// ResponseBody 读取响应体到内存里，读取后响应体仍然会返回给客户端。
+// 有响应钩子的请求不会把Accept-Encoding转发给后端，后端仍然压缩时这里按gzip或deflate解压并去掉Content-Encoding，
+// 其他编码返回错误
+func (pc *Context) ResponseBody() ([]byte, error) {
+	body, err := io.ReadAll(pc.resp.Body)
+	pc.resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	pc.resp.Body = io.NopCloser(bytes.NewReader(body))
+	encoding := strings.ToLower(strings.TrimSpace(pc.resp.Header.Get("Content-Encoding")))
+	if len(encoding) == 0 || encoding == "identity" {
+		return body, nil
+	}
+	decoded, err := decodeBody(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+	pc.SetResponseBody(decoded)
+	return decoded, nil
+}
+
+// SetResponseBody 替换返回给客户端的响应体，body按未压缩处理，会去掉Content-Encoding
+func (pc *Context) SetResponseBody(body []byte) {
+	pc.resp.Body.Close()
+	pc.resp.Body = io.NopCloser(bytes.NewReader(body))
+	pc.resp.ContentLength = int64(len(body))
+	pc.resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	pc.resp.Header.Del("Content-Encoding")
+	pc.resp.TransferEncoding = nil
+}
+
+// hasResponseHook 有响应钩子时不让后端压缩响应体
+func hasResponseHook(hooks []*registeredHook) bool {
+	for _, hook := range hooks {
+		if hook.OnResponse != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %s", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode %s body: %w", encoding, err)
+	}
+	defer reader.Close()
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s body: %w", encoding, err)
+	}
+	return decoded, nil
+}
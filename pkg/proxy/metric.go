@@ -30,7 +30,7 @@ var (
 			Name: "not_carry_vmid_req",
 			Help: "Total number of HTTP requests",
 		},
-		[]string{"method", "handler", "clientIp"},
+		[]string{"method", "handler"},
 	)
 	otherRequestDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -40,6 +40,48 @@ var (
 		},
 		[]string{"handler", "code"},
 	)
+	tunnelBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_tunnel_bytes_total",
+			Help: "Total bytes copied through websocket and CONNECT tunnels",
+		},
+		[]string{"kind", "direction"},
+	)
+	rateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_rate_limited_total",
+			Help: "Total number of HTTP requests rejected by rate limits",
+		},
+		[]string{"limit"},
+	)
+	breakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_circuit_breaker_state",
+			Help: "Circuit breaker state of upstreams, 0 closed, 1 open, 2 half open",
+		},
+		[]string{"upstream"},
+	)
+	cacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_cache_requests_total",
+			Help: "Total number of HTTP requests handled by the response cache",
+		},
+		[]string{"rule", "result"},
+	)
+	cacheBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_cache_bytes",
+			Help: "Bytes of cached responses",
+		},
+		[]string{"tier"},
+	)
+	tunnelsActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_tunnels_active",
+			Help: "Number of open websocket and CONNECT tunnels",
+		},
+		[]string{"kind"},
+	)
 )
 
 func init() {
@@ -48,6 +90,12 @@ func init() {
 	prometheus.MustRegister(notCarryVmidFromHeader)
 	prometheus.MustRegister(requestDuration)
 	prometheus.MustRegister(otherRequestDuration)
+	prometheus.MustRegister(tunnelBytes)
+	prometheus.MustRegister(rateLimited)
+	prometheus.MustRegister(breakerState)
+	prometheus.MustRegister(cacheRequests)
+	prometheus.MustRegister(cacheBytes)
+	prometheus.MustRegister(tunnelsActive)
 }
 
 // PrometheusMiddleware 监控中间件
@@ -80,8 +128,20 @@ func (prw *promResponseWriter) WriteHeader(code int) {
 	prw.ResponseWriter.WriteHeader(code)
 }
 
-func ReportNotCarryVmidFromHeader(method, handler, clientIp string) {
-	notCarryVmidFromHeader.WithLabelValues(method, handler, clientIp).Inc()
+// Unwrap 让http.ResponseController能劫持websocket连接
+func (prw *promResponseWriter) Unwrap() http.ResponseWriter {
+	return prw.ResponseWriter
+}
+
+// ReportNotCarryVmidFromHeader 上报没有带proxyHeader的请求，handler要用路由名称或模板，非标准的方法归为OTHER
+func ReportNotCarryVmidFromHeader(method, handler string) {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+	default:
+		method = "OTHER"
+	}
+	notCarryVmidFromHeader.WithLabelValues(method, handler).Inc()
 }
 func ReportOtherRequestDuration(handler string, code int, start time.Time) {
 	otherRequestDuration.WithLabelValues(handler, fmt.Sprintf("%d", code)).Observe(time.Since(start).Seconds())
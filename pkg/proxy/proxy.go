@@ -4,27 +4,42 @@ import (
 	"accumulation/pkg/log"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"go.opentelemetry.io/otel/propagation"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
+	"sync"
 	"time"
 
 	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
+// Context 一个请求在钩子之间共享的上下文
 type Context struct {
 	context.Context
 	req        *http.Request
 	queryCache url.Values
 	resp       *http.Response
+	hooks      []*registeredHook
+	vars       map[string]string
+	upstream   *Upstream
+	cache      *Cache
+	cacheRule  *cacheRule
+	cacheScope string // 路由请求头的值，不同的值不共用缓存
+	backend    backendRecorder
+	err        error // 转发失败的原因，用于访问日志
 }
 
+// Bind 解析请求体，响应钩子里只有设置了KeepRequestBody的路径才能读取请求体
 func (pc *Context) Bind(binding Unmarshaler) error {
+	if pc.req.GetBody == nil {
+		return errors.New("request body is not kept")
+	}
 	reader, err := pc.req.GetBody()
 	if err != nil {
 		return err
@@ -63,35 +78,131 @@ type Unmarshaler interface {
 type PostFunc func(ctx *Context) error
 
 type ReverseProxy struct {
-	tracer      oteltrace.Tracer
-	postFuncs   map[string]PostFunc
-	proxyPort   int32
-	proxyHeader string
-	portRoute   PortRoute
+	tracer        oteltrace.Tracer
+	hooks         []*registeredHook // 按Order排序
+	rateLimits    []*rateLimiter    // 按注册顺序
+	proxyPort     int32
+	proxyHeader   string
+	portRoute     PortRoute
+	upstreamRoute UpstreamRoute
+	headerRoutes  HeaderRoutes
+	cache         *Cache
+	cacheRules    []*cacheRule
+	mutex         sync.RWMutex
+	transport     http.RoundTripper
+	proxies       map[string]*httputil.ReverseProxy // 按后端地址缓存，避免每个请求都创建
+	maxBodySize   int64                             // 请求体的最大字节数，0不限制
+	logBodySize   int                               // 日志里打印的请求体字节数
+	redactor      *regexp.Regexp
+	// websocket和CONNECT隧道
+	tunnelIdleTimeout time.Duration
+	connectPolicy     ConnectPolicy
+	accessLog         AccessLogOptions
 }
 
 func NewReverseProxy(tracer oteltrace.Tracer) *ReverseProxy {
-	return &ReverseProxy{tracer: tracer, postFuncs: make(map[string]PostFunc)}
+	return &ReverseProxy{
+		tracer:            tracer,
+		transport:         NewTransport(TransportOptions{}),
+		proxies:           make(map[string]*httputil.ReverseProxy),
+		redactor:          newRedactor(defaultRedactKeys),
+		tunnelIdleTimeout: defaultTunnelIdleTimeout,
+		accessLog:         AccessLogOptions{SampleRate: 1, Logger: defaultAccessLogger},
+	}
+}
+
+// SetMaxBodySize 请求体超过maxBodySize时返回413，0不限制
+func (p *ReverseProxy) SetMaxBodySize(maxBodySize int64) {
+	p.maxBodySize = maxBodySize
+}
+
+// SetBodyLog 访问日志里最多打印请求体的前limit个字节，redactKeys字段的值被隐藏，默认limit为0不打印请求体
+func (p *ReverseProxy) SetBodyLog(limit int, redactKeys ...string) {
+	p.logBodySize = limit
+	p.redactor = newRedactor(redactKeys)
+}
+
+// SetTransportOptions 替换转发用的连接池，正在处理的请求继续使用旧的连接，旧连接池的空闲连接被关闭
+func (p *ReverseProxy) SetTransportOptions(opts TransportOptions) {
+	p.mutex.Lock()
+	old := p.transport
+	p.transport = NewTransport(opts)
+	p.mutex.Unlock()
+	closeIdleConnections(old)
+}
+
+// proxyFor 返回后端地址对应的代理，Transport是ReverseProxy自己，转发时使用当前的连接池
+func (p *ReverseProxy) proxyFor(host string) *httputil.ReverseProxy {
+	p.mutex.RLock()
+	proxy, ok := p.proxies[host]
+	p.mutex.RUnlock()
+	if ok {
+		return proxy
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if proxy, ok = p.proxies[host]; ok {
+		return proxy
+	}
+	proxy = httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: host})
+	proxy.Transport = p
+	proxy.ErrorHandler = handlerError
+	p.proxies[host] = proxy
+	return proxy
+}
+
+func (p *ReverseProxy) currentTransport() http.RoundTripper {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.transport
 }
 
 func (p *ReverseProxy) SetPortRoute(portRoute PortRoute) {
 	p.portRoute = portRoute
 }
+
+// destPort 本地地址上转发的端口，PortRoute没有返回端口时使用proxyPort
+func (p *ReverseProxy) destPort(ctx context.Context, r *http.Request) int32 {
+	if p.portRoute != nil {
+		if port := p.portRoute(ctx, r); port > 0 {
+			return int32(port)
+		}
+	}
+	return p.proxyPort
+}
+
+// SetUpstreamRoute 设置后请求优先转发到返回的上游，返回nil时仍然按本地地址和端口转发
+func (p *ReverseProxy) SetUpstreamRoute(upstreamRoute UpstreamRoute) {
+	p.upstreamRoute = upstreamRoute
+}
 func (p *ReverseProxy) ResetProxyPort(proxyPort int32) {
 	p.proxyPort = proxyPort
 }
 
+// ResetProxyHeader 设置路由用的请求头，见SetHeaderRoutes
 func (p *ReverseProxy) ResetProxyHeader(proxyHeader string) {
+	p.mutex.Lock()
 	p.proxyHeader = proxyHeader
+	p.mutex.Unlock()
 }
 
+// RegisterPostHandler 后端返回200之后执行post，post可以读取请求体和响应体，返回错误时响应502
 func (p *ReverseProxy) RegisterPostHandler(path string, post PostFunc) {
-	p.postFuncs[path] = post
+	err := p.Use(Hook{Name: path, Pattern: path, KeepRequestBody: true, OnResponse: func(ctx *Context) error {
+		if ctx.resp.StatusCode != http.StatusOK {
+			return nil
+		}
+		return post(ctx)
+	}})
+	if err != nil {
+		log.Errorf(context.TODO(), "register post handler %s failure err:%v", path, err)
+	}
 }
 
-func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	start := time.Now().Unix()
-	ctx := r.Context()
+func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	w := &accessWriter{ResponseWriter: rw}
+	entry := &AccessLogEntry{Method: r.Method, Path: r.URL.Path, Route: r.URL.Path}
 	// 从 Request 中获取 TCP 连接信息
 	host := r.Host
 	if tcpConn, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
@@ -102,49 +213,164 @@ func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if p.tracer != nil {
-		propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(r.Header))
+		// 先创建代理的span，转发时在RoundTrip里创建子span注入到请求头
 		var span oteltrace.Span
-		ctx, span = p.tracer.Start(ctx, "proxy")
-		defer span.End()
+		r, span = p.startServerSpan(r)
+		defer func() {
+			endServerSpan(span, entry.Route, entry.Status)
+		}()
+	}
+	ctx := r.Context()
+	defer func() {
+		entry.Status = w.statusCode(r)
+		entry.BytesOut = w.bytes
+		entry.Latency = time.Since(start)
+		p.writeAccessLog(ctx, r, entry)
+	}()
+	if r.Method == http.MethodConnect {
+		entry.Backend = r.Host
+		p.serveConnect(w, r)
+		return
 	}
-
-	r = r.WithContext(ctx)
 	addr, err := getIPFromHost(host)
 	if err != nil {
+		entry.Err = err
 		handlerError(w, r, err)
 		return
 	}
-	body, err := io.ReadAll(r.Body)
+	if p.maxBodySize > 0 {
+		if r.ContentLength > p.maxBodySize {
+			entry.Err = &http.MaxBytesError{Limit: p.maxBodySize}
+			handlerError(w, r, entry.Err)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, p.maxBodySize)
+	}
+	hooks, vars := p.matchHooks(r)
+	pc := &Context{hooks: hooks, vars: vars}
+	pc.cache, pc.cacheRule = p.matchCacheRule(r)
+	if pc.cache != nil {
+		p.mutex.RLock()
+		header := p.proxyHeader
+		p.mutex.RUnlock()
+		if len(header) > 0 {
+			pc.cacheScope = r.Header.Get(header)
+		}
+	}
+	r = withProxyContext(r, pc)
+	for _, hook := range hooks {
+		if len(hook.Pattern) > 0 {
+			entry.Route = hook.Pattern
+			break
+		}
+	}
+	if err = p.checkRateLimits(r); err != nil {
+		entry.Err = err
+		handlerError(w, r, err)
+		return
+	}
+	// 请求体默认直接转发，响应钩子需要解析请求体时才读到内存里
+	if keepRequestBody(hooks) {
+		if err = keepBody(r); err != nil {
+			entry.Err = err
+			handlerError(w, r, err)
+			return
+		}
+	}
+	// 响应钩子要读取未压缩的响应体
+	if hasResponseHook(hooks) {
+		r.Header.Del("Accept-Encoding")
+	}
+	tee := newBodyTee(r.Body, p.logBodySize)
+	r.Body = tee
+	defer func() {
+		entry.BytesIn = tee.total
+		entry.Backend = pc.backend.get()
+		if pc.upstream != nil {
+			entry.Upstream = pc.upstream.Name
+		}
+		if p.logBodySize > 0 {
+			entry.Body = tee.String(p.redactor)
+		}
+		if entry.Err == nil {
+			entry.Err = pc.err
+		}
+	}()
+	if err = pc.runRequestHooks(); err != nil {
+		entry.Err = err
+		handlerError(w, r, err)
+		return
+	}
+	if r.Body != tee {
+		// 请求钩子替换了请求体
+		tee = newBodyTee(r.Body, p.logBodySize)
+		r.Body = tee
+	}
+	log.Debugf(ctx, "receive request path:%s host:%s RequestURI:%s RemoteAddr:%s", r.URL.Path, r.Host, r.RequestURI, r.RemoteAddr)
+	destPort, err := p.route(ctx, r, pc)
 	if err != nil {
+		entry.Err = err
 		handlerError(w, r, err)
 		return
 	}
-	log.Infof(ctx, "receive   request  path is  %s,%s ,RequestURI:%s,RemoteAddr:%s,req_body:[%v]", r.URL.Path, r.Host, r.RequestURI, r.RemoteAddr, string(body))
-	r.GetBody = func() (io.ReadCloser, error) {
-		reader := bytes.NewReader(body)
-		return io.NopCloser(reader), nil
+	if isUpgrade(r) {
+		// 升级请求不经过RoundTrip，响应钩子不执行
+		target := net.JoinHostPort(addr, fmt.Sprint(destPort))
+		var tlsConfig *tls.Config
+		var handshake func(*http.Response, error)
+		if pc.upstream != nil {
+			tlsConfig = pc.upstream.tlsConfig
+			backend, observe, release, err := pc.upstream.acquireUpgrade(r)
+			if err != nil {
+				entry.Err = err
+				handlerError(w, r, err)
+				return
+			}
+			defer release()
+			target, handshake = backend.Addr, observe
+		}
+		pc.backend.set(target)
+		p.serveUpgrade(w, r, target, tlsConfig, handshake)
+		return
 	}
-	destPort := p.proxyPort
-	if p.portRoute != nil {
-		port := p.portRoute(ctx, r)
-		if port > 0 {
-			destPort = int32(port)
+	var proxy *httputil.ReverseProxy
+	if pc.upstream != nil {
+		// 重试时需要重新发送请求体
+		if pc.upstream.opts.Retries > 0 && idempotent(r.Method) && r.ContentLength != 0 && r.GetBody == nil {
+			if err = keepBody(r); err != nil {
+				entry.Err = err
+				handlerError(w, r, err)
+				return
+			}
 		}
+		// 后端在RoundTrip里选择，这里的地址只用来缓存代理
+		proxy = p.proxyFor("upstream." + pc.upstream.Name)
+	} else {
+		proxy = p.proxyFor(net.JoinHostPort(addr, fmt.Sprint(destPort)))
 	}
-	proxy := httputil.NewSingleHostReverseProxy(&url.URL{
-		Scheme: "http",
-		Host:   fmt.Sprintf("%s:%d", addr, destPort),
-	})
-	proxy.Transport = p
-	reader := bytes.NewReader(body)
-	r.Body = io.NopCloser(reader)
 	proxy.ServeHTTP(w, r)
-	log.Infof(ctx, "path %s time cost: %d", r.URL.Path, time.Now().Unix()-start)
 }
 
 func (p *ReverseProxy) RoundTrip(req *http.Request) (*http.Response, error) {
 	start := time.Now()
-	resp, err := http.DefaultTransport.RoundTrip(req)
+	pc, ok := proxyContext(req)
+	next := p.currentTransport().RoundTrip
+	if ok {
+		next = p.backendTransport(pc, p.currentTransport())
+		if pc.upstream != nil {
+			transport := p.backendTransport(pc, pc.upstream.transportOr(p.currentTransport()))
+			next = func(req *http.Request) (*http.Response, error) {
+				return pc.upstream.roundTrip(transport, req)
+			}
+		}
+	}
+	var resp *http.Response
+	var err error
+	if ok && pc.cache != nil {
+		resp, err = pc.cache.roundTrip(pc.cacheRule, req, pc.cacheScope, next)
+	} else {
+		resp, err = next(req)
+	}
 	ReportOtherRequestDuration(req.RequestURI, func() int {
 		if resp == nil {
 			return 500
@@ -154,21 +380,31 @@ func (p *ReverseProxy) RoundTrip(req *http.Request) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		return resp, nil
-	}
-	handler, ok := p.postFuncs[req.URL.Path]
 	if !ok {
 		return resp, nil
 	}
-	err = handler(&Context{Context: req.Context(), req: req, resp: resp})
-	if err != nil {
+	pc.req, pc.resp = req, resp
+	if err = pc.runResponseHooks(); err != nil {
+		resp.Body.Close()
 		log.Errorf(req.Context(), "RoundTrip Failed to handle request: %s: %+v", req.URL.String(), err)
 		return nil, err
 	}
 	return resp, nil
 }
 
+// keepBody 把请求体读到内存里，GetBody可以重复读取
+func keepBody(r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
 func getIPFromHost(host string) (string, error) {
 	host, _, err := net.SplitHostPort(host)
 	if err != nil {
@@ -179,8 +415,22 @@ func getIPFromHost(host string) (string, error) {
 
 }
 func handlerError(w http.ResponseWriter, r *http.Request, err error) {
+	if pc, ok := proxyContext(r); ok {
+		pc.err = err
+	}
 	var statusCode int
+	var maxBytesError *http.MaxBytesError
+	var hookError *HookError
 	switch {
+	case errors.As(err, &hookError):
+		copyHeader(w.Header(), hookError.Header)
+		w.WriteHeader(hookError.Status)
+		io.WriteString(w, hookError.Message)
+		return
+	case errors.As(err, &maxBytesError):
+		statusCode = http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrNoAvailableBackend), errors.Is(err, ErrCircuitOpen):
+		statusCode = http.StatusServiceUnavailable
 	case errors.Is(err, context.Canceled),
 		err.Error() == "client disconnected":
 		statusCode = 499
@@ -193,4 +443,8 @@ func handlerError(w http.ResponseWriter, r *http.Request, err error) {
 	w.WriteHeader(statusCode)
 }
 
+// PortRoute 按请求选择后端端口，请求体还没有读取，不能在这里读取请求体
 type PortRoute func(context context.Context, req *http.Request) int
+
+// UpstreamRoute 选择请求转发的上游，和PortRoute一样不能读取请求体
+type UpstreamRoute func(context context.Context, req *http.Request) *Upstream
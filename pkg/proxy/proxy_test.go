@@ -0,0 +1,456 @@
+package proxy
+
+import (
+	"accumulation/pkg/log"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	klog "github.com/go-kratos/kratos/v2/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestMain(m *testing.M) {
+	// 每个请求都会打印日志，测试里不输出
+	log.SetLogger(klog.NewStdLogger(io.Discard))
+	os.Exit(m.Run())
+}
+
+// newBackend 启动一个后端，统计新建的连接数和请求使用的协议
+func newBackend(t testing.TB, h2 bool) (*httptest.Server, *atomic.Int32, *sync.Map) {
+	var conns atomic.Int32
+	protos := &sync.Map{}
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos.Store(r.Proto, true)
+		io.WriteString(w, "ok")
+	})
+	if h2 {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	backend := httptest.NewUnstartedServer(handler)
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	backend.Start()
+	t.Cleanup(backend.Close)
+	return backend, &conns, protos
+}
+
+// newTestProxy 代理和后端都监听在127.0.0.1，代理按本地地址转发到proxyPort
+func newTestProxy(t testing.TB, backend *httptest.Server) (*ReverseProxy, *httptest.Server) {
+	proxy := NewReverseProxy(nil)
+	proxy.ResetProxyPort(int32(backendPort(t, backend)))
+	front := httptest.NewServer(proxy)
+	t.Cleanup(front.Close)
+	return proxy, front
+}
+
+func backendPort(t testing.TB, backend *httptest.Server) int {
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return port
+}
+
+// get 会在多个goroutine里调用，失败时只记录错误
+func get(t testing.TB, client *http.Client, url string) {
+	resp, err := client.Post(url, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("unexpected response %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestReverseProxyReusesConnections(t *testing.T) {
+	backend, conns, _ := newBackend(t, false)
+	proxy, front := newTestProxy(t, backend)
+	client := front.Client()
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				get(t, client, front.URL+"/path")
+			}
+		}()
+	}
+	wg.Wait()
+	if got := conns.Load(); got > 8 {
+		t.Fatalf("expected connections to backend to be reused, got %d", got)
+	}
+	if len(proxy.proxies) != 1 {
+		t.Fatalf("expected one cached proxy, got %d", len(proxy.proxies))
+	}
+
+	// 替换连接池后使用新的连接
+	proxy.SetTransportOptions(TransportOptions{MaxIdleConnsPerHost: 1})
+	before := conns.Load()
+	get(t, client, front.URL+"/path")
+	if conns.Load() != before+1 {
+		t.Fatalf("expected new transport to dial")
+	}
+}
+
+func TestReverseProxyHTTP2(t *testing.T) {
+	backend, conns, protos := newBackend(t, true)
+	proxy, front := newTestProxy(t, backend)
+	proxy.SetTransportOptions(TransportOptions{HTTP2: true})
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				get(t, front.Client(), front.URL+"/path")
+			}
+		}()
+	}
+	wg.Wait()
+	if _, ok := protos.Load("HTTP/2.0"); !ok {
+		t.Fatalf("expected backend to receive HTTP/2 requests")
+	}
+	if got := conns.Load(); got != 1 {
+		t.Fatalf("expected one multiplexed connection, got %d", got)
+	}
+}
+
+func TestHTTP2TLSTransport(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(backend.Certificate())
+	transport := NewTransport(TransportOptions{HTTP2: true, TLS: &tls.Config{RootCAs: pool}})
+	resp, err := (&http.Client{Transport: transport}).Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "HTTP/2.0" {
+		t.Fatalf("expected HTTP/2, got %s", body)
+	}
+
+	// 后端不完成tls握手时按TLSHandshakeTimeout超时
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	transport = NewTransport(TransportOptions{HTTP2: true, TLS: &tls.Config{RootCAs: pool}, TLSHandshakeTimeout: 100 * time.Millisecond})
+	start := time.Now()
+	if _, err = (&http.Client{Transport: transport}).Get("https://" + listener.Addr().String()); err == nil || time.Since(start) > 5*time.Second {
+		t.Fatalf("expected handshake timeout, got %v after %s", err, time.Since(start))
+	}
+}
+
+// legacyHandler 旧的实现：每个请求创建一个代理，使用http.DefaultTransport的默认连接池配置
+func legacyHandler(target string) http.Handler {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: target})
+		proxy.Transport = transport
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+func benchmarkProxy(b *testing.B, front *httptest.Server) {
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 256}}
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			get(b, client, front.URL+"/path")
+		}
+	})
+}
+
+func BenchmarkReverseProxyLegacy(b *testing.B) {
+	backend, conns, _ := newBackend(b, false)
+	front := httptest.NewServer(legacyHandler(fmt.Sprintf("127.0.0.1:%d", backendPort(b, backend))))
+	defer front.Close()
+	benchmarkProxy(b, front)
+	b.ReportMetric(float64(conns.Load()), "conns")
+}
+
+func BenchmarkReverseProxyPooled(b *testing.B) {
+	backend, conns, _ := newBackend(b, false)
+	_, front := newTestProxy(b, backend)
+	benchmarkProxy(b, front)
+	b.ReportMetric(float64(conns.Load()), "conns")
+}
+
+type lengthBackend struct {
+	received atomic.Int64
+}
+
+func (b *lengthBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n, _ := io.Copy(io.Discard, r.Body)
+	b.received.Store(n)
+	fmt.Fprint(w, n)
+}
+
+type jsonBody map[string]interface{}
+
+func (b *jsonBody) Unmarshal(data []byte) error {
+	return json.Unmarshal(data, b)
+}
+
+func TestReverseProxyBody(t *testing.T) {
+	handler := &lengthBackend{}
+	backend := httptest.NewServer(handler)
+	defer backend.Close()
+	proxy, front := newTestProxy(t, backend)
+	proxy.SetMaxBodySize(1 << 20)
+	var bound jsonBody
+	proxy.RegisterPostHandler("/bind", func(ctx *Context) error {
+		return ctx.Bind(&bound)
+	})
+	post := func(path string, body io.Reader) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, front.URL+path, body)
+		resp, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	// 没有Content-Length的请求体直接转发
+	if code, body := post("/upload", io.MultiReader(strings.NewReader(strings.Repeat("a", 512<<10)))); code != http.StatusOK || body != "524288" {
+		t.Fatalf("unexpected streaming response %d %s", code, body)
+	}
+	if code, _ := post("/upload", strings.NewReader(strings.Repeat("a", 2<<20))); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected content length over limit to be rejected, got %d", code)
+	}
+	if code, _ := post("/upload", io.MultiReader(strings.NewReader(strings.Repeat("a", 2<<20)))); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected streaming body over limit to be rejected, got %d", code)
+	}
+	if code, _ := post("/bind", strings.NewReader(`{"vmid":7}`)); code != http.StatusOK || bound["vmid"] != float64(7) {
+		t.Fatalf("expected post handler to bind body, got %d %v", code, bound)
+	}
+}
+
+func TestBodyTee(t *testing.T) {
+	tee := newBodyTee(io.NopCloser(strings.NewReader(`{"user":"a","Password" : "p\"w","token":"t","data":"0123456789"}`)), 48)
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"user":"a","Password" : "***","token":"***","dat...(truncated, 64 bytes)`
+	if got := tee.String(newRedactor(defaultRedactKeys)); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	// 截断在密码中间时，没有结束引号的部分也要隐藏
+	for limit, want := range map[int]string{
+		18: `{"password":"***"...(truncated, 30 bytes)`,
+		16: `{"password":"***"...(truncated, 30 bytes)`,
+		22: `{"password":"***"...(truncated, 30 bytes)`,
+		26: `{"password":"***","u...(truncated, 30 bytes)`,
+	} {
+		tee = newBodyTee(io.NopCloser(strings.NewReader(`{"password":"hunt\"er2","u":1}`)), limit)
+		io.Copy(io.Discard, tee)
+		if got := tee.String(newRedactor(defaultRedactKeys)); got != want {
+			t.Fatalf("limit %d: got %s, want %s", limit, got, want)
+		}
+	}
+}
+
+// echoBackend 把请求头X-User和请求体写回响应
+type echoBackend struct{}
+
+func (echoBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("X-Backend", "echo")
+	fmt.Fprintf(w, "%s|%s", r.Header.Get("X-User"), body)
+}
+
+func TestReverseProxyHooks(t *testing.T) {
+	backend := httptest.NewServer(echoBackend{})
+	defer backend.Close()
+	proxy, front := newTestProxy(t, backend)
+	var order []string
+	var mutex sync.Mutex
+	trace := func(name string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		order = append(order, name)
+	}
+	hooks := []Hook{
+		{Name: "auth", Order: 1, Pattern: "/games/{gid:[0-9]+}/start", OnRequest: func(ctx *Context) error {
+			trace("auth")
+			if ctx.Request().Header.Get("Authorization") != "token" {
+				return &HookError{Status: http.StatusUnauthorized, Message: "unauthorized"}
+			}
+			ctx.Request().Header.Set("X-User", "gid-"+ctx.Vars()["gid"])
+			return nil
+		}, OnResponse: func(ctx *Context) error {
+			trace("auth-resp")
+			return nil
+		}},
+		{Name: "rewrite", Order: 2, Pattern: "/games/{gid}/start", Methods: []string{http.MethodPost}, OnRequest: func(ctx *Context) error {
+			trace("rewrite")
+			body, err := ctx.RequestBody()
+			if err != nil {
+				return err
+			}
+			ctx.SetRequestBody([]byte(strings.ToUpper(string(body))))
+			return nil
+		}, OnResponse: func(ctx *Context) error {
+			trace("rewrite-resp")
+			body, err := ctx.ResponseBody()
+			if err != nil {
+				return err
+			}
+			ctx.Response().StatusCode = http.StatusAccepted
+			ctx.Response().Header.Del("X-Backend")
+			ctx.SetResponseBody([]byte("rewritten:" + string(body)))
+			return nil
+		}},
+		{Name: "first", Order: 0, OnRequest: func(ctx *Context) error {
+			trace("first")
+			return nil
+		}},
+	}
+	for _, hook := range hooks {
+		if err := proxy.Use(hook); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := proxy.Use(Hook{Name: "bad", Pattern: "/games/{gid"}); err == nil {
+		t.Fatal("expected invalid pattern to be rejected")
+	}
+	do := func(method, path, auth string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, front.URL+path, strings.NewReader("hello"))
+		req.Header.Set("Authorization", auth)
+		resp, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	resp, body := do(http.MethodPost, "/games/12/start", "token")
+	if resp.StatusCode != http.StatusAccepted || body != "rewritten:gid-12|HELLO" || resp.Header.Get("X-Backend") != "" {
+		t.Fatalf("unexpected rewritten response %d %s %v", resp.StatusCode, body, resp.Header)
+	}
+	want := []string{"first", "auth", "rewrite", "rewrite-resp", "auth-resp"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Fatalf("got order %v, want %v", order, want)
+	}
+
+	order = nil
+	if resp, body = do(http.MethodPost, "/games/12/start", ""); resp.StatusCode != http.StatusUnauthorized || body != "unauthorized" {
+		t.Fatalf("expected auth hook to reject, got %d %s", resp.StatusCode, body)
+	}
+	if fmt.Sprint(order) != "[first auth]" {
+		t.Fatalf("expected request to stop at auth hook, got %v", order)
+	}
+
+	// 方法不匹配时rewrite不执行，路径参数不匹配时auth也不执行
+	if resp, body = do(http.MethodPut, "/games/12/start", "token"); resp.StatusCode != http.StatusOK || body != "gid-12|hello" {
+		t.Fatalf("unexpected response for PUT %d %s", resp.StatusCode, body)
+	}
+	if resp, body = do(http.MethodPost, "/games/abc/start", ""); resp.StatusCode != http.StatusAccepted || body != "rewritten:|HELLO" {
+		t.Fatalf("unexpected response for non numeric gid %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestResponseHookEncoding(t *testing.T) {
+	var acceptEncoding atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding.Store(r.Header.Get("Accept-Encoding"))
+		// 不管Accept-Encoding都压缩
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		io.WriteString(zw, "plain")
+		zw.Close()
+	}))
+	t.Cleanup(backend.Close)
+	proxy, front := newTestProxy(t, backend)
+	proxy.Use(Hook{Name: "upper", Pattern: "/upper", OnResponse: func(ctx *Context) error {
+		body, err := ctx.ResponseBody()
+		if err != nil {
+			return err
+		}
+		ctx.SetResponseBody([]byte(strings.ToUpper(string(body))))
+		return nil
+	}})
+	req, _ := http.NewRequest(http.MethodGet, front.URL+"/upper", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	resp, err := front.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "PLAIN" || resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("unexpected response %q encoding %q", body, resp.Header.Get("Content-Encoding"))
+	}
+	if got := acceptEncoding.Load(); got == "gzip, br" {
+		t.Fatalf("expected client Accept-Encoding not to be forwarded, got %q", got)
+	}
+}
+
+func TestResponseHookError(t *testing.T) {
+	backend := httptest.NewServer(echoBackend{})
+	defer backend.Close()
+	proxy, front := newTestProxy(t, backend)
+	proxy.RegisterPostHandler("/fail", func(ctx *Context) error {
+		return fmt.Errorf("post failure")
+	})
+	proxy.Use(Hook{Name: "forbid", Pattern: "/forbid", OnResponse: func(ctx *Context) error {
+		return &HookError{Status: http.StatusForbidden, Message: "forbidden"}
+	}})
+	for path, code := range map[string]int{"/fail": http.StatusBadGateway, "/forbid": http.StatusForbidden, "/other": http.StatusOK} {
+		resp, err := front.Client().Get(front.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("%s: got %d, want %d", path, resp.StatusCode, code)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"accumulation/pkg/nnet"
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// clientBucketIdle 客户端的令牌桶空闲超过这个时间后删除
+	clientBucketIdle = 10 * time.Minute
+	// defaultMaxClients 默认最多保存的客户端令牌桶数
+	defaultMaxClients = 10000
+)
+
+// RateLimit 令牌桶限流，超过时返回429和Retry-After
+type RateLimit struct {
+	Name      string
+	Pattern   string   // gorilla/mux的路径模板，为空时匹配所有路径
+	Methods   []string // 为空时匹配所有方法
+	Rate      float64  // 每秒的请求数
+	Burst     int      // 令牌桶容量，默认向上取整的Rate
+	PerClient bool     // 按客户端ip分别限流，否则整个路由共用一个令牌桶
+	// TrustedProxies 可信代理的ip或CIDR，对端是可信代理时才用X-Forwarded-For等请求头(nnet.GetClientIP)里的客户端ip，
+	// 否则用RemoteAddr，避免客户端伪造请求头绕过限流
+	TrustedProxies []string
+	MaxClients     int // PerClient时最多保存的客户端令牌桶数，超过时淘汰最久没用的，默认10000
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take 取一个令牌，没有令牌时返回需要等待的时间
+func (b *tokenBucket) take(now time.Time, rate, burst float64) (bool, time.Duration) {
+	if wait := b.wait(now, rate, burst); wait > 0 {
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// wait 补充令牌，返回取到一个令牌需要等待的时间，有令牌时返回0
+func (b *tokenBucket) wait(now time.Time, rate, burst float64) time.Duration {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+type clientBucket struct {
+	client string
+	tokenBucket
+}
+
+type rateLimiter struct {
+	limit   RateLimit
+	match   *mux.Route
+	burst   float64
+	trusted []*net.IPNet
+	mutex   sync.Mutex
+	route   tokenBucket
+	order   *list.List // 前面是最近使用的客户端
+	clients map[string]*list.Element
+}
+
+func newRateLimiter(limit RateLimit) (*rateLimiter, error) {
+	if limit.Rate <= 0 {
+		return nil, fmt.Errorf("rate limit %s rate %v must be positive", limit.Name, limit.Rate)
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	if limit.MaxClients <= 0 {
+		limit.MaxClients = defaultMaxClients
+	}
+	trusted, err := parseTrustedProxies(limit.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit %s: %w", limit.Name, err)
+	}
+	match := mux.NewRouter().NewRoute()
+	if len(limit.Pattern) > 0 {
+		match = match.Path(limit.Pattern)
+	}
+	if len(limit.Methods) > 0 {
+		match = match.Methods(limit.Methods...)
+	}
+	if err = match.GetError(); err != nil {
+		return nil, fmt.Errorf("rate limit %s pattern %s err:%w", limit.Name, limit.Pattern, err)
+	}
+	return &rateLimiter{
+		limit:   limit,
+		match:   match,
+		burst:   float64(limit.Burst),
+		trusted: trusted,
+		route:   tokenBucket{tokens: float64(limit.Burst), last: time.Now()},
+		order:   list.New(),
+		clients: make(map[string]*list.Element),
+	}, nil
+}
+
+// parseTrustedProxies 解析ip或CIDR，单个ip按/32或/128处理
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			result = append(result, ipNet)
+			continue
+		}
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return result, nil
+}
+
+// clientKey 对端是可信代理时用请求头里的客户端ip，否则用RemoteAddr
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if ip := net.ParseIP(peer); ip != nil {
+		for _, ipNet := range l.trusted {
+			if ipNet.Contains(ip) {
+				if client := nnet.GetClientIP(r); len(client) > 0 {
+					return client
+				}
+				break
+			}
+		}
+	}
+	return peer
+}
+
+func (l *rateLimiter) allow(r *http.Request) (bool, time.Duration) {
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.bucket(r, now).take(now, l.limit.Rate, l.burst)
+}
+
+// bucket 返回请求使用的令牌桶，调用方持有mutex
+func (l *rateLimiter) bucket(r *http.Request, now time.Time) *tokenBucket {
+	if !l.limit.PerClient {
+		return &l.route
+	}
+	client := l.clientKey(r)
+	elem, ok := l.clients[client]
+	if ok {
+		l.order.MoveToFront(elem)
+	} else {
+		elem = l.order.PushFront(&clientBucket{client: client, tokenBucket: tokenBucket{tokens: l.burst, last: now}})
+		l.clients[client] = elem
+	}
+	// 从最久没用的开始淘汰超过MaxClients或者空闲超过clientBucketIdle的令牌桶
+	for back := l.order.Back(); back != nil && back != elem; back = l.order.Back() {
+		bucket := back.Value.(*clientBucket)
+		if l.order.Len() <= l.limit.MaxClients && now.Sub(bucket.last) < clientBucketIdle {
+			break
+		}
+		l.order.Remove(back)
+		delete(l.clients, bucket.client)
+	}
+	return &elem.Value.(*clientBucket).tokenBucket
+}
+
+// checkRateLimits 在请求钩子和读取请求体之前执行，匹配的规则都有令牌时才各取一个令牌，
+// 有规则没有令牌时返回429，其他规则的令牌不扣
+func (p *ReverseProxy) checkRateLimits(r *http.Request) error {
+	p.mutex.RLock()
+	limiters := p.rateLimits
+	p.mutex.RUnlock()
+	var matched []*rateLimiter
+	for _, limiter := range limiters {
+		var match mux.RouteMatch
+		if limiter.match.Match(r, &match) {
+			matched = append(matched, limiter)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	// 按注册顺序加锁，不会死锁
+	for _, limiter := range matched {
+		limiter.mutex.Lock()
+		defer limiter.mutex.Unlock()
+	}
+	now := time.Now()
+	buckets := make([]*tokenBucket, len(matched))
+	var wait time.Duration
+	for i, limiter := range matched {
+		buckets[i] = limiter.bucket(r, now)
+		if w := buckets[i].wait(now, limiter.limit.Rate, limiter.burst); w > 0 {
+			rateLimited.WithLabelValues(limiter.limit.Name).Inc()
+			wait = max(wait, w)
+		}
+	}
+	if wait == 0 {
+		for _, bucket := range buckets {
+			bucket.tokens--
+		}
+		return nil
+	}
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	return &HookError{
+		Status:  http.StatusTooManyRequests,
+		Message: "too many requests",
+		Header:  http.Header{"Retry-After": []string{strconv.Itoa(retryAfter)}},
+	}
+}
+
+// AddRateLimit 添加限流规则，限流在读取请求体和所有请求钩子之前执行，多个规则都匹配时都要有令牌
+func (p *ReverseProxy) AddRateLimit(limit RateLimit) error {
+	limiter, err := newRateLimiter(limit)
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rateLimits = append(append([]*rateLimiter(nil), p.rateLimits...), limiter)
+	return nil
+}
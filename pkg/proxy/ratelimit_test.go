@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := tokenBucket{tokens: 2, last: now}
+	for i := 0; i < 2; i++ {
+		if ok, _ := bucket.take(now, 10, 2); !ok {
+			t.Fatalf("expected token %d", i)
+		}
+	}
+	ok, wait := bucket.take(now, 10, 2)
+	if ok || wait != 100*time.Millisecond {
+		t.Fatalf("expected to wait 100ms, got %v %v", ok, wait)
+	}
+	if ok, _ = bucket.take(now.Add(100*time.Millisecond), 10, 2); !ok {
+		t.Fatal("expected token to be refilled")
+	}
+	// 令牌数不超过burst
+	if ok, _ = bucket.take(now.Add(time.Hour), 10, 2); !ok || bucket.tokens != 1 {
+		t.Fatalf("unexpected tokens %v", bucket.tokens)
+	}
+}
+
+func TestReverseProxyRateLimit(t *testing.T) {
+	backend, _, _ := newBackend(t, false)
+	proxy, front := newTestProxy(t, backend)
+	if err := proxy.AddRateLimit(RateLimit{Name: "games", Pattern: "/games/{gid}", Rate: 0.01, Burst: 2, PerClient: true, TrustedProxies: []string{"127.0.0.0/8"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := proxy.AddRateLimit(RateLimit{Name: "bad"}); err == nil {
+		t.Fatal("expected zero rate to be rejected")
+	}
+	do := func(path, client string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, front.URL+path, nil)
+		req.Header.Set("X-Forwarded-For", client)
+		resp, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	for i := 0; i < 2; i++ {
+		if resp := do("/games/1", "10.0.0.1"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, resp.StatusCode)
+		}
+	}
+	resp := do("/games/2", "10.0.0.1")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "100" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if resp = do("/games/1", "10.0.0.2"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected other client to have its own bucket, got %d", resp.StatusCode)
+	}
+	if resp = do("/other", "10.0.0.1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected other route not to be limited, got %d", resp.StatusCode)
+	}
+}
+
+func TestRateLimiterClients(t *testing.T) {
+	limiter, err := newRateLimiter(RateLimit{Name: "clients", Rate: 0.01, Burst: 1, PerClient: true, MaxClients: 2, TrustedProxies: []string{"10.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newRateLimiter(RateLimit{Name: "bad", Rate: 1, TrustedProxies: []string{"proxy"}}); err == nil {
+		t.Fatal("expected invalid trusted proxy to be rejected")
+	}
+	request := func(remote, xff string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set("X-Forwarded-For", xff)
+		return req
+	}
+	// 不可信的对端伪造X-Forwarded-For也按RemoteAddr限流
+	if ok, _ := limiter.allow(request("192.168.0.1", "1.1.1.1")); !ok {
+		t.Fatal("expected first request to pass")
+	}
+	if ok, _ := limiter.allow(request("192.168.0.1", "2.2.2.2")); ok {
+		t.Fatal("expected spoofed X-Forwarded-For to share the peer bucket")
+	}
+	// 可信代理转发时按X-Forwarded-For限流
+	if ok, _ := limiter.allow(request("10.0.0.1", "1.1.1.1")); !ok {
+		t.Fatal("expected forwarded client to have its own bucket")
+	}
+	if ok, _ := limiter.allow(request("10.0.0.1", "1.1.1.1")); ok {
+		t.Fatal("expected forwarded client to be limited")
+	}
+	// 超过MaxClients时淘汰最久没用的192.168.0.1
+	limiter.allow(request("192.168.0.2", ""))
+	if len(limiter.clients) != 2 || limiter.order.Len() != 2 {
+		t.Fatalf("expected 2 clients, got %d", len(limiter.clients))
+	}
+	if _, ok := limiter.clients["192.168.0.1"]; ok {
+		t.Fatal("expected least recently used client to be evicted")
+	}
+}
+
+func TestRateLimitAllRulesMustAllow(t *testing.T) {
+	backend, _, _ := newBackend(t, false)
+	proxy, front := newTestProxy(t, backend)
+	if err := proxy.AddRateLimit(RateLimit{Name: "all", Rate: 0.01, Burst: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := proxy.AddRateLimit(RateLimit{Name: "games", Pattern: "/games/{gid}", Rate: 0.01, Burst: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// 请求体钩子不影响限流，被拒绝的请求不读请求体
+	if err := proxy.Use(Hook{Name: "route", Pattern: "/games/{gid}", KeepRequestBody: true}); err != nil {
+		t.Fatal(err)
+	}
+	do := func(path string) int {
+		resp, err := front.Client().Post(front.URL+path, "text/plain", strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := do("/games/1"); code != http.StatusOK {
+		t.Fatalf("expected first request to pass, got %d", code)
+	}
+	// games没有令牌，all的令牌不扣
+	for i := 0; i < 3; i++ {
+		if code := do("/games/1"); code != http.StatusTooManyRequests {
+			t.Fatalf("request %d: expected 429, got %d", i, code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if code := do("/other"); code != http.StatusOK {
+			t.Fatalf("request %d: expected all to keep its tokens, got %d", i, code)
+		}
+	}
+	if code := do("/other"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected all to be exhausted, got %d", code)
+	}
+}
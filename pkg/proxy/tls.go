@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"accumulation/pkg/log"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultCertReloadInterval = time.Minute
+
+// CertFile pem格式的证书链和私钥
+type CertFile struct {
+	CertFile string
+	KeyFile  string
+}
+
+// TLSServerOptions tls终结的配置
+type TLSServerOptions struct {
+	Certificates   []CertFile // 按SNI选择证书，没有匹配时使用第一个
+	ClientCAFile   string     // 不为空时校验客户端证书
+	ClientAuth     tls.ClientAuthType
+	MinVersion     uint16        // 默认tls1.2
+	ReloadInterval time.Duration // 检查证书文件修改时间的周期，默认1分钟
+}
+
+// CertReloader 定时检查证书和客户端CA文件，修改后重新加载，新的连接使用新证书
+type CertReloader struct {
+	opts     TLSServerOptions
+	mutex    sync.RWMutex
+	certs    []*tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+	reload   sync.Mutex // 同时只有一个Reload
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewCertReloader(opts TLSServerOptions) (*CertReloader, error) {
+	if len(opts.Certificates) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = defaultCertReloadInterval
+	}
+	if opts.MinVersion == 0 {
+		opts.MinVersion = tls.VersionTLS12
+	}
+	if len(opts.ClientCAFile) > 0 && opts.ClientAuth == tls.NoClientCert {
+		opts.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	reloader := &CertReloader{opts: opts}
+	if _, err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *CertReloader) files() []string {
+	var files []string
+	for _, cert := range r.opts.Certificates {
+		files = append(files, cert.CertFile, cert.KeyFile)
+	}
+	if len(r.opts.ClientCAFile) > 0 {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+// Reload 文件修改过时重新加载，加载失败时继续使用旧的证书
+func (r *CertReloader) Reload() (bool, error) {
+	r.reload.Lock()
+	defer r.reload.Unlock()
+	modTimes := make(map[string]time.Time)
+	changed := r.modTimes == nil
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes[file] = info.ModTime()
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	var certs []*tls.Certificate
+	for _, file := range r.opts.Certificates {
+		cert, err := tls.LoadX509KeyPair(file.CertFile, file.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("load certificate %s err:%w", file.CertFile, err)
+		}
+		certs = append(certs, &cert)
+	}
+	var clientCA *x509.CertPool
+	if len(r.opts.ClientCAFile) > 0 {
+		pool, err := loadCertPool(r.opts.ClientCAFile)
+		if err != nil {
+			return false, err
+		}
+		clientCA = pool
+	}
+	r.mutex.Lock()
+	r.certs, r.clientCA, r.modTimes = certs, clientCA, modTimes
+	r.mutex.Unlock()
+	return true, nil
+}
+
+// Start 定时重新加载
+func (r *CertReloader) Start() {
+	if r.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.opts.ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			reloaded, err := r.Reload()
+			if err != nil {
+				log.Errorf(ctx, "reload certificate failure err:%v", err)
+			} else if reloaded {
+				log.Infof(ctx, "certificate reloaded")
+			}
+		}
+	}()
+}
+
+func (r *CertReloader) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	r.wg.Wait()
+	r.cancel = nil
+}
+
+// GetCertificate 按SNI选择证书
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	certs := r.certs
+	r.mutex.RUnlock()
+	if len(hello.ServerName) > 0 {
+		for _, cert := range certs {
+			if hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return certs[0], nil
+}
+
+// TLSConfig 服务端的tls配置，客户端CA和证书一样热加载
+func (r *CertReloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:     r.opts.MinVersion,
+		ClientAuth:     r.opts.ClientAuth,
+		GetCertificate: r.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mutex.RLock()
+		clientCA := r.clientCA
+		r.mutex.RUnlock()
+		if clientCA == nil {
+			return nil, nil
+		}
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.ClientCAs = clientCA
+		return config, nil
+	}
+	return base
+}
+
+// Server tls终结的http服务，证书热加载
+type Server struct {
+	*http.Server
+	reloader *CertReloader
+}
+
+func NewServer(addr string, handler http.Handler, opts TLSServerOptions) (*Server, error) {
+	reloader, err := NewCertReloader(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		Server:   &http.Server{Addr: addr, Handler: handler, TLSConfig: reloader.TLSConfig()},
+		reloader: reloader,
+	}, nil
+}
+
+// ListenAndServe 监听Addr并处理tls连接
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve 在listener上处理tls连接，直到Shutdown
+func (s *Server) Serve(listener net.Listener) error {
+	s.reloader.Start()
+	defer s.reloader.Stop()
+	return s.Server.ServeTLS(listener, "", "")
+}
+
+// UpstreamTLS 用https连接上游的配置，CertFile和KeyFile不为空时使用客户端证书(mTLS)
+type UpstreamTLS struct {
+	CAFile             string // 校验后端证书的CA，为空时使用系统CA
+	CertFile           string
+	KeyFile            string
+	ServerName         string // 为空时使用后端地址的host
+	InsecureSkipVerify bool
+}
+
+func (opts *UpstreamTLS) config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if len(opts.CAFile) > 0 {
+		pool, err := loadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if len(opts.CertFile) > 0 || len(opts.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate %s err:%w", opts.CertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	file := filepath.Join(dir, "ca.crt")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue 签发证书写到dir/name.crt和dir/name.key，hosts是ip时写到IPAddresses
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64, hosts ...string) CertFile {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := CertFile{CertFile: filepath.Join(dir, name+".crt"), KeyFile: filepath.Join(dir, name+".key")}
+	writePEM(t, file.CertFile, "CERTIFICATE", der)
+	writePEM(t, file.KeyFile, "EC PRIVATE KEY", keyDer)
+	return file
+}
+
+func writePEM(t *testing.T, file, kind string, der []byte) {
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func startTLSServer(t *testing.T, opts TLSServerOptions) (*Server, string) {
+	server, err := NewServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return server, listener.Addr().String()
+}
+
+func peerSerial(t *testing.T, addr, serverName string, roots *x509.CertPool) int64 {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLSServerSNIAndReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	a := ca.issue(t, dir, "a", 10, "a.test")
+	b := ca.issue(t, dir, "b", 20, "*.b.test")
+	server, addr := startTLSServer(t, TLSServerOptions{Certificates: []CertFile{a, b}})
+	for serverName, serial := range map[string]int64{"a.test": 10, "game.b.test": 20} {
+		if got := peerSerial(t, addr, serverName, ca.pool()); got != serial {
+			t.Fatalf("%s: got serial %d, want %d", serverName, got, serial)
+		}
+	}
+
+	ca.issue(t, dir, "a", 11, "a.test")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(a.CertFile, future, future)
+	if reloaded, err := server.reloader.Reload(); err != nil || !reloaded {
+		t.Fatalf("expected certificate to be reloaded, got %v %v", reloaded, err)
+	}
+	if got := peerSerial(t, addr, "a.test", ca.pool()); got != 11 {
+		t.Fatalf("expected reloaded certificate, got serial %d", got)
+	}
+	if reloaded, _ := server.reloader.Reload(); reloaded {
+		t.Fatal("expected unchanged files not to be reloaded")
+	}
+
+	// 加载失败时继续使用旧证书
+	os.WriteFile(a.KeyFile, []byte("broken"), 0o600)
+	os.Chtimes(a.KeyFile, future.Add(time.Minute), future.Add(time.Minute))
+	if _, err := server.reloader.Reload(); err == nil {
+		t.Fatal("expected broken key to fail")
+	}
+	if got := peerSerial(t, addr, "a.test", ca.pool()); got != 11 {
+		t.Fatalf("expected old certificate after failed reload, got serial %d", got)
+	}
+}
+
+func TestTLSServerClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	server := ca.issue(t, dir, "server", 2, "127.0.0.1")
+	client := ca.issue(t, dir, "client", 3, "client")
+	_, addr := startTLSServer(t, TLSServerOptions{Certificates: []CertFile{server}, ClientCAFile: ca.file})
+	get := func(certs []tls.Certificate) error {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool(), Certificates: certs}}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + addr)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err := get(nil); err == nil {
+		t.Fatal("expected request without client certificate to fail")
+	}
+	cert, err := tls.LoadX509KeyPair(client.CertFile, client.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = get([]tls.Certificate{cert}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpstreamMTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverFile := ca.issue(t, dir, "backend", 2, "127.0.0.1")
+	client := ca.issue(t, dir, "proxy", 3, "proxy")
+	serverCert, err := tls.LoadX509KeyPair(serverFile.CertFile, serverFile.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	backend.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: ca.pool()}
+	backend.StartTLS()
+	defer backend.Close()
+
+	upstream, err := NewUpstream("mtls", []string{backend.Listener.Addr().String()}, UpstreamOptions{
+		TLS: &UpstreamTLS{CAFile: ca.file, CertFile: client.CertFile, KeyFile: client.KeyFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, body := request(t, newUpstreamProxy(t, upstream), http.MethodGet); code != http.StatusOK || body != "proxy" {
+		t.Fatalf("unexpected mtls response %d %s", code, body)
+	}
+
+	withoutCert, _ := NewUpstream("tls", []string{backend.Listener.Addr().String()}, UpstreamOptions{
+		TLS: &UpstreamTLS{CAFile: ca.file},
+	})
+	if code, _ := request(t, newUpstreamProxy(t, withoutCert), http.MethodGet); code != http.StatusBadGateway {
+		t.Fatalf("expected backend to reject proxy without client certificate, got %d", code)
+	}
+	if _, err = NewUpstream("bad", []string{"127.0.0.1:1"}, UpstreamOptions{TLS: &UpstreamTLS{CAFile: filepath.Join(dir, "missing.crt")}}); err == nil {
+		t.Fatal("expected missing ca file to fail")
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// startServerSpan 从请求头里提取上游调用方的trace，创建代理的server span
+func (p *ReverseProxy) startServerSpan(r *http.Request) (*http.Request, oteltrace.Span) {
+	ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := p.tracer.Start(ctx, "proxy "+r.Method, oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+		oteltrace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path),
+			attribute.String("net.peer.addr", r.RemoteAddr),
+		))
+	return r.WithContext(ctx), span
+}
+
+// endServerSpan server span只有5xx算错误
+func endServerSpan(span oteltrace.Span, route string, status int) {
+	span.SetAttributes(attribute.String("http.route", route), attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// backendTransport 每次请求后端时记录后端地址，开启trace时为每次请求(包括重试)创建client span并注入到请求头
+func (p *ReverseProxy) backendTransport(pc *Context, next http.RoundTripper) roundTripFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		pc.backend.set(req.URL.Host)
+		if p.tracer == nil {
+			return next.RoundTrip(req)
+		}
+		ctx, span := p.tracer.Start(req.Context(), "upstream "+req.Method, oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+			oteltrace.WithAttributes(
+				attribute.String("http.method", req.Method),
+				attribute.String("http.url", req.URL.String()),
+				attribute.String("net.peer.name", req.URL.Host),
+			))
+		defer span.End()
+		propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+		resp, err := next.RoundTrip(req.WithContext(ctx))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		// client span 4xx和5xx都算错误
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		}
+		return resp, nil
+	}
+}
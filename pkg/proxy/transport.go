@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	defaultMaxIdleConns          = 512
+	defaultMaxIdleConnsPerHost   = 64
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultDialTimeout           = 5 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 5 * time.Second
+	defaultExpectContinueTimeout = time.Second
+)
+
+// TransportOptions 转发到后端使用的连接池配置，为0的字段使用默认值
+type TransportOptions struct {
+	// 连接数限制只对HTTP/1生效，HTTP2时每个后端只有一个多路复用的连接
+	MaxIdleConns          int           // 所有后端的最大空闲连接数
+	MaxIdleConnsPerHost   int           // 每个后端的最大空闲连接数，http.DefaultTransport只有2个，并发高时连接会不断新建和关闭
+	MaxConnsPerHost       int           // 每个后端的最大连接数，0不限制
+	IdleConnTimeout       time.Duration // 空闲连接的保留时间
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration // tcp keep-alive周期，小于0时关闭
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration // 等待后端响应头的最长时间，0不限制，只对HTTP/1生效
+	ExpectContinueTimeout time.Duration
+	HTTP2                 bool        // 用h2c(明文HTTP/2)连接后端，一个后端只用一个连接多路复用，TLS不为空时用h2
+	TLS                   *tls.Config // https后端的tls配置，见UpstreamTLS
+}
+
+func (opts TransportOptions) withDefaults() TransportOptions {
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = defaultMaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost <= 0 {
+		opts.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaultDialTimeout
+	}
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = defaultKeepAlive
+	}
+	if opts.TLSHandshakeTimeout <= 0 {
+		opts.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if opts.ExpectContinueTimeout <= 0 {
+		opts.ExpectContinueTimeout = defaultExpectContinueTimeout
+	}
+	return opts
+}
+
+// NewTransport 按配置创建转发用的RoundTripper，整个ReverseProxy共用一个，连接在请求之间复用
+func NewTransport(opts TransportOptions) http.RoundTripper {
+	opts = opts.withDefaults()
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: opts.KeepAlive}
+	if opts.HTTP2 && opts.TLS != nil {
+		return &http2.Transport{
+			TLSClientConfig: opts.TLS,
+			// 用配置的dialer建立tcp连接，DialTimeout和TLSHandshakeTimeout才生效
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := dialer.DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				ctx, cancel := context.WithTimeout(ctx, opts.TLSHandshakeTimeout)
+				defer cancel()
+				tlsConn := tls.Client(conn, cfg)
+				if err = tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return tlsConn, nil
+			},
+			ReadIdleTimeout: opts.KeepAlive,
+			IdleConnTimeout: opts.IdleConnTimeout,
+		}
+	}
+	if opts.HTTP2 {
+		return &http2.Transport{
+			AllowHTTP: true,
+			// h2c不需要tls，直接建立tcp连接
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			ReadIdleTimeout: opts.KeepAlive,
+			IdleConnTimeout: opts.IdleConnTimeout,
+		}
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		ExpectContinueTimeout: opts.ExpectContinueTimeout,
+		TLSClientConfig:       opts.TLS,
+	}
+}
+
+// closeIdleConnections 替换transport后关闭旧transport的空闲连接
+func closeIdleConnections(transport http.RoundTripper) {
+	if closer, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"accumulation/pkg/log"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tunnelWebSocket = "websocket"
+	tunnelConnect   = "connect"
+
+	defaultTunnelIdleTimeout = 5 * time.Minute
+	tunnelBufferSize         = 32 << 10
+)
+
+// ConnectPolicy 判断是否允许CONNECT到host(host:port)
+type ConnectPolicy func(ctx context.Context, host string) bool
+
+// SetTunnelIdleTimeout 隧道两个方向都没有数据超过idleTimeout时关闭，0使用默认的5分钟
+func (p *ReverseProxy) SetTunnelIdleTimeout(idleTimeout time.Duration) {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultTunnelIdleTimeout
+	}
+	p.tunnelIdleTimeout = idleTimeout
+}
+
+// SetConnectPolicy 允许CONNECT隧道，policy为nil时CONNECT请求返回405
+func (p *ReverseProxy) SetConnectPolicy(policy ConnectPolicy) {
+	p.connectPolicy = policy
+}
+
+// isUpgrade 请求是否要升级协议，比如websocket
+func isUpgrade(r *http.Request) bool {
+	if len(r.Header.Get("Upgrade")) == 0 {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveConnect 建立到r.Host的tcp隧道
+func (p *ReverseProxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if p.connectPolicy == nil {
+		http.Error(w, "CONNECT is not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !p.connectPolicy(ctx, r.Host) {
+		http.Error(w, "CONNECT to "+r.Host+" is forbidden", http.StatusForbidden)
+		return
+	}
+	backend, err := dialBackend(ctx, r.Host)
+	if err != nil {
+		handlerError(w, r, err)
+		return
+	}
+	client, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		backend.Close()
+		handlerError(w, r, err)
+		return
+	}
+	if _, err = io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		client.Close()
+		backend.Close()
+		return
+	}
+	p.tunnel(ctx, tunnelConnect, r.Host, bufferedConn(client, clientBuf.Reader), backend)
+}
+
+// serveUpgrade 把升级请求转发到host，后端返回101时劫持客户端连接双向拷贝，否则把响应返回给客户端
+// tlsConfig不为空时用tls连接后端，handshake不为空时记录后端握手的结果
+func (p *ReverseProxy) serveUpgrade(w http.ResponseWriter, r *http.Request, host string, tlsConfig *tls.Config, handshake func(resp *http.Response, err error)) {
+	if handshake == nil {
+		handshake = func(*http.Response, error) {}
+	}
+	ctx := r.Context()
+	backend, err := dialBackend(ctx, host)
+	if err != nil {
+		handshake(nil, err)
+		handlerError(w, r, err)
+		return
+	}
+	if tlsConfig != nil {
+		if backend, err = tlsHandshake(ctx, backend, host, tlsConfig); err != nil {
+			handshake(nil, err)
+			handlerError(w, r, err)
+			return
+		}
+	}
+	outreq := upgradeRequest(r, host)
+	if err = outreq.Write(backend); err != nil {
+		backend.Close()
+		handshake(nil, err)
+		handlerError(w, r, err)
+		return
+	}
+	backendBuf := bufio.NewReader(backend)
+	resp, err := http.ReadResponse(backendBuf, outreq)
+	handshake(resp, err)
+	if err != nil {
+		backend.Close()
+		handlerError(w, r, err)
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer backend.Close()
+		defer resp.Body.Close()
+		copyHeader(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	client, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		backend.Close()
+		handlerError(w, r, err)
+		return
+	}
+	if err = resp.Write(client); err != nil {
+		client.Close()
+		backend.Close()
+		return
+	}
+	p.tunnel(ctx, tunnelWebSocket, host, bufferedConn(client, clientBuf.Reader), bufferedConn(backend, backendBuf))
+}
+
+func dialBackend(ctx context.Context, host string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultKeepAlive}
+	return dialer.DialContext(ctx, "tcp", host)
+}
+
+func tlsHandshake(ctx context.Context, conn net.Conn, host string, config *tls.Config) (net.Conn, error) {
+	config = config.Clone()
+	if len(config.ServerName) == 0 {
+		config.ServerName, _, _ = net.SplitHostPort(host)
+	}
+	// websocket只用http/1.1
+	config.NextProtos = nil
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// upgradeRequest 转发给后端的升级请求，保留Connection和Upgrade头
+func upgradeRequest(r *http.Request, host string) *http.Request {
+	outreq := r.Clone(r.Context())
+	outreq.URL.Scheme = "http"
+	outreq.URL.Host = host
+	outreq.RequestURI = ""
+	outreq.Body = nil
+	outreq.ContentLength = 0
+	outreq.Header.Del("Proxy-Connection")
+	outreq.Header.Del("Proxy-Authorization")
+	outreq.Header.Set("Connection", "Upgrade")
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := outreq.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		outreq.Header.Set("X-Forwarded-For", clientIP)
+	}
+	return outreq
+}
+
+func copyHeader(dst, src http.Header) {
+	for key, values := range src {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+// readerConn 先读取bufio里已经缓存的数据
+type readerConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func bufferedConn(conn net.Conn, reader *bufio.Reader) net.Conn {
+	if reader == nil {
+		return conn
+	}
+	return &readerConn{Conn: conn, reader: reader}
+}
+
+// tunnel 双向拷贝直到一端关闭或者空闲超时，统计两个方向的字节数
+func (p *ReverseProxy) tunnel(ctx context.Context, kind, host string, client, backend net.Conn) {
+	t := &tunnelConn{idleTimeout: p.tunnelIdleTimeout}
+	if t.idleTimeout <= 0 {
+		t.idleTimeout = defaultTunnelIdleTimeout
+	}
+	t.touch()
+	tunnelsActive.WithLabelValues(kind).Inc()
+	defer tunnelsActive.WithLabelValues(kind).Dec()
+	start := time.Now()
+	var up, down atomic.Int64
+	var once sync.Once
+	closeBoth := func() {
+		client.Close()
+		backend.Close()
+	}
+	errs := make(chan error, 2)
+	go func() {
+		err := t.pipe(backend, client, &up, tunnelBytes.WithLabelValues(kind, "up"))
+		once.Do(closeBoth)
+		errs <- err
+	}()
+	go func() {
+		err := t.pipe(client, backend, &down, tunnelBytes.WithLabelValues(kind, "down"))
+		once.Do(closeBoth)
+		errs <- err
+	}()
+	err := <-errs
+	<-errs
+	log.Infof(ctx, "%s tunnel to %s closed, up:%d down:%d cost:%s err:%v", kind, host, up.Load(), down.Load(), time.Since(start), err)
+}
+
+type tunnelConn struct {
+	idleTimeout  time.Duration
+	lastActivity atomic.Int64 // UnixNano
+}
+
+func (t *tunnelConn) touch() {
+	t.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (t *tunnelConn) idle() bool {
+	return time.Since(time.Unix(0, t.lastActivity.Load())) >= t.idleTimeout
+}
+
+type counter interface {
+	Add(float64)
+}
+
+// pipe 从src拷贝到dst，读超时时另一个方向最近有数据就继续等待
+func (t *tunnelConn) pipe(dst, src net.Conn, total *atomic.Int64, metric counter) error {
+	buf := make([]byte, tunnelBufferSize)
+	for {
+		src.SetReadDeadline(time.Now().Add(t.idleTimeout))
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+			if _, writeErr := dst.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			total.Add(int64(n))
+			metric.Add(float64(n))
+		}
+		if err == nil {
+			continue
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && !t.idle() {
+			continue
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("idle timeout %s", t.idleTimeout)
+		}
+		return err
+	}
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/websocket"
+)
+
+func dialWebSocket(t *testing.T, front *httptest.Server) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(front.URL, "http") + "/echo"
+	ws, err := websocket.Dial(url, "", front.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func TestWebSocketTunnel(t *testing.T) {
+	backend := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		io.Copy(ws, ws)
+	}))
+	defer backend.Close()
+	_, front := newTestProxy(t, backend)
+	up := testutil.ToFloat64(tunnelBytes.WithLabelValues(tunnelWebSocket, "up"))
+	down := testutil.ToFloat64(tunnelBytes.WithLabelValues(tunnelWebSocket, "down"))
+
+	ws := dialWebSocket(t, front)
+	for _, message := range []string{"hello", strings.Repeat("x", 2000)} {
+		if err := websocket.Message.Send(ws, message); err != nil {
+			t.Fatal(err)
+		}
+		var reply string
+		if err := websocket.Message.Receive(ws, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply != message {
+			t.Fatalf("unexpected echo of %d bytes: %d bytes", len(message), len(reply))
+		}
+	}
+	if got := testutil.ToFloat64(tunnelsActive.WithLabelValues(tunnelWebSocket)); got != 1 {
+		t.Fatalf("expected 1 active tunnel, got %v", got)
+	}
+	ws.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(tunnelsActive.WithLabelValues(tunnelWebSocket)) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("tunnel is not closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if testutil.ToFloat64(tunnelBytes.WithLabelValues(tunnelWebSocket, "up"))-up < 2000 ||
+		testutil.ToFloat64(tunnelBytes.WithLabelValues(tunnelWebSocket, "down"))-down < 2000 {
+		t.Fatal("expected tunnel bytes to be counted")
+	}
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	backend := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		io.Copy(ws, ws)
+	}))
+	defer backend.Close()
+	proxy, front := newTestProxy(t, backend)
+	proxy.SetTunnelIdleTimeout(50 * time.Millisecond)
+	ws := dialWebSocket(t, front)
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply string
+	if err := websocket.Message.Receive(ws, &reply); err != io.EOF {
+		t.Fatalf("expected idle tunnel to be closed, got %v", err)
+	}
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no upgrade", http.StatusBadRequest)
+	}))
+	defer backend.Close()
+	_, front := newTestProxy(t, backend)
+	req, _ := http.NewRequest(http.MethodGet, front.URL+"/echo", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := front.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || strings.TrimSpace(string(body)) != "no upgrade" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestWebSocketUpstream(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "fail", http.StatusInternalServerError)
+			return
+		}
+		websocket.Handler(func(ws *websocket.Conn) {
+			io.Copy(ws, ws)
+		}).ServeHTTP(w, r)
+	}))
+	defer backend.Close()
+	upstream, _ := NewUpstream("ws", []string{backend.Listener.Addr().String()}, UpstreamOptions{
+		Breaker: &BreakerOptions{MinRequests: 3, OpenDuration: time.Minute},
+	})
+	front := newUpstreamProxy(t, upstream)
+	// 隧道关闭前一直计入后端的活跃连接数
+	ws := dialWebSocket(t, front)
+	if active := upstream.Backends()[0].Active(); active != 1 {
+		t.Fatalf("expected 1 active tunnel on backend, got %d", active)
+	}
+	ws.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for upstream.Backends()[0].Active() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("backend active count is not released")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// 握手失败计入熔断器
+	upgrade := func() int {
+		req, _ := http.NewRequest(http.MethodGet, front.URL+"/fail", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		resp, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i := 0; i < 2; i++ {
+		if code := upgrade(); code != http.StatusInternalServerError {
+			t.Fatalf("request %d: expected 500, got %d", i, code)
+		}
+	}
+	if code := upgrade(); code != http.StatusServiceUnavailable || upstream.BreakerState() != BreakerOpen {
+		t.Fatalf("expected breaker to open, got %d %v", code, upstream.BreakerState())
+	}
+}
+
+// connect 通过代理CONNECT到host，成功时在隧道里请求host
+func connect(t *testing.T, front *httptest.Server, host string) (int, string) {
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "CONNECT "+host+" HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, ""
+	}
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+host+"\r\nConnection: close\r\n\r\n")
+	resp, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return http.StatusOK, string(body)
+}
+
+func TestConnectTunnel(t *testing.T) {
+	backend, _, _ := newBackend(t, false)
+	host := backend.Listener.Addr().String()
+	proxy, front := newTestProxy(t, backend)
+	if code, _ := connect(t, front, host); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected CONNECT to be disabled by default, got %d", code)
+	}
+	proxy.SetConnectPolicy(func(ctx context.Context, target string) bool {
+		return target == host
+	})
+	if code, body := connect(t, front, host); code != http.StatusOK || body != "ok" {
+		t.Fatalf("unexpected tunnel response %d %s", code, body)
+	}
+	if code, _ := connect(t, front, "127.0.0.1:1"); code != http.StatusForbidden {
+		t.Fatalf("expected CONNECT to other host to be forbidden, got %d", code)
+	}
+}
@@ -0,0 +1,408 @@
+package proxy
+
+import (
+	"accumulation/pkg/log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoAvailableBackend 上游所有后端都不健康或者被摘除，代理返回503
+var ErrNoAvailableBackend = errors.New("no available backend")
+
+// Backend 上游里的一个后端
+type Backend struct {
+	Addr         string // host:port
+	active       atomic.Int64
+	healthy      atomic.Bool
+	checks       atomic.Int32 // 主动检查连续成功(正数)或者失败(负数)的次数
+	failures     atomic.Int32 // 被动检查连续失败的次数
+	ejectedUntil atomic.Int64 // 被动检查摘除到的时间，UnixNano
+}
+
+// Active 正在处理的请求数
+func (b *Backend) Active() int64 {
+	return b.active.Load()
+}
+
+// Healthy 主动健康检查的结果，没有配置主动检查时总是true
+func (b *Backend) Healthy() bool {
+	return b.healthy.Load()
+}
+
+// Ejected 是否因为连续失败被摘除
+func (b *Backend) Ejected(now time.Time) bool {
+	return now.UnixNano() < b.ejectedUntil.Load()
+}
+
+func (b *Backend) available(now time.Time) bool {
+	return b.Healthy() && !b.Ejected(now)
+}
+
+// HealthCheck 主动健康检查，定时GET Path，返回2xx算成功
+type HealthCheck struct {
+	Path               string
+	Interval           time.Duration // 默认10秒
+	Timeout            time.Duration // 默认2秒
+	HealthyThreshold   int           // 连续成功几次标记为健康，默认2
+	UnhealthyThreshold int           // 连续失败几次标记为不健康，默认3
+}
+
+// Outlier 被动检查，连续ConsecutiveFailures次连接失败或者5xx时摘除EjectDuration，0不摘除
+type Outlier struct {
+	ConsecutiveFailures int
+	EjectDuration       time.Duration // 默认30秒
+}
+
+type UpstreamOptions struct {
+	Balancer    Balancer // 默认RoundRobin
+	HealthCheck *HealthCheck
+	Outlier     Outlier
+	// Retries 幂等请求连接失败或者后端返回502/503/504时换一个后端重试的次数
+	Retries int
+	// Breaker 不为空时整个上游按错误率和慢请求比例熔断，熔断时返回503
+	Breaker *BreakerOptions
+	// TLS 不为空时用https连接后端，健康检查和websocket也使用tls
+	TLS *UpstreamTLS
+}
+
+// Upstream 一组提供相同服务的后端
+type Upstream struct {
+	Name      string
+	backends  []*Backend
+	opts      UpstreamOptions
+	client    *http.Client
+	breaker   *circuitBreaker
+	tlsConfig *tls.Config
+	// transport 配置了TLS时上游使用自己的连接池，否则使用ReverseProxy的
+	transport http.RoundTripper
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewUpstream addrs是后端的host:port列表
+func NewUpstream(name string, addrs []string, opts UpstreamOptions) (*Upstream, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("upstream %s has no backend", name)
+	}
+	if opts.Balancer == nil {
+		opts.Balancer = RoundRobin()
+	}
+	if opts.Outlier.EjectDuration <= 0 {
+		opts.Outlier.EjectDuration = 30 * time.Second
+	}
+	if check := opts.HealthCheck; check != nil {
+		copied := *check
+		if copied.Interval <= 0 {
+			copied.Interval = 10 * time.Second
+		}
+		if copied.Timeout <= 0 {
+			copied.Timeout = 2 * time.Second
+		}
+		if copied.HealthyThreshold <= 0 {
+			copied.HealthyThreshold = 2
+		}
+		if copied.UnhealthyThreshold <= 0 {
+			copied.UnhealthyThreshold = 3
+		}
+		opts.HealthCheck = &copied
+	}
+	upstream := &Upstream{Name: name, opts: opts}
+	if opts.Breaker != nil {
+		upstream.breaker = newCircuitBreaker(name, *opts.Breaker)
+	}
+	if opts.TLS != nil {
+		config, err := opts.TLS.config()
+		if err != nil {
+			return nil, fmt.Errorf("upstream %s tls err:%w", name, err)
+		}
+		upstream.tlsConfig = config
+		upstream.transport = NewTransport(TransportOptions{TLS: config})
+	}
+	for _, addr := range addrs {
+		backend := &Backend{Addr: addr}
+		backend.healthy.Store(true)
+		upstream.backends = append(upstream.backends, backend)
+	}
+	return upstream, nil
+}
+
+// Backends 所有后端
+func (u *Upstream) Backends() []*Backend {
+	return u.backends
+}
+
+// BreakerState 熔断状态，没有配置Breaker时总是BreakerClosed
+func (u *Upstream) BreakerState() BreakerState {
+	if u.breaker == nil {
+		return BreakerClosed
+	}
+	return u.breaker.State()
+}
+
+// Start 开始主动健康检查，没有配置HealthCheck时什么都不做
+func (u *Upstream) Start() {
+	if u.opts.HealthCheck == nil || u.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	u.cancel = cancel
+	u.client = &http.Client{Transport: NewTransport(TransportOptions{TLS: u.tlsConfig}), Timeout: u.opts.HealthCheck.Timeout}
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		ticker := time.NewTicker(u.opts.HealthCheck.Interval)
+		defer ticker.Stop()
+		for {
+			u.checkAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止主动健康检查
+func (u *Upstream) Stop() {
+	if u.cancel == nil {
+		return
+	}
+	u.cancel()
+	u.wg.Wait()
+	u.cancel = nil
+	closeIdleConnections(u.client.Transport)
+}
+
+func (u *Upstream) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, backend := range u.backends {
+		wg.Add(1)
+		go func(backend *Backend) {
+			defer wg.Done()
+			u.markChecked(backend, u.check(ctx, backend))
+		}(backend)
+	}
+	wg.Wait()
+}
+
+func (u *Upstream) check(ctx context.Context, backend *Backend) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.scheme()+"://"+backend.Addr+u.opts.HealthCheck.Path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (u *Upstream) markChecked(backend *Backend, err error) {
+	check := u.opts.HealthCheck
+	if err == nil {
+		count := backend.checks.Load()
+		if count < 0 {
+			count = 0
+		}
+		backend.checks.Store(count + 1)
+		if !backend.Healthy() && int(count+1) >= check.HealthyThreshold {
+			backend.healthy.Store(true)
+			log.Infof(context.TODO(), "upstream %s backend %s is healthy", u.Name, backend.Addr)
+		}
+		return
+	}
+	count := backend.checks.Load()
+	if count > 0 {
+		count = 0
+	}
+	backend.checks.Store(count - 1)
+	if backend.Healthy() && int(1-count) >= check.UnhealthyThreshold {
+		backend.healthy.Store(false)
+		log.Warnf(context.TODO(), "upstream %s backend %s is unhealthy err:%v", u.Name, backend.Addr, err)
+	}
+}
+
+// observe 被动检查，记录一次请求的结果
+func (u *Upstream) observe(backend *Backend, resp *http.Response, err error) {
+	if err == nil && resp.StatusCode < 500 {
+		backend.failures.Store(0)
+		return
+	}
+	failures := backend.failures.Add(1)
+	threshold := u.opts.Outlier.ConsecutiveFailures
+	if threshold <= 0 || int(failures) < threshold {
+		return
+	}
+	backend.failures.Store(0)
+	backend.ejectedUntil.Store(time.Now().Add(u.opts.Outlier.EjectDuration).UnixNano())
+	log.Warnf(context.TODO(), "upstream %s backend %s ejected for %s after %d failures", u.Name, backend.Addr, u.opts.Outlier.EjectDuration, failures)
+}
+
+// pick 从没有试过的可用后端里选一个，没有时返回nil
+func (u *Upstream) pick(req *http.Request, tried map[*Backend]bool) *Backend {
+	now := time.Now()
+	candidates := make([]*Backend, 0, len(u.backends))
+	for _, backend := range u.backends {
+		if !tried[backend] && backend.available(now) {
+			candidates = append(candidates, backend)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return u.opts.Balancer.Pick(req, candidates)
+}
+
+func (u *Upstream) scheme() string {
+	if u.tlsConfig != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// transportOr 配置了TLS时使用上游自己的连接池，否则使用transport
+func (u *Upstream) transportOr(transport http.RoundTripper) http.RoundTripper {
+	if u.transport != nil {
+		return u.transport
+	}
+	return transport
+}
+
+// roundTrip 选择后端转发，幂等请求失败时换一个后端重试
+func (u *Upstream) roundTrip(transport http.RoundTripper, req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = u.scheme()
+	tried := make(map[*Backend]bool)
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		backend := u.pick(req, tried)
+		if backend == nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, fmt.Errorf("upstream %s: %w", u.Name, ErrNoAvailableBackend)
+		}
+		tried[backend] = true
+		if attempt > 0 {
+			if err := rewindBody(req); err != nil {
+				return nil, lastErr
+			}
+		}
+		if u.breaker != nil {
+			if err := u.breaker.allow(); err != nil {
+				if lastErr != nil {
+					return nil, lastErr
+				}
+				return nil, err
+			}
+		}
+		req.URL.Host = backend.Addr
+		backend.active.Add(1)
+		start := time.Now()
+		resp, err := transport.RoundTrip(req)
+		if u.breaker != nil {
+			// 客户端取消的请求不算后端失败
+			failed := (err != nil && !errors.Is(err, context.Canceled)) || (err == nil && resp.StatusCode >= 500)
+			u.breaker.record(failed, time.Since(start))
+		}
+		u.observe(backend, resp, err)
+		retry := attempt < u.opts.Retries && retryable(req) && (err != nil || retryableStatus(resp.StatusCode))
+		if !retry {
+			if err != nil {
+				backend.active.Add(-1)
+				return nil, err
+			}
+			resp.Body = &activeBody{ReadCloser: resp.Body, backend: backend}
+			return resp, nil
+		}
+		backend.active.Add(-1)
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("backend %s status %d", backend.Addr, resp.StatusCode)
+		}
+		log.Warnf(req.Context(), "upstream %s backend %s failure, retry err:%v", u.Name, backend.Addr, err)
+		lastErr = err
+	}
+}
+
+// acquireUpgrade 给升级请求选择后端，和roundTrip一样经过熔断器并计入后端的活跃连接数，
+// 握手结果交给返回的handshake记录，隧道关闭后调用release
+func (u *Upstream) acquireUpgrade(req *http.Request) (backend *Backend, handshake func(*http.Response, error), release func(), err error) {
+	backend = u.pick(req, nil)
+	if backend == nil {
+		return nil, nil, nil, fmt.Errorf("upstream %s: %w", u.Name, ErrNoAvailableBackend)
+	}
+	if u.breaker != nil {
+		if err = u.breaker.allow(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	backend.active.Add(1)
+	start := time.Now()
+	handshake = func(resp *http.Response, err error) {
+		if u.breaker != nil {
+			failed := (err != nil && !errors.Is(err, context.Canceled)) || (err == nil && resp.StatusCode >= 500)
+			u.breaker.record(failed, time.Since(start))
+		}
+		u.observe(backend, resp, err)
+	}
+	release = func() {
+		backend.active.Add(-1)
+	}
+	return backend, handshake, release, nil
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// retryable 幂等并且请求体可以重新发送
+func retryable(req *http.Request) bool {
+	return idempotent(req.Method) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+func rewindBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+// activeBody 响应体关闭时请求才算处理完
+type activeBody struct {
+	io.ReadCloser
+	backend *Backend
+	once    sync.Once
+}
+
+func (b *activeBody) Close() error {
+	b.once.Do(func() {
+		b.backend.active.Add(-1)
+	})
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newBackends(addrs ...string) []*Backend {
+	var backends []*Backend
+	for _, addr := range addrs {
+		backend := &Backend{Addr: addr}
+		backend.healthy.Store(true)
+		backends = append(backends, backend)
+	}
+	return backends
+}
+
+func TestBalancers(t *testing.T) {
+	backends := newBackends("a:1", "b:1", "c:1")
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	roundRobin := RoundRobin()
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, roundRobin.Pick(req, backends).Addr)
+	}
+	if strings.Join(picked, ",") != "a:1,b:1,c:1,a:1" {
+		t.Fatalf("unexpected round robin order %v", picked)
+	}
+
+	backends[0].active.Store(3)
+	backends[1].active.Store(1)
+	backends[2].active.Store(2)
+	if got := LeastConnections().Pick(req, backends); got != backends[1] {
+		t.Fatalf("expected least connections to pick b:1, got %s", got.Addr)
+	}
+
+	hash := ConsistentHash("X-Vmid")
+	owners := map[string]*Backend{}
+	for vmid := 0; vmid < 300; vmid++ {
+		req.Header.Set("X-Vmid", fmt.Sprint(vmid))
+		owner := hash.Pick(req, backends)
+		if again := hash.Pick(req, backends); again != owner {
+			t.Fatalf("vmid %d moved from %s to %s", vmid, owner.Addr, again.Addr)
+		}
+		owners[fmt.Sprint(vmid)] = owner
+	}
+	// 摘除c之后只有原来在c上的vmid迁移
+	moved := 0
+	for vmid, owner := range owners {
+		req.Header.Set("X-Vmid", vmid)
+		got := hash.Pick(req, backends[:2])
+		if owner != backends[2] && got != owner {
+			t.Fatalf("vmid %s moved from %s to %s", vmid, owner.Addr, got.Addr)
+		}
+		if got != owner {
+			moved++
+		}
+	}
+	if moved == 0 || moved == len(owners) {
+		t.Fatalf("unexpected moved count %d", moved)
+	}
+}
+
+// namedBackend 返回自己的名字，status不为0时返回这个状态码
+func namedBackend(t *testing.T, name string, status *atomic.Int32) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := status.Load(); code != 0 {
+			w.WriteHeader(int(code))
+			return
+		}
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// deadAddr 一个没有监听的地址
+func deadAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+func newUpstreamProxy(t *testing.T, upstream *Upstream) *httptest.Server {
+	proxy := NewReverseProxy(nil)
+	proxy.SetUpstreamRoute(func(ctx context.Context, req *http.Request) *Upstream {
+		return upstream
+	})
+	front := httptest.NewServer(proxy)
+	t.Cleanup(front.Close)
+	return front
+}
+
+func request(t *testing.T, front *httptest.Server, method string) (int, string) {
+	req, _ := http.NewRequest(method, front.URL+"/", strings.NewReader("body"))
+	resp, err := front.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestUpstreamRetry(t *testing.T) {
+	var status atomic.Int32
+	live := namedBackend(t, "live", &status)
+	upstream, err := NewUpstream("game", []string{deadAddr(t), live.Listener.Addr().String()}, UpstreamOptions{Retries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := newUpstreamProxy(t, upstream)
+	for i := 0; i < 4; i++ {
+		if code, body := request(t, front, http.MethodPut); code != http.StatusOK || body != "live" {
+			t.Fatalf("expected idempotent request to be retried, got %d %s", code, body)
+		}
+	}
+	// 非幂等请求不重试，轮到坏的后端时返回502
+	codes := map[int]int{}
+	for i := 0; i < 4; i++ {
+		code, _ := request(t, front, http.MethodPost)
+		codes[code]++
+	}
+	if codes[http.StatusOK] != 2 || codes[http.StatusBadGateway] != 2 {
+		t.Fatalf("unexpected status codes %v", codes)
+	}
+	for _, backend := range upstream.Backends() {
+		if backend.Active() != 0 {
+			t.Fatalf("backend %s still has %d active requests", backend.Addr, backend.Active())
+		}
+	}
+}
+
+func TestUpstreamOutlierEjection(t *testing.T) {
+	var okStatus, badStatus atomic.Int32
+	badStatus.Store(http.StatusInternalServerError)
+	good := namedBackend(t, "good", &okStatus)
+	bad := namedBackend(t, "bad", &badStatus)
+	upstream, _ := NewUpstream("game", []string{bad.Listener.Addr().String(), good.Listener.Addr().String()}, UpstreamOptions{
+		Outlier: Outlier{ConsecutiveFailures: 2, EjectDuration: time.Minute},
+	})
+	front := newUpstreamProxy(t, upstream)
+	failures := 0
+	for i := 0; i < 10; i++ {
+		if code, _ := request(t, front, http.MethodPost); code == http.StatusInternalServerError {
+			failures++
+		}
+	}
+	if failures != 2 || !upstream.Backends()[0].Ejected(time.Now()) {
+		t.Fatalf("expected bad backend to be ejected after 2 failures, got %d", failures)
+	}
+}
+
+func TestUpstreamHealthCheck(t *testing.T) {
+	var status atomic.Int32
+	backend := namedBackend(t, "backend", &status)
+	upstream, _ := NewUpstream("game", []string{backend.Listener.Addr().String()}, UpstreamOptions{
+		HealthCheck: &HealthCheck{Path: "/health", Interval: 10 * time.Millisecond, HealthyThreshold: 1, UnhealthyThreshold: 2},
+	})
+	upstream.Start()
+	defer upstream.Stop()
+	front := newUpstreamProxy(t, upstream)
+	waitHealthy := func(healthy bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for upstream.Backends()[0].Healthy() != healthy {
+			if time.Now().After(deadline) {
+				t.Fatalf("backend healthy is not %v", healthy)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	status.Store(http.StatusServiceUnavailable)
+	waitHealthy(false)
+	if code, _ := request(t, front, http.MethodGet); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without available backend, got %d", code)
+	}
+	status.Store(0)
+	waitHealthy(true)
+	if code, body := request(t, front, http.MethodGet); code != http.StatusOK || body != "backend" {
+		t.Fatalf("unexpected response %d %s", code, body)
+	}
+}
@@ -0,0 +1,275 @@
+package ringbuff
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Policy 队列满时的处理策略
+type Policy int
+
+const (
+	// Reject 队列满时Enqueue返回false，Put阻塞等待空位
+	Reject Policy = iota
+	// OverwriteOldest 队列满时丢弃最早的元素，Enqueue和Put都不会失败
+	OverwriteOldest
+)
+
+// RingBuff 加锁的环形队列，支持阻塞的Put/Take，多个生产者和消费者可以并发使用
+type RingBuff[T any] struct {
+	items    []T
+	size     int
+	capacity int
+	head     int
+	tail     int
+	policy   Policy
+	mutex    sync.Mutex
+	notEmpty chan struct{} // 有等待者时才创建，状态变化时关闭来唤醒所有等待者
+	notFull  chan struct{}
+	dropped  atomic.Uint64 // 覆盖丢弃的元素个数
+	rejected atomic.Uint64 // 队列满被拒绝的元素个数
+}
+
+func NewRingBuff[T any](capacity int, policy Policy) *RingBuff[T] {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &RingBuff[T]{
+		items:    make([]T, capacity),
+		capacity: capacity,
+		policy:   policy,
+	}
+}
+
+// Enqueue 入队，不阻塞。Reject策略下队列满时返回false
+func (rb *RingBuff[T]) Enqueue(val T) bool {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return rb.enqueue(val)
+}
+
+func (rb *RingBuff[T]) enqueue(val T) bool {
+	if rb.size == rb.capacity {
+		if rb.policy != OverwriteOldest {
+			rb.rejected.Add(1)
+			return false // 队列已满
+		}
+		rb.pop()
+		rb.dropped.Add(1)
+	}
+	rb.items[rb.tail] = val
+	rb.tail = (rb.tail + 1) % rb.capacity
+	rb.size++
+	rb.notEmpty = wake(rb.notEmpty)
+	return true
+}
+
+// pop 调用方需要持有锁并保证队列不为空
+func (rb *RingBuff[T]) pop() T {
+	var t T
+	val := rb.items[rb.head]
+	rb.items[rb.head] = t
+	rb.head = (rb.head + 1) % rb.capacity
+	rb.size--
+	return val
+}
+
+func (rb *RingBuff[T]) Dequeue() (T, bool) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	var t T
+	if rb.size == 0 {
+		return t, false // 队列为空
+	}
+	val := rb.pop()
+	rb.notFull = wake(rb.notFull)
+	return val, true
+}
+
+// Put 入队，队列满时阻塞到有空位或者ctx结束
+func (rb *RingBuff[T]) Put(ctx context.Context, val T) error {
+	for {
+		rb.mutex.Lock()
+		if rb.size < rb.capacity || rb.policy == OverwriteOldest {
+			rb.enqueue(val)
+			rb.mutex.Unlock()
+			return nil
+		}
+		rb.notFull = waiter(rb.notFull)
+		wait := rb.notFull
+		rb.mutex.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Take 出队，队列为空时阻塞到有数据或者ctx结束
+func (rb *RingBuff[T]) Take(ctx context.Context) (T, error) {
+	for {
+		rb.mutex.Lock()
+		if rb.size > 0 {
+			val := rb.pop()
+			rb.notFull = wake(rb.notFull)
+			rb.mutex.Unlock()
+			return val, nil
+		}
+		rb.notEmpty = waiter(rb.notEmpty)
+		wait := rb.notEmpty
+		rb.mutex.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			var t T
+			return t, ctx.Err()
+		}
+	}
+}
+
+// Wait 阻塞到队列里至少有n个元素或者ctx结束，n大于容量时按容量算
+func (rb *RingBuff[T]) Wait(ctx context.Context, n int) error {
+	for {
+		rb.mutex.Lock()
+		if rb.size >= n || rb.size == rb.capacity {
+			rb.mutex.Unlock()
+			return nil
+		}
+		rb.notEmpty = waiter(rb.notEmpty)
+		wait := rb.notEmpty
+		rb.mutex.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Drain 出队最多n个元素，不阻塞，n<=0时出队全部
+func (rb *RingBuff[T]) Drain(n int) []T {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if n <= 0 || n > rb.size {
+		n = rb.size
+	}
+	result := make([]T, 0, n)
+	for index := 0; index < n; index++ {
+		result = append(result, rb.pop())
+	}
+	if n > 0 {
+		rb.notFull = wake(rb.notFull)
+	}
+	return result
+}
+
+func (rb *RingBuff[T]) Current() T {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	var t T
+	if rb.size == 0 {
+		return t // 队列为空
+	}
+	return rb.items[rb.head]
+}
+
+func (rb *RingBuff[T]) Size() int {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return rb.size
+}
+
+// Surplus 返回队列里的所有元素，不出队
+func (rb *RingBuff[T]) Surplus() []T {
+	return rb.Peek(rb.Capacity())
+}
+
+// SurplusCount 队列的剩余空间
+func (rb *RingBuff[T]) SurplusCount() int {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return rb.capacity - rb.size
+}
+
+// Peek 返回队头最多n个元素，不出队
+func (rb *RingBuff[T]) Peek(n int) []T {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if n > rb.size {
+		n = rb.size
+	}
+	result := make([]T, 0, n)
+	for index := 0; index < n; index++ {
+		result = append(result, rb.items[(index+rb.head)%rb.capacity])
+	}
+	return result
+}
+
+// Discard 队头最多n个元素出队，返回实际出队的个数
+func (rb *RingBuff[T]) Discard(n int) int {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if n > rb.size {
+		n = rb.size
+	}
+	for index := 0; index < n; index++ {
+		rb.pop()
+	}
+	if n > 0 {
+		rb.notFull = wake(rb.notFull)
+	}
+	return n
+}
+
+// Resize 修改容量并保留队列里的元素，队列里的元素比新容量多时返回false，容量不变
+func (rb *RingBuff[T]) Resize(capacity int) bool {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if capacity <= 0 || capacity < rb.size {
+		return false
+	}
+	items := make([]T, capacity)
+	for index := 0; index < rb.size; index++ {
+		items[index] = rb.items[(index+rb.head)%rb.capacity]
+	}
+	rb.items = items
+	rb.capacity = capacity
+	rb.head = 0
+	rb.tail = rb.size % capacity
+	rb.notFull = wake(rb.notFull)
+	return true
+}
+
+func (rb *RingBuff[T]) Capacity() int {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return rb.capacity
+}
+
+// Dropped OverwriteOldest策略下被覆盖丢弃的元素个数
+func (rb *RingBuff[T]) Dropped() uint64 {
+	return rb.dropped.Load()
+}
+
+// Rejected Reject策略下Enqueue失败的次数
+func (rb *RingBuff[T]) Rejected() uint64 {
+	return rb.rejected.Load()
+}
+
+// waiter 返回等待用的通道，没有等待者时才创建
+func waiter(ch chan struct{}) chan struct{} {
+	if ch == nil {
+		ch = make(chan struct{})
+	}
+	return ch
+}
+
+// wake 唤醒所有等待者，下一个等待者会重新创建通道
+func wake(ch chan struct{}) chan struct{} {
+	if ch != nil {
+		close(ch)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package ringbuff_test
+
+import (
+	"accumulation/pkg/ringbuff"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRingBuff(t *testing.T) {
+	rb := ringbuff.NewRingBuff[int](5, ringbuff.Reject)
+
+	// Enqueue
+	for i := 0; i < 5; i++ {
+		if !rb.Enqueue(i) {
+			t.Errorf("Enqueue failed")
+		}
+	}
+
+	// Dequeue
+	for i := 0; i < 5; i++ {
+		val, ok := rb.Dequeue()
+		if !ok || val != i {
+			t.Errorf("Dequeue failed")
+		}
+		if rb.Size() > 0 && rb.Current() != i+1 {
+			t.Errorf("Current failed")
+		}
+	}
+
+	// Surplus
+	for i := 0; i < 5; i++ {
+		rb.Enqueue(i)
+	}
+	surplus := rb.Surplus()
+	if len(surplus) != 5 {
+		t.Errorf("Surplus failed")
+	}
+
+	// SurplusCount
+	if rb.Size() != 5 {
+		t.Errorf("SurplusCount failed")
+	}
+}
+
+func TestRingBuffConcurrent(t *testing.T) {
+	rb := ringbuff.NewRingBuff[int](15000, ringbuff.Reject)
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		for i := 0; i < 5000; i++ {
+			if !rb.Enqueue(i) {
+				t.Errorf("Enqueue failed")
+			}
+		}
+		wg.Done()
+	}()
+
+	go func() {
+		for i := 0; i < 5000; i++ {
+			if !rb.Enqueue(i) {
+				t.Errorf("Enqueue failed")
+			}
+		}
+		wg.Done()
+	}()
+	go func() {
+		for i := 0; i < 5000; i++ {
+			if !rb.Enqueue(i) {
+				t.Errorf("Enqueue failed")
+			}
+		}
+		wg.Done()
+	}()
+	wg.Wait()
+	if rb.Size() != 15000 {
+		t.Errorf("Enqueue failed")
+	}
+}
+
+func TestRingBuffResize(t *testing.T) {
+	rb := ringbuff.NewRingBuff[int](4, ringbuff.Reject)
+	for i := 0; i < 4; i++ {
+		rb.Enqueue(i)
+	}
+	rb.Discard(2)
+	rb.Enqueue(4)
+	rb.Enqueue(5)
+	if rb.Resize(3) {
+		t.Fatalf("expected resize below size to fail")
+	}
+	if !rb.Resize(6) || rb.Capacity() != 6 {
+		t.Fatalf("expected resize to succeed")
+	}
+	rb.Enqueue(6)
+	rb.Enqueue(7)
+	if rb.Enqueue(8) {
+		t.Fatalf("expected ring to be full")
+	}
+	rb.Discard(3)
+	if !rb.Resize(3) {
+		t.Fatalf("expected shrink to succeed")
+	}
+	if got := rb.Surplus(); len(got) != 3 || got[0] != 5 || got[2] != 7 {
+		t.Fatalf("unexpected items after resize %v", got)
+	}
+}
+
+func TestRingBuffOverwrite(t *testing.T) {
+	rb := ringbuff.NewRingBuff[int](3, ringbuff.OverwriteOldest)
+	for i := 0; i < 5; i++ {
+		if !rb.Enqueue(i) {
+			t.Fatalf("expected overwrite enqueue to succeed")
+		}
+	}
+	if got := rb.Surplus(); fmt.Sprint(got) != "[2 3 4]" || rb.Dropped() != 2 {
+		t.Fatalf("unexpected items %v dropped %d", got, rb.Dropped())
+	}
+	if err := rb.Put(context.Background(), 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := rb.Drain(2); fmt.Sprint(got) != "[3 4]" || rb.Size() != 1 {
+		t.Fatalf("unexpected drained items %v", got)
+	}
+	if got := rb.Drain(0); fmt.Sprint(got) != "[5]" {
+		t.Fatalf("unexpected drained items %v", got)
+	}
+}
+
+func TestRingBuffBlocking(t *testing.T) {
+	rb := ringbuff.NewRingBuff[int](1, ringbuff.Reject)
+	ctx := context.Background()
+	if err := rb.Put(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if rb.Enqueue(2) || rb.Rejected() != 1 {
+		t.Fatalf("expected full ring to reject")
+	}
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := rb.Put(timeout, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected put to time out, got %v", err)
+	}
+
+	// 队列满时Put阻塞，Take出队后被唤醒
+	done := make(chan error)
+	go func() {
+		done <- rb.Put(ctx, 2)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if val, err := rb.Take(ctx); err != nil || val != 1 {
+		t.Fatalf("unexpected take %d err:%v", val, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if val, err := rb.Take(ctx); err != nil || val != 2 {
+		t.Fatalf("unexpected take %d err:%v", val, err)
+	}
+	timeout, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := rb.Take(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected take to time out, got %v", err)
+	}
+}
+
+func TestRingBuffWait(t *testing.T) {
+	rb := ringbuff.NewRingBuff[int](4, ringbuff.Reject)
+	go func() {
+		for i := 0; i < 3; i++ {
+			rb.Enqueue(i)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rb.Wait(ctx, 3); err != nil || rb.Size() < 3 {
+		t.Fatalf("unexpected wait size %d err:%v", rb.Size(), err)
+	}
+	// 要等待的个数比容量大时，队列满就返回
+	rb.Enqueue(3)
+	if err := rb.Wait(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRingBuffProducerConsumer(t *testing.T) {
+	rb := ringbuff.NewRingBuff[int](8, ringbuff.Reject)
+	ctx := context.Background()
+	const producers, count = 4, 1000
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				if err := rb.Put(ctx, i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	sum := 0
+	for i := 0; i < producers*count; i++ {
+		val, err := rb.Take(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += val
+	}
+	wg.Wait()
+	if want := producers * count * (count - 1) / 2; sum != want {
+		t.Fatalf("got sum %d, want %d", sum, want)
+	}
+}
+
+func BenchmarkRingBuffEnqueueDequeue(b *testing.B) {
+	rb := ringbuff.NewRingBuff[int](1024, ringbuff.Reject)
+	for i := 0; i < b.N; i++ {
+		rb.Enqueue(i)
+		rb.Dequeue()
+	}
+}
+
+func BenchmarkRingBuffPutTake(b *testing.B) {
+	rb := ringbuff.NewRingBuff[int](1024, ringbuff.Reject)
+	ctx := context.Background()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			rb.Put(ctx, i)
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		rb.Take(ctx)
+	}
+}
@@ -0,0 +1,83 @@
+package ringbuff
+
+import (
+	"sync/atomic"
+)
+
+// SPSC 单生产者单消费者的无锁环形队列，用于收集器读包这类热点路径
+// 只允许一个goroutine调用Offer，一个goroutine调用Poll/Drain，队列满时Offer失败并计数
+type SPSC[T any] struct {
+	items   []T
+	mask    uint64
+	head    atomic.Uint64 // 消费者的位置，只有消费者写
+	_       [56]byte      // head和tail放在不同的缓存行，避免伪共享
+	tail    atomic.Uint64 // 生产者的位置，只有生产者写
+	_       [56]byte
+	dropped atomic.Uint64
+}
+
+// NewSPSC 容量向上取整到2的幂
+func NewSPSC[T any](capacity int) *SPSC[T] {
+	size := 1
+	for size < capacity {
+		size <<= 1
+	}
+	return &SPSC[T]{items: make([]T, size), mask: uint64(size - 1)}
+}
+
+// Offer 生产者入队，队列满时返回false
+func (q *SPSC[T]) Offer(val T) bool {
+	tail := q.tail.Load()
+	if tail-q.head.Load() == uint64(len(q.items)) {
+		q.dropped.Add(1)
+		return false
+	}
+	q.items[tail&q.mask] = val
+	q.tail.Store(tail + 1)
+	return true
+}
+
+// Poll 消费者出队
+func (q *SPSC[T]) Poll() (T, bool) {
+	var t T
+	head := q.head.Load()
+	if head == q.tail.Load() {
+		return t, false
+	}
+	val := q.items[head&q.mask]
+	q.items[head&q.mask] = t
+	q.head.Store(head + 1)
+	return val, true
+}
+
+// Drain 消费者出队最多n个元素追加到dst，n<=0时出队全部
+func (q *SPSC[T]) Drain(dst []T, n int) []T {
+	var t T
+	head := q.head.Load()
+	size := int(q.tail.Load() - head)
+	if n <= 0 || n > size {
+		n = size
+	}
+	for index := 0; index < n; index++ {
+		dst = append(dst, q.items[(head+uint64(index))&q.mask])
+		q.items[(head+uint64(index))&q.mask] = t
+	}
+	q.head.Store(head + uint64(n))
+	return dst
+}
+
+// Len 队列里的元素个数，并发时只是一个近似值
+func (q *SPSC[T]) Len() int {
+	// 先读head再读tail，保证tail不小于head
+	head := q.head.Load()
+	return int(q.tail.Load() - head)
+}
+
+func (q *SPSC[T]) Capacity() int {
+	return len(q.items)
+}
+
+// Dropped 队列满时被丢弃的元素个数
+func (q *SPSC[T]) Dropped() uint64 {
+	return q.dropped.Load()
+}
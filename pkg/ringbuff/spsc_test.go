@@ -0,0 +1,84 @@
+package ringbuff_test
+
+import (
+	"accumulation/pkg/ringbuff"
+	"runtime"
+	"testing"
+)
+
+func TestSPSC(t *testing.T) {
+	q := ringbuff.NewSPSC[int](3)
+	if q.Capacity() != 4 {
+		t.Fatalf("expected capacity to be rounded up, got %d", q.Capacity())
+	}
+	for i := 0; i < 4; i++ {
+		if !q.Offer(i) {
+			t.Fatalf("offer %d failed", i)
+		}
+	}
+	if q.Offer(4) || q.Dropped() != 1 {
+		t.Fatalf("expected full queue to drop")
+	}
+	if val, ok := q.Poll(); !ok || val != 0 {
+		t.Fatalf("unexpected poll %d", val)
+	}
+	if got := q.Drain(nil, 2); len(got) != 2 || got[0] != 1 || got[1] != 2 || q.Len() != 1 {
+		t.Fatalf("unexpected drain %v", got)
+	}
+	q.Offer(5)
+	if got := q.Drain(nil, 0); len(got) != 2 || got[0] != 3 || got[1] != 5 {
+		t.Fatalf("unexpected drain %v", got)
+	}
+	if _, ok := q.Poll(); ok {
+		t.Fatalf("expected empty queue")
+	}
+}
+
+func TestSPSCConcurrent(t *testing.T) {
+	q := ringbuff.NewSPSC[int](64)
+	const count = 100000
+	go func() {
+		for i := 0; i < count; {
+			if q.Offer(i) {
+				i++
+				continue
+			}
+			runtime.Gosched()
+		}
+	}()
+	// 消费者必须按入队顺序拿到每一个元素
+	next := 0
+	var batch []int
+	for next < count {
+		batch = q.Drain(batch[:0], 16)
+		if len(batch) == 0 {
+			runtime.Gosched()
+		}
+		for _, val := range batch {
+			if val != next {
+				t.Fatalf("got %d, want %d", val, next)
+			}
+			next++
+		}
+	}
+}
+
+func BenchmarkSPSC(b *testing.B) {
+	q := ringbuff.NewSPSC[int](1024)
+	go func() {
+		for i := 0; i < b.N; {
+			if q.Offer(i) {
+				i++
+				continue
+			}
+			runtime.Gosched()
+		}
+	}()
+	for i := 0; i < b.N; {
+		if _, ok := q.Poll(); ok {
+			i++
+			continue
+		}
+		runtime.Gosched()
+	}
+}